@@ -0,0 +1,1091 @@
+// Command gcode-optimizer removes finishing-depth moves from a Snapmaker
+// Luban G-code program, producing a file that only cuts below a given
+// material allowance.
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/chrisns/snapmaker-cnc-finisher/internal/cli"
+	"github.com/chrisns/snapmaker-cnc-finisher/internal/completion"
+	"github.com/chrisns/snapmaker-cnc-finisher/internal/gcode"
+	"github.com/chrisns/snapmaker-cnc-finisher/internal/logging"
+	"github.com/chrisns/snapmaker-cnc-finisher/internal/optimizer"
+)
+
+// interruptedExitCode is returned when a run is stopped by SIGINT/SIGTERM,
+// following the shell convention of 128+signal (SIGINT is signal 2).
+const interruptedExitCode = 130
+
+// mmPerInch converts an inch value to millimeters, for interpreting
+// -allowance/-tolerance (always parsed in mm) against a file written in
+// G20 (inch) mode.
+const mmPerInch = 25.4
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err := run(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gcode-optimizer:", err)
+		if errors.Is(err, context.Canceled) {
+			os.Exit(interruptedExitCode)
+		}
+		var usageErr *cli.UsageError
+		if errors.As(err, &usageErr) {
+			os.Exit(cli.ExitUsage)
+		}
+		os.Exit(cli.ExitError)
+	}
+}
+
+// completion is an undocumented subcommand ("gcode-optimizer completion
+// bash|zsh|fish") that prints a tab-completion script for the named shell.
+// It is intentionally left out of -h output: it's install-time plumbing,
+// not something a user picks between on each run.
+func runCompletion(args []string, stdout io.Writer) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: gcode-optimizer completion bash|zsh|fish")
+	}
+	script, err := completion.Script(completion.Shell(args[0]))
+	if err != nil {
+		return err
+	}
+	fmt.Fprint(stdout, script)
+	return nil
+}
+
+// runStrategies prints every optimizer.Strategy with a one-line description
+// of its behavior and safety tradeoff, for "gcode-optimizer strategies".
+// The list is sourced from optimizer.Strategies so it can't drift from the
+// actual enum.
+func runStrategies(stdout io.Writer) error {
+	for _, info := range optimizer.Strategies() {
+		if _, err := fmt.Fprintf(stdout, "%-12s %s\n", info.Strategy, info.Description); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	if len(args) > 0 && args[0] == "completion" {
+		return runCompletion(args[1:], stdout)
+	}
+	if len(args) > 0 && args[0] == "strategies" {
+		return runStrategies(stdout)
+	}
+
+	configPath, explicitConfig := extractConfigFlag(args)
+	if configPath == "" {
+		if cwd, err := os.Getwd(); err == nil {
+			if found, ok := cli.DiscoverConfigFile(cwd); ok {
+				configPath = found
+			}
+		}
+	}
+	var cfgDefaults map[string]string
+	if configPath != "" {
+		loaded, err := cli.LoadConfigFile(configPath)
+		switch {
+		case err != nil && os.IsNotExist(err) && !explicitConfig:
+			// Auto-discovery found nothing; that's fine, not an error.
+		case err != nil:
+			return cli.NewUsageError(fmt.Errorf("reading config file %s: %w", configPath, err))
+		default:
+			if err := cli.ValidateConfigKeys(loaded, completion.Flags); err != nil {
+				return cli.NewUsageError(fmt.Errorf("%s: %w", configPath, err))
+			}
+			cfgDefaults = loaded
+		}
+	}
+	cfgFloat := func(key string, fallback float64) (float64, error) {
+		v, err := cli.ConfigFloat64(cfgDefaults, key, fallback)
+		if err != nil {
+			return 0, cli.NewUsageError(fmt.Errorf("%s: %w", configPath, err))
+		}
+		return v, nil
+	}
+	cfgInt := func(key string, fallback int) (int, error) {
+		v, err := cli.ConfigInt(cfgDefaults, key, fallback)
+		if err != nil {
+			return 0, cli.NewUsageError(fmt.Errorf("%s: %w", configPath, err))
+		}
+		return v, nil
+	}
+	defaultTolerance, err := cfgFloat("tolerance", 0)
+	if err != nil {
+		return err
+	}
+	defaultReferenceZ, err := cfgFloat("reference-z", 0)
+	if err != nil {
+		return err
+	}
+	defaultPrecision, err := cfgInt("precision", optimizer.DefaultPrecision)
+	if err != nil {
+		return err
+	}
+	defaultFeedDefault, err := cfgFloat("default-feed", optimizer.DefaultFeedRate)
+	if err != nil {
+		return err
+	}
+
+	fs := flag.NewFlagSet("gcode-optimizer", flag.ExitOnError)
+	fs.String("config", "", "path to a config file (key = value per line) providing defaults for allowance, tolerance, reference, reference-z, precision, default-feed and strategy, overridden by any flag given explicitly; without this, "+strings.Join(cli.ConfigFileNames, " or ")+" is auto-discovered in the current directory")
+	allowance := fs.String("allowance", cli.ConfigString(cfgDefaults, "allowance", "0"),
+		"material thickness to keep above the reference depth, converted to the file's own units. "+
+			"Accepts an optional \"mm\" or \"in\" suffix (e.g. \"1.0mm\", \"0.04in\"); a bare number is mm. "+
+			"With allowance=0, only moves strictly above the reference Z are removed.")
+	tolerance := fs.Float64("tolerance", defaultTolerance,
+		"safety margin, in mm, taken off -allowance (raising the threshold) before classifying a move as shallow. "+
+			"A move within tolerance of the threshold is kept even though it's technically shallow, "+
+			"guarding against surface variation in the source file.")
+	reference := fs.String("reference", cli.ConfigString(cfgDefaults, "reference", "surface"),
+		"what Z is measured against: \"surface\" (Z=0 is the stock surface), "+
+			"\"machine\" (Z=0 is the machine origin), or \"metadata\" (read from the file's header)")
+	referenceZ := fs.Float64("reference-z", defaultReferenceZ,
+		"Z value moves are compared to; meaning depends on -reference")
+	surface := fs.String("surface", "keep",
+		"how to classify a move exactly at the reference plane (e.g. Z=0 with zero -allowance): "+
+			"\"keep\" treats it as deep and keeps it (the default), \"remove\" treats it as shallow and removes it")
+	invert := fs.Bool("invert", false,
+		"keep only the shallow moves and drop the deep ones, producing a skim-only pass instead of a finishing pass")
+	byLayer := fs.Bool("by-layer", false,
+		"break down removed/kept line counts per \"; Layer N\" or tool-change comment")
+	precision := fs.Int("precision", defaultPrecision,
+		"number of decimal places to round generated split-move coordinates to")
+	normalizeEndings := fs.String("normalize-endings", "",
+		"force a consistent line terminator in the output: \"lf\" or \"crlf\"; empty auto-detects from the input's first line")
+	strategy := fs.String("strategy", cli.ConfigString(cfgDefaults, "strategy", string(optimizer.StrategySafe)),
+		"how to handle moves that cross the depth threshold: \"safe\" (classify by start and end Z, never split), "+
+			"\"split\" (split a retracting move at the crossing point and keep only its deep portion), "+
+			"\"aggressive\", or \"all-axes\"")
+	dryRun := fs.Bool("dry-run", false,
+		"report statistics without writing -output")
+	dumpRemoved := fs.String("dump-removed", "",
+		"write every removed move to this file as valid G-code, for visualizing what was cut")
+	keepZeroLength := fs.Bool("keep-zero-length", false,
+		"keep cutting moves that don't change position instead of dropping them by default")
+	rotary := fs.Bool("rotary", false,
+		"experimental: on 4-axis jobs, keep any move that rotates the B axis meaningfully regardless of its Z depth")
+	keepAboveSurface := fs.Bool("keep-above-surface", false,
+		"keep every move above the reference plane regardless of allowance, for engraving jobs referenced above the surface")
+	footerLines := fs.Int("footer-lines", 0,
+		"guarantee the last N lines of the file (the closing retract/spindle-off/program-end sequence) are written byte-exact, regardless of filtering")
+	calibrate := fs.Bool("calibrate", false,
+		"derive the time-saved estimate from the header's estimated_time and the program's total travel distance, instead of literal F values")
+	headerLines := fs.Int("header-lines", gcode.DefaultHeaderScanLines,
+		"how many leading lines to scan for header fields like min_x/max_x before giving up; raise this for tool chains with a long header")
+	maxFeed := fs.Float64("max-feed", 0,
+		"cap every emitted F word at this machine maximum (mm/min), warning when clamping occurs; 0 disables clamping")
+	stamp := fs.Bool("stamp", false,
+		"write an \"optimized_by\" comment as the first line of output")
+	commentPrefix := fs.String("comment-prefix", optimizer.DefaultCommentPrefix,
+		"delimiter for comments the tool writes itself: \";\" or \"(\"")
+	splitSize := fs.Float64("split-size", 0,
+		"split output into multiple files of at most this many megabytes each, named out.1.cnc, out.2.cnc, ...; 0 disables splitting")
+	checksum := fs.Bool("checksum", false,
+		"append a trailing comment with a CRC32 checksum and line count of the emitted lines")
+	stripRedundantFeed := fs.Bool("strip-redundant-feed", false,
+		"drop an emitted F word when it repeats the current modal feed rate, keeping the first F after a G0 or a change of motion command")
+	fixFeed := fs.Bool("fix-feed", false,
+		"replace a motion line's zero or negative F word with the last valid modal feed rate, or -default-feed if none has been seen yet; without this, an invalid F word is only warned about")
+	defaultFeed := fs.Float64("default-feed", defaultFeedDefault,
+		"feed rate -fix-feed falls back to when an invalid F word appears before any valid one; 0 leaves it unfixed in that case")
+	summaryOnly := fs.Bool("summary-only", false,
+		"skip optimizing; read -input and its already-produced -output and print the line/byte comparison stats, for regenerating a report after the fact")
+	smooth := fs.Bool("smooth", false,
+		"insert a ramp move wherever removing a shallow span left a kept cutting move descending more steeply than -ramp-angle, instead of jumping straight down; this changes the toolpath")
+	rampAngle := fs.Float64("ramp-angle", optimizer.DefaultRampAngle,
+		"steepest descent angle, in degrees from horizontal, -smooth allows before inserting a ramp")
+	checkBounds := fs.Bool("check-bounds", false,
+		"warn if any emitted move falls outside the header's declared X/Y work area")
+	springPass := fs.Bool("spring-pass", false,
+		"repeat the final contour's kept moves a second time at the end of output, for a no-stepdown spring pass")
+	var renumber renumberFlag
+	fs.Var(&renumber, "renumber",
+		"rewrite N-words sequentially in the output, keeping the sequence gapless after lines are removed; "+
+			"optional \"start,step\" (default \"0,10\")")
+	movesOnly := fs.Bool("moves-only", false,
+		"output only G0/G1/G2/G3 motion lines, dropping comments and M-codes; the result isn't a runnable program on its own")
+	lineRange := fs.String("range", "",
+		"restrict filtering to source lines \"startline:endline\" (1-based, inclusive); everything outside the range passes through untouched")
+	toolNumber := fs.Int("tool", -1,
+		"restrict filtering to the section of a multi-tool file where this tool (selected by a \"T<N>\" word) is active; other tools' sections pass through untouched; -1 disables the filter")
+	region := fs.String("region", "",
+		"restrict filtering to cutting moves whose endpoint falls inside this rectangle \"x1,y1,x2,y2\" (file units, corners in any order); moves outside it pass through untouched")
+	collapseBlanks := fs.Bool("collapse-blanks", false,
+		"drop a blank line that immediately follows another blank line in the output, for size-sensitive transfers")
+	optimizeRapids := fs.Bool("optimize-rapids", false,
+		"drop a G0 rapid that doesn't actually change position, above the depth threshold only; a G0 that approaches or moves within the stock is always preserved")
+	force := fs.Bool("force", false,
+		"overwrite -output if it already exists")
+	interactive := fs.Bool("interactive", false,
+		"before writing -output, run the analysis, print its statistics, and prompt \"proceed? (y/n)\"; "+
+			"declining leaves -output untouched. Ignored under -dry-run (nothing is written anyway) and skipped by -force")
+	quietWarnings := fs.Bool("quiet-warnings", false,
+		"in a batch run (-input passed more than once), report each distinct warning once with an occurrence count instead of once per file")
+	compare := fs.String("compare", "",
+		"compare this run's line-reduction percentage against a baseline saved from a prior -json run (combined across every -input in a batch run); "+
+			"exit non-zero if it differs by more than -compare-tolerance")
+	compareTolerance := fs.Float64("compare-tolerance", 1.0,
+		"percentage points of reduction difference tolerated by -compare before it's treated as a regression")
+	statsFile := fs.String("stats-file", "",
+		"also write the run summary (same text or -json format as stdout, combined across every -input in a batch run) to this path, for archival separate from the processing output")
+	zAlias := fs.String("z-alias", "",
+		"treat this single axis letter (e.g. \"W\") as depth alongside Z, for controllers that use a secondary quill axis")
+	toolDia := fs.Float64("tool-dia", 0,
+		"cutting tool diameter (file units), used to estimate the swept area of removed moves for reporting; 0 disables the estimate")
+	checkPlunge := fs.Bool("check-plunge", false,
+		"warn when a cutting run's Z reverses direction instead of moving monotonically, a common sign of a CAM bug; read-only, never alters filtering")
+	floor := fs.Float64("floor", 0,
+		"always keep at least this percent of cutting moves, the deepest first, regardless of -allowance or -strategy; 0 disables the backstop")
+	collapseRetracts := fs.Bool("collapse-retracts", false,
+		"remove a pure-Z G0 retract immediately followed by a pure-Z G0 approach back to the same Z with nothing cut between them, wasted motion commonly left behind by removing a shallow span")
+	logFormat := fs.String("log-format", "",
+		"emit per-phase progress as JSON lines to stderr: \"json\"")
+	progressJSON := fs.Bool("progress-json", false,
+		"in a batch run (-input passed more than once), write a {\"done\":N,\"total\":M,\"percent\":P,\"eta_s\":E} line to stderr after each file, for a wrapping GUI to parse")
+	cpuProfile := fs.String("cpuprofile", "", "advanced: write a CPU profile to this path")
+	memProfile := fs.String("memprofile", "", "advanced: write a heap profile to this path")
+	var inputs, outputs stringListFlag
+	fs.Var(&inputs, "input", "input G-code file; pass more than once for a batch run")
+	fs.Var(&outputs, "output", "output G-code file; one per -input, in the same order")
+	recursive := fs.Bool("recursive", false,
+		"treat each -input as a directory and walk it for .cnc/.gcode/.nc files, mirroring the tree under -output-dir; symlinks are never followed")
+	outputDir := fs.String("output-dir", "", "root directory -recursive mirrors the input tree's structure into")
+	version := fs.Bool("version", false, "print version information and exit")
+	listStrategies := fs.Bool("list-strategies", false, "print every -strategy value with a one-line description and exit")
+	jsonOut := fs.Bool("json", false, "print machine-readable JSON instead of text, for -version or the run summary")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *listStrategies {
+		return runStrategies(stdout)
+	}
+
+	if *version {
+		if *jsonOut {
+			text, err := cli.GetVersionJSON()
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(stdout, text)
+		} else {
+			fmt.Fprintln(stdout, cli.GetVersionText())
+		}
+		return nil
+	}
+
+	if *cpuProfile != "" {
+		f, err := os.Create(*cpuProfile)
+		if err != nil {
+			return fmt.Errorf("creating cpu profile: %w", err)
+		}
+		defer f.Close()
+		if err := pprof.StartCPUProfile(f); err != nil {
+			return fmt.Errorf("starting cpu profile: %w", err)
+		}
+		defer pprof.StopCPUProfile()
+	}
+	if *memProfile != "" {
+		defer func() {
+			f, err := os.Create(*memProfile)
+			if err != nil {
+				fmt.Fprintln(stderr, "gcode-optimizer: writing mem profile:", err)
+				return
+			}
+			defer f.Close()
+			runtime.GC()
+			if err := pprof.WriteHeapProfile(f); err != nil {
+				fmt.Fprintln(stderr, "gcode-optimizer: writing mem profile:", err)
+			}
+		}()
+	}
+
+	if *recursive {
+		if *outputDir == "" {
+			return cli.NewUsageError(fmt.Errorf("-recursive requires -output-dir"))
+		}
+		if len(outputs) > 0 {
+			return cli.NewUsageError(fmt.Errorf("-recursive discovers its own -output paths under -output-dir; -output must not be passed"))
+		}
+		var err error
+		inputs, outputs, err = discoverRecursiveInputs(inputs, *outputDir)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(inputs) == 0 || (len(outputs) == 0 && !*dryRun) {
+		return cli.NewUsageError(fmt.Errorf("both -input and -output are required (or pass -dry-run)"))
+	}
+	if !*dryRun && len(outputs) != len(inputs) {
+		return cli.NewUsageError(fmt.Errorf("-output must be passed once per -input (%d -input, %d -output)", len(inputs), len(outputs)))
+	}
+
+	var logger logging.Logger = logging.NopLogger{}
+	if *logFormat == "json" {
+		logger = logging.JSONLogger{W: stderr}
+	}
+
+	allowanceMM, err := cli.ParseAllowance(*allowance)
+	if err != nil {
+		return cli.NewUsageError(err)
+	}
+
+	var refMode optimizer.ReferenceMode
+	switch *reference {
+	case "surface":
+		refMode = optimizer.ReferenceSurface
+	case "machine":
+		refMode = optimizer.ReferenceMachineOrigin
+	case "metadata":
+		refMode = optimizer.ReferenceMetadata
+	default:
+		return cli.NewUsageError(fmt.Errorf("unknown -reference %q: want surface, machine, or metadata", *reference))
+	}
+
+	var surfaceBoundary optimizer.SurfaceBoundary
+	switch *surface {
+	case "keep":
+		surfaceBoundary = optimizer.SurfaceKeep
+	case "remove":
+		surfaceBoundary = optimizer.SurfaceRemove
+	default:
+		return cli.NewUsageError(fmt.Errorf("unknown -surface %q: want keep or remove", *surface))
+	}
+
+	switch optimizer.Strategy(*strategy) {
+	case optimizer.StrategySafe, optimizer.StrategySplit, optimizer.StrategyAggressive, optimizer.StrategyAllAxes:
+	default:
+		return cli.NewUsageError(fmt.Errorf("unknown -strategy %q: want safe, split, aggressive, or all-axes", *strategy))
+	}
+
+	switch *commentPrefix {
+	case ";", "(":
+	default:
+		return cli.NewUsageError(fmt.Errorf("unknown -comment-prefix %q: want \";\" or \"(\"", *commentPrefix))
+	}
+
+	var zAliasLetter byte
+	if *zAlias != "" {
+		if len(*zAlias) != 1 {
+			return cli.NewUsageError(fmt.Errorf("invalid -z-alias %q: want a single axis letter", *zAlias))
+		}
+		zAliasLetter = strings.ToUpper(*zAlias)[0]
+	}
+
+	var dumpFile *os.File
+	if *dumpRemoved != "" {
+		var err error
+		dumpFile, err = os.Create(*dumpRemoved)
+		if err != nil {
+			return err
+		}
+		defer dumpFile.Close()
+	}
+
+	var rangeStart, rangeEnd int
+	var hasRange bool
+	if *lineRange != "" {
+		parts := strings.SplitN(*lineRange, ":", 2)
+		if len(parts) != 2 {
+			return cli.NewUsageError(fmt.Errorf("invalid -range %q: want \"startline:endline\"", *lineRange))
+		}
+		var err error
+		rangeStart, err = strconv.Atoi(strings.TrimSpace(parts[0]))
+		if err != nil {
+			return cli.NewUsageError(fmt.Errorf("invalid -range start %q: %w", parts[0], err))
+		}
+		rangeEnd, err = strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return cli.NewUsageError(fmt.Errorf("invalid -range end %q: %w", parts[1], err))
+		}
+		if rangeStart > rangeEnd {
+			return cli.NewUsageError(fmt.Errorf("invalid -range %q: start must not be after end", *lineRange))
+		}
+		hasRange = true
+	}
+
+	var regionX1, regionY1, regionX2, regionY2 float64
+	var hasRegion bool
+	if *region != "" {
+		parts := strings.Split(*region, ",")
+		if len(parts) != 4 {
+			return cli.NewUsageError(fmt.Errorf("invalid -region %q: want \"x1,y1,x2,y2\"", *region))
+		}
+		values := make([]float64, 4)
+		for i, p := range parts {
+			v, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+			if err != nil {
+				return cli.NewUsageError(fmt.Errorf("invalid -region %q: %w", *region, err))
+			}
+			values[i] = v
+		}
+		regionX1, regionY1, regionX2, regionY2 = values[0], values[1], values[2], values[3]
+		hasRegion = true
+	}
+
+	baseCfg := optimizer.Config{
+		Allowance:          allowanceMM,
+		Tolerance:          *tolerance,
+		Reference:          refMode,
+		ReferenceZ:         *referenceZ,
+		SurfaceBoundary:    surfaceBoundary,
+		Invert:             *invert,
+		ByLayer:            *byLayer,
+		Precision:          *precision,
+		NormalizeEndings:   *normalizeEndings,
+		Strategy:           optimizer.Strategy(*strategy),
+		KeepZeroLength:     *keepZeroLength,
+		Force:              *force,
+		Rotary:             *rotary,
+		KeepAboveSurface:   *keepAboveSurface,
+		FooterLines:        *footerLines,
+		MaxFeed:            *maxFeed,
+		Stamp:              *stamp,
+		CommentPrefix:      *commentPrefix,
+		SplitSize:          int64(*splitSize * 1024 * 1024),
+		Checksum:           *checksum,
+		StripRedundantFeed: *stripRedundantFeed,
+		CheckBounds:        *checkBounds,
+		SpringPass:         *springPass,
+		Renumber:           renumber.enabled,
+		RenumberStart:      renumber.start,
+		RenumberStep:       renumber.step,
+		MovesOnly:          *movesOnly,
+		CollapseBlanks:     *collapseBlanks,
+		OptimizeRapids:     *optimizeRapids,
+		RangeStart:         rangeStart,
+		RangeEnd:           rangeEnd,
+		HasRange:           hasRange,
+		ZAlias:             zAliasLetter,
+		ToolDiameter:       *toolDia,
+		CheckPlunge:        *checkPlunge,
+		CollapseRetracts:   *collapseRetracts,
+		ToolNumber:         *toolNumber,
+		HasToolFilter:      *toolNumber >= 0,
+		RegionX1:           regionX1,
+		RegionY1:           regionY1,
+		RegionX2:           regionX2,
+		RegionY2:           regionY2,
+		HasRegion:          hasRegion,
+		FixFeed:            *fixFeed,
+		DefaultFeed:        *defaultFeed,
+		Smooth:             *smooth,
+		RampAngle:          *rampAngle,
+	}
+	if dumpFile != nil {
+		baseCfg.DumpRemoved = dumpFile
+	}
+
+	tracker := cli.NewWarningTracker()
+	var mergedStats optimizer.Statistics
+	batchStart := time.Now()
+	// One scanner shared across every file in the batch: -interactive reads
+	// a line of stdin per file, and a fresh bufio.Scanner per prompt would
+	// silently discard whatever of the next answer its internal read buffer
+	// had already pulled in past the first line, starving later prompts in
+	// a piped/scripted run.
+	stdinScanner := bufio.NewScanner(stdin)
+	for i, inputPath := range inputs {
+		var outputPath string
+		if i < len(outputs) {
+			outputPath = outputs[i]
+		}
+
+		var stats optimizer.Statistics
+		var err error
+		if *summaryOnly {
+			stats, err = summarizeOnly(inputPath, outputPath)
+		} else {
+			stats, err = processFile(ctx, inputPath, outputPath, *dryRun, *calibrate, *force, *interactive, *splitSize, *headerLines, *floor, baseCfg, stdinScanner, stdout, logger, stderr)
+		}
+		mergedStats.Merge(&stats)
+		if err != nil {
+			if errors.Is(err, errDeclined) {
+				continue
+			}
+			if errors.Is(err, context.Canceled) {
+				fmt.Fprintf(stderr, "interrupted after processing %d input lines (%d kept, %d removed)\n",
+					stats.LinesIn, stats.LinesOut, stats.LinesRemoved)
+			}
+			return err
+		}
+
+		if err := printSummary(stdout, stats, *jsonOut); err != nil {
+			return err
+		}
+		if *byLayer {
+			printSectionTable(stdout, stats.Sections)
+		}
+
+		coded := make(map[string]optimizer.Warning, len(stats.CodedWarnings))
+		for _, w := range stats.CodedWarnings {
+			coded[w.Message] = w
+		}
+		for _, w := range stats.Warnings {
+			if *quietWarnings {
+				tracker.Add(w)
+				continue
+			}
+			if cw, ok := coded[w]; ok {
+				if err := cli.PrintWarning(stderr, cw); err != nil {
+					return err
+				}
+				continue
+			}
+			fmt.Fprintln(stderr, "warning:", w)
+		}
+
+		if *progressJSON {
+			if err := reportProgressJSON(stderr, i+1, len(inputs), batchStart); err != nil {
+				return err
+			}
+		}
+	}
+
+	if *quietWarnings {
+		for _, ws := range tracker.Report() {
+			if ws.Count > 1 {
+				fmt.Fprintf(stderr, "warning: %s (x%d)\n", ws.Message, ws.Count)
+			} else {
+				fmt.Fprintln(stderr, "warning:", ws.Message)
+			}
+		}
+	}
+
+	if *statsFile != "" {
+		f, err := os.Create(*statsFile)
+		if err != nil {
+			return fmt.Errorf("-stats-file: %w", err)
+		}
+		err = printSummary(f, mergedStats, *jsonOut)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("-stats-file: %w", err)
+		}
+	}
+
+	if *compare != "" {
+		if err := compareAgainstBaseline(*compare, mergedStats.ReductionPercent(), *compareTolerance); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// compareAgainstBaseline loads a summary previously saved by -json from
+// baselinePath and fails if its reductionPercent differs from got by more
+// than toleranceFraction percentage points, so a CI run can catch a tool
+// change that silently removes more or fewer lines than before.
+func compareAgainstBaseline(baselinePath string, got, toleranceFraction float64) error {
+	data, err := os.ReadFile(baselinePath)
+	if err != nil {
+		return fmt.Errorf("-compare: reading baseline: %w", err)
+	}
+	var baseline summary
+	if err := json.Unmarshal(data, &baseline); err != nil {
+		return fmt.Errorf("-compare: parsing baseline %s: %w", baselinePath, err)
+	}
+	diff := got - baseline.ReductionPercent
+	if diff < 0 {
+		diff = -diff
+	}
+	if diff > toleranceFraction {
+		return fmt.Errorf("-compare: reduction %.2f%% differs from baseline %.2f%% by more than %.2f%%",
+			got, baseline.ReductionPercent, toleranceFraction)
+	}
+	return nil
+}
+
+// recursiveGCodeExtensions lists the file extensions -recursive treats as
+// G-code, matched case-insensitively.
+var recursiveGCodeExtensions = map[string]bool{".cnc": true, ".gcode": true, ".nc": true}
+
+// discoverRecursiveInputs walks each directory in dirs for files matching
+// recursiveGCodeExtensions and returns parallel input/output slices - one
+// pair per file found - with each output path built by mirroring the
+// file's path relative to its walk root underneath outputDir. Symlinks are
+// never followed: fs.WalkDir already doesn't descend into a symlinked
+// directory, and a symlinked file is skipped outright, so a tree can't walk
+// the tool out of the directory it was pointed at.
+func discoverRecursiveInputs(dirs []string, outputDir string) (inputs, outputs []string, err error) {
+	for _, dir := range dirs {
+		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, walkErr error) error {
+			if walkErr != nil {
+				return walkErr
+			}
+			if d.IsDir() {
+				return nil
+			}
+			if d.Type()&fs.ModeSymlink != 0 {
+				return nil
+			}
+			if !recursiveGCodeExtensions[strings.ToLower(filepath.Ext(path))] {
+				return nil
+			}
+
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			outPath := filepath.Join(outputDir, rel)
+			if err := os.MkdirAll(filepath.Dir(outPath), 0o755); err != nil {
+				return err
+			}
+			inputs = append(inputs, path)
+			outputs = append(outputs, outPath)
+			return nil
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("-recursive: walking %s: %w", dir, err)
+		}
+	}
+	return inputs, outputs, nil
+}
+
+// progressEvent is one -progress-json line: how many of a batch run's files
+// have completed, and an estimate of how long the rest will take based on
+// the average time per file seen so far.
+type progressEvent struct {
+	Done       int     `json:"done"`
+	Total      int     `json:"total"`
+	Percent    float64 `json:"percent"`
+	ETASeconds float64 `json:"eta_s"`
+}
+
+// reportProgressJSON writes one progressEvent to w for the done-th of total
+// files in a batch run, started at start.
+func reportProgressJSON(w io.Writer, done, total int, start time.Time) error {
+	elapsed := time.Since(start).Seconds()
+	var eta float64
+	if done > 0 && done < total {
+		eta = elapsed / float64(done) * float64(total-done)
+	}
+	b, err := json.Marshal(progressEvent{
+		Done:       done,
+		Total:      total,
+		Percent:    float64(done) / float64(total) * 100,
+		ETASeconds: eta,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(b))
+	return err
+}
+
+// summarizeOnly backs -summary-only: it reads inputPath and outputPath, a
+// previously produced optimized file, and reports the comparison stats
+// optimizer.CompareOutputs can derive from line/byte counts alone, without
+// running any of cfg's filtering logic again.
+func summarizeOnly(inputPath, outputPath string) (optimizer.Statistics, error) {
+	var stats optimizer.Statistics
+	if outputPath == "" {
+		return stats, cli.NewUsageError(fmt.Errorf("-summary-only requires a matching -output for every -input"))
+	}
+
+	in, err := os.Open(inputPath)
+	if err != nil {
+		return stats, err
+	}
+	defer in.Close()
+
+	out, err := os.Open(outputPath)
+	if err != nil {
+		return stats, err
+	}
+	defer out.Close()
+
+	return optimizer.CompareOutputs(in, out)
+}
+
+// errDeclined is returned by processFile when -interactive prompted the user
+// and they declined to proceed; run() treats it as a completed (not failed)
+// file, since processFile has already reported the analysis that led to the
+// decision.
+var errDeclined = errors.New("declined by user")
+
+// processFile runs one -input/-output pair through the optimizer: it reads
+// that file's own header metadata and Z range (and, if requested, its own
+// calibrated feed rate) and layers them onto cfg, which otherwise holds the
+// flags shared across every file in a batch run.
+func processFile(ctx context.Context, inputPath, outputPath string, dryRun, calibrate, force, interactive bool, splitSizeMB float64, headerLines int, floorPercent float64, cfg optimizer.Config, stdin *bufio.Scanner, stdout io.Writer, logger logging.Logger, stderr io.Writer) (optimizer.Statistics, error) {
+	var stats optimizer.Statistics
+
+	logger.Log("start", map[string]any{"input": inputPath})
+
+	in, err := os.Open(inputPath)
+	if err != nil {
+		return stats, err
+	}
+	defer in.Close()
+
+	md, err := gcode.ExtractMetadataWithLimit(in, headerLines)
+	if err != nil {
+		return stats, err
+	}
+	if _, err := in.Seek(0, io.SeekStart); err != nil {
+		return stats, err
+	}
+	cfg.Metadata = md
+
+	minZ, maxZ, hasZRange, err := optimizer.ZRange(in)
+	if err != nil {
+		return stats, err
+	}
+	if _, err := in.Seek(0, io.SeekStart); err != nil {
+		return stats, err
+	}
+	cfg.MinZ, cfg.MaxZ, cfg.HasZRange = minZ, maxZ, hasZRange
+
+	if floorPercent > 0 {
+		floorThreshold, hasFloor, err := optimizer.DepthPercentile(in, floorPercent)
+		if err != nil {
+			return stats, err
+		}
+		if _, err := in.Seek(0, io.SeekStart); err != nil {
+			return stats, err
+		}
+		cfg.FloorThreshold, cfg.HasFloor = floorThreshold, hasFloor
+	}
+
+	units, err := optimizer.InitialUnits(in)
+	if err != nil {
+		return stats, err
+	}
+	if _, err := in.Seek(0, io.SeekStart); err != nil {
+		return stats, err
+	}
+	if units == optimizer.UnitsInches {
+		cfg.Allowance /= mmPerInch
+		cfg.Tolerance /= mmPerInch
+	}
+
+	if calibrate {
+		totalDistance, err := optimizer.TotalDistance(in)
+		if err != nil {
+			return stats, err
+		}
+		if _, err := in.Seek(0, io.SeekStart); err != nil {
+			return stats, err
+		}
+		if rate, ok := optimizer.CalibratedFeedRate(totalDistance, md.EstimatedTimeSeconds); ok {
+			cfg.CalibratedFeedRate = rate
+		} else {
+			fmt.Fprintln(stderr, "warning: -calibrate requested but the header has no usable estimated_time; falling back to literal F values")
+		}
+	}
+
+	if dryRun {
+		stats, err = optimizer.Analyze(in, cfg)
+	} else {
+		if interactive && !force {
+			analyzed, err := optimizer.Analyze(in, cfg)
+			if err != nil {
+				return analyzed, err
+			}
+			if _, err := in.Seek(0, io.SeekStart); err != nil {
+				return analyzed, err
+			}
+			if err := printSummary(stdout, analyzed, false); err != nil {
+				return analyzed, err
+			}
+			proceed, err := confirmProceed(stdin, stdout)
+			if err != nil {
+				return analyzed, err
+			}
+			if !proceed {
+				return analyzed, errDeclined
+			}
+		}
+
+		resolvedOutputPath := outputPath
+		if splitSizeMB > 0 {
+			resolvedOutputPath = partPath(outputPath, 1)
+			cfg.NewPart = func(part int) (io.Writer, error) {
+				partFile, err := os.Create(partPath(outputPath, part))
+				if err != nil {
+					return nil, wrapOutputCreateErr(partPath(outputPath, part), err)
+				}
+				return partFile, nil
+			}
+		}
+
+		if absIn, err := filepath.Abs(inputPath); err == nil {
+			if absOut, err := filepath.Abs(resolvedOutputPath); err == nil && absIn == absOut {
+				return stats, fmt.Errorf("input and output both resolve to %s: writing would truncate the file before it's fully read; pass a different -output path", absOut)
+			}
+		}
+
+		if _, statErr := os.Stat(resolvedOutputPath); statErr == nil {
+			if !force {
+				return stats, fmt.Errorf("%s already exists; pass -force to overwrite", resolvedOutputPath)
+			}
+			fmt.Fprintf(stderr, "Overwriting existing file: %s\n", resolvedOutputPath)
+		}
+
+		var out *os.File
+		out, err = os.Create(resolvedOutputPath)
+		if err != nil {
+			return stats, wrapOutputCreateErr(resolvedOutputPath, err)
+		}
+		defer out.Close()
+		stats, err = optimizer.OptimizeContext(ctx, in, out, cfg)
+	}
+	if err != nil {
+		return stats, err
+	}
+
+	logger.Log("done", map[string]any{
+		"input":          inputPath,
+		"lines_in":       stats.LinesIn,
+		"lines_out":      stats.LinesOut,
+		"lines_removed":  stats.LinesRemoved,
+		"lines_added":    stats.AddedLines,
+		"lines_modified": stats.ModifiedLines,
+	})
+
+	return stats, nil
+}
+
+// extractConfigFlag scans args for -config/--config, in either "=value" or
+// separate-argument form, ahead of the main flag.Parse call: -config's own
+// value has to be known before the other flags are defined, since it picks
+// their defaults, and flag.Parse only applies a value after a flag already
+// has one. found is false if -config wasn't given at all, so the caller
+// can fall back to auto-discovery without treating that as an error.
+func extractConfigFlag(args []string) (path string, found bool) {
+	for i, arg := range args {
+		switch {
+		case arg == "-config" || arg == "--config":
+			if i+1 < len(args) {
+				return args[i+1], true
+			}
+		case strings.HasPrefix(arg, "-config="):
+			return strings.TrimPrefix(arg, "-config="), true
+		case strings.HasPrefix(arg, "--config="):
+			return strings.TrimPrefix(arg, "--config="), true
+		}
+	}
+	return "", false
+}
+
+// stringListFlag is a flag.Value that accumulates every occurrence of a
+// repeated flag, e.g. "-input a.gcode -input b.gcode", for batch runs.
+type stringListFlag []string
+
+func (f *stringListFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringListFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// renumberFlag implements flag.Value (and IsBoolFlag, for the optional
+// "=start,step" form) for -renumber: "-renumber" alone enables it with
+// optimizer.Config's defaults, "-renumber=start,step" overrides them.
+type renumberFlag struct {
+	enabled bool
+	start   int
+	step    int
+}
+
+func (f *renumberFlag) String() string {
+	if !f.enabled {
+		return ""
+	}
+	return fmt.Sprintf("%d,%d", f.start, f.step)
+}
+
+func (f *renumberFlag) IsBoolFlag() bool { return true }
+
+func (f *renumberFlag) Set(value string) error {
+	f.enabled = true
+	if value == "" || value == "true" {
+		return nil
+	}
+	parts := strings.SplitN(value, ",", 2)
+	start, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return fmt.Errorf("invalid -renumber start %q: %w", parts[0], err)
+	}
+	f.start = start
+	if len(parts) == 2 {
+		step, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return fmt.Errorf("invalid -renumber step %q: %w", parts[1], err)
+		}
+		f.step = step
+	}
+	return nil
+}
+
+// summary is the JSON shape of printSummary's -json output. Field names
+// mirror Statistics, not the flags that produce them.
+type summary struct {
+	LinesIn          int     `json:"linesIn"`
+	LinesOut         int     `json:"linesOut"`
+	LinesRemoved     int     `json:"linesRemoved"`
+	AddedLines       int     `json:"addedLines"`
+	ModifiedLines    int     `json:"modifiedLines"`
+	ReductionPercent float64 `json:"reductionPercent"`
+
+	OriginalEstimatedSeconds  float64 `json:"originalEstimatedSeconds,omitempty"`
+	OptimizedEstimatedSeconds float64 `json:"optimizedEstimatedSeconds,omitempty"`
+	TimeSavedPercent          float64 `json:"timeSavedPercent,omitempty"`
+
+	RemovedCoverageArea float64 `json:"removedCoverageArea,omitempty"`
+
+	LargestRemovedSpanMoves    int     `json:"largestRemovedSpanMoves,omitempty"`
+	LargestRemovedSpanDistance float64 `json:"largestRemovedSpanDistance,omitempty"`
+	LargestRemovedSpanSeconds  float64 `json:"largestRemovedSpanSeconds,omitempty"`
+
+	RemovedFeedMin     float64 `json:"removedFeedMin,omitempty"`
+	RemovedFeedMax     float64 `json:"removedFeedMax,omitempty"`
+	RemovedFeedAverage float64 `json:"removedFeedAverage,omitempty"`
+
+	KeptByStrategy int `json:"keptByStrategy,omitempty"`
+}
+
+// printSummary reports how a run changed the program: lines removed alone
+// undercounts the diff for modes (split, max-feed clamping, ...) that add
+// or rewrite lines instead of only dropping them, so added and modified
+// counts are reported alongside removed.
+func printSummary(w io.Writer, stats optimizer.Statistics, jsonOut bool) error {
+	if jsonOut {
+		return json.NewEncoder(w).Encode(summary{
+			LinesIn:                    stats.LinesIn,
+			LinesOut:                   stats.LinesOut,
+			LinesRemoved:               stats.LinesRemoved,
+			AddedLines:                 stats.AddedLines,
+			ModifiedLines:              stats.ModifiedLines,
+			ReductionPercent:           stats.ReductionPercent(),
+			OriginalEstimatedSeconds:   stats.OriginalEstimatedSeconds,
+			OptimizedEstimatedSeconds:  stats.OptimizedEstimatedSeconds,
+			TimeSavedPercent:           stats.TimeSavedPercent(),
+			RemovedCoverageArea:        stats.RemovedCoverageArea,
+			LargestRemovedSpanMoves:    stats.LargestRemovedSpanMoves,
+			LargestRemovedSpanDistance: stats.LargestRemovedSpanDistance,
+			LargestRemovedSpanSeconds:  stats.LargestRemovedSpanSeconds,
+			RemovedFeedMin:             stats.RemovedFeedMin,
+			RemovedFeedMax:             stats.RemovedFeedMax,
+			RemovedFeedAverage:         stats.AverageRemovedFeed(),
+			KeptByStrategy:             stats.KeptByStrategy,
+		})
+	}
+	if _, err := fmt.Fprintf(w, "lines: %d in, %d out, %d removed, %d added, %d modified (%.1f%%)\n",
+		stats.LinesIn, stats.LinesOut, stats.LinesRemoved, stats.AddedLines, stats.ModifiedLines, stats.ReductionPercent()); err != nil {
+		return err
+	}
+	if stats.OriginalEstimatedSeconds > 0 {
+		if _, err := fmt.Fprintf(w, "Estimated machining: %s → %s (−%.0f%%)\n",
+			formatDuration(stats.OriginalEstimatedSeconds), formatDuration(stats.OptimizedEstimatedSeconds), stats.TimeSavedPercent()); err != nil {
+			return err
+		}
+	}
+	if stats.RemovedCoverageArea > 0 {
+		if _, err := fmt.Fprintf(w, "Removed coverage: %.1f mm² (air-cutting eliminated)\n", stats.RemovedCoverageArea); err != nil {
+			return err
+		}
+	}
+	if stats.LargestRemovedSpanMoves > 0 {
+		if _, err := fmt.Fprintf(w, "Largest removed span: %d moves, %.1f mm, %.0fs\n",
+			stats.LargestRemovedSpanMoves, stats.LargestRemovedSpanDistance, stats.LargestRemovedSpanSeconds); err != nil {
+			return err
+		}
+	}
+	if stats.RemovedFeedCount > 0 {
+		if _, err := fmt.Fprintf(w, "Removed moves feed range: %.0f–%.0f (avg %.0f) mm/min\n",
+			stats.RemovedFeedMin, stats.RemovedFeedMax, stats.AverageRemovedFeed()); err != nil {
+			return err
+		}
+	}
+	if stats.KeptByStrategy > 0 {
+		if _, err := fmt.Fprintf(w, "Safe strategy preserved %d additional moves\n", stats.KeptByStrategy); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// confirmProceed prints a "proceed? (y/n)" prompt to stdout and reads a
+// single line from stdin, for -interactive. stdin is a scanner shared
+// across the whole batch run, not constructed per call: a fresh
+// bufio.Scanner here would buffer ahead past the first line and strand
+// the rest of the answer for a later file's prompt to never see. Anything
+// but "y"/"yes" (case-insensitively), including EOF, is treated as
+// declining.
+func confirmProceed(stdin *bufio.Scanner, stdout io.Writer) (bool, error) {
+	if _, err := fmt.Fprint(stdout, "proceed? (y/n): "); err != nil {
+		return false, err
+	}
+	if !stdin.Scan() {
+		return false, stdin.Err()
+	}
+	answer := strings.ToLower(strings.TrimSpace(stdin.Text()))
+	return answer == "y" || answer == "yes", nil
+}
+
+// formatDuration renders a duration in whole seconds as "1h 12m", dropping
+// the hours component entirely under an hour (e.g. "12m"), for the
+// "Estimated machining" summary line.
+func formatDuration(seconds float64) string {
+	total := int(seconds + 0.5)
+	h := total / 3600
+	m := (total % 3600) / 60
+	if h > 0 {
+		return fmt.Sprintf("%dh %dm", h, m)
+	}
+	return fmt.Sprintf("%dm", m)
+}
+
+// wrapOutputCreateErr replaces a permission-denied error from os.Create on
+// path with a specific, actionable message, instead of the generic "open
+// X: permission denied" Go produces. Any other error is returned unchanged.
+func wrapOutputCreateErr(path string, err error) error {
+	if os.IsPermission(err) {
+		return fmt.Errorf("cannot write output file %s: permission denied — check directory permissions or choose another path", path)
+	}
+	return err
+}
+
+// partPath inserts ".N" before base's extension, e.g. "out.cnc" and part 2
+// become "out.2.cnc", for -split-size output parts.
+func partPath(base string, part int) string {
+	ext := filepath.Ext(base)
+	stem := strings.TrimSuffix(base, ext)
+	return fmt.Sprintf("%s.%d%s", stem, part, ext)
+}
+
+func printSectionTable(w io.Writer, sections []optimizer.SectionStats) {
+	fmt.Fprintf(w, "%-24s %8s %8s\n", "section", "kept", "removed")
+	for _, s := range sections {
+		fmt.Fprintf(w, "%-24s %8d %8d\n", s.Name, s.LinesKept, s.LinesRemoved)
+	}
+}
@@ -0,0 +1,878 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chrisns/snapmaker-cnc-finisher/internal/cli"
+	"github.com/chrisns/snapmaker-cnc-finisher/internal/optimizer"
+)
+
+// TestRun_RecursiveWalksNestedDirectoryAndMirrorsOutputTree builds a small
+// nested directory of fixtures (including a non-gcode file that must be
+// skipped and a subdirectory) and checks -recursive finds every .gcode/.cnc
+// file and mirrors the tree structure under -output-dir.
+func TestRun_RecursiveWalksNestedDirectoryAndMirrorsOutputTree(t *testing.T) {
+	root := t.TempDir()
+	outDir := t.TempDir()
+
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	must(os.WriteFile(filepath.Join(root, "top.gcode"), []byte("G1 X1 Z1 F300\n"), 0o644))
+	must(os.MkdirAll(filepath.Join(root, "sub"), 0o755))
+	must(os.WriteFile(filepath.Join(root, "sub", "nested.cnc"), []byte("G1 X1 Z1 F300\n"), 0o644))
+	must(os.WriteFile(filepath.Join(root, "sub", "notes.txt"), []byte("not gcode\n"), 0o644))
+
+	var stdout, stderr bytes.Buffer
+	err := run(context.Background(), []string{
+		"-input", root, "-recursive", "-output-dir", outDir,
+	}, strings.NewReader(""), &stdout, &stderr)
+	if err != nil {
+		t.Fatalf("run: %v (stderr: %s)", err, stderr.String())
+	}
+
+	for _, rel := range []string{"top.gcode", filepath.Join("sub", "nested.cnc")} {
+		if _, err := os.Stat(filepath.Join(outDir, rel)); err != nil {
+			t.Errorf("expected mirrored output at %s: %v", rel, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "sub", "notes.txt")); err == nil {
+		t.Error("notes.txt is not G-code and should not have been processed")
+	}
+}
+
+func TestRun_RecursiveRequiresOutputDir(t *testing.T) {
+	root := t.TempDir()
+	var stdout, stderr bytes.Buffer
+	err := run(context.Background(), []string{"-input", root, "-recursive"}, strings.NewReader(""), &stdout, &stderr)
+	if err == nil {
+		t.Fatal("expected an error when -recursive is passed without -output-dir")
+	}
+	var usageErr *cli.UsageError
+	if !errors.As(err, &usageErr) {
+		t.Fatalf("error = %v, want a *cli.UsageError", err)
+	}
+}
+
+func TestRun_ForceOverwriteNoticesStderr(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.gcode")
+	output := filepath.Join(dir, "out.gcode")
+
+	if err := os.WriteFile(input, []byte("G0 Z5\nG1 Z-1 F300\n"), 0o644); err != nil {
+		t.Fatalf("WriteFile input: %v", err)
+	}
+	if err := os.WriteFile(output, []byte("stale"), 0o644); err != nil {
+		t.Fatalf("WriteFile output: %v", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	err := run(context.Background(), []string{"-input", input, "-output", output, "-force"}, strings.NewReader(""), &stdout, &stderr)
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	if !strings.Contains(stderr.String(), "Overwriting existing file: "+output) {
+		t.Fatalf("stderr = %q, want overwrite notice", stderr.String())
+	}
+}
+
+func TestRun_InteractiveDeclinedLeavesOutputUntouched(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.gcode")
+	output := filepath.Join(dir, "out.gcode")
+
+	if err := os.WriteFile(input, []byte("G0 Z5\nG1 Z-1 F300\n"), 0o644); err != nil {
+		t.Fatalf("WriteFile input: %v", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	err := run(context.Background(), []string{"-input", input, "-output", output, "-interactive"}, strings.NewReader("n\n"), &stdout, &stderr)
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	if !strings.Contains(stdout.String(), "proceed? (y/n): ") {
+		t.Fatalf("stdout = %q, want the proceed prompt", stdout.String())
+	}
+	if !strings.Contains(stdout.String(), "lines:") {
+		t.Fatalf("stdout = %q, want the analysis printed before the prompt", stdout.String())
+	}
+	if _, err := os.Stat(output); !os.IsNotExist(err) {
+		t.Fatalf("output file exists after declining, want it never created")
+	}
+}
+
+func TestRun_InteractiveAcceptedWritesOutput(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.gcode")
+	output := filepath.Join(dir, "out.gcode")
+
+	if err := os.WriteFile(input, []byte("G0 Z5\nG1 Z-1 F300\n"), 0o644); err != nil {
+		t.Fatalf("WriteFile input: %v", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	err := run(context.Background(), []string{"-input", input, "-output", output, "-interactive"}, strings.NewReader("y\n"), &stdout, &stderr)
+	if err != nil {
+		t.Fatalf("run: %v (stderr: %s)", err, stderr.String())
+	}
+
+	if _, err := os.Stat(output); err != nil {
+		t.Fatalf("output file missing after accepting: %v", err)
+	}
+}
+
+func TestRun_InteractiveSkippedByForce(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.gcode")
+	output := filepath.Join(dir, "out.gcode")
+
+	if err := os.WriteFile(input, []byte("G0 Z5\nG1 Z-1 F300\n"), 0o644); err != nil {
+		t.Fatalf("WriteFile input: %v", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	// stdin has nothing to read; if -force didn't skip the prompt, the
+	// missing "y" would make this decline and leave output unwritten.
+	err := run(context.Background(), []string{"-input", input, "-output", output, "-interactive", "-force"}, strings.NewReader(""), &stdout, &stderr)
+	if err != nil {
+		t.Fatalf("run: %v (stderr: %s)", err, stderr.String())
+	}
+
+	if _, err := os.Stat(output); err != nil {
+		t.Fatalf("output file missing when -force should have skipped the prompt: %v", err)
+	}
+}
+
+func TestRun_InteractiveBatchReadsOneAnswerPerFile(t *testing.T) {
+	dir := t.TempDir()
+	input1 := filepath.Join(dir, "in1.gcode")
+	input2 := filepath.Join(dir, "in2.gcode")
+	output1 := filepath.Join(dir, "out1.gcode")
+	output2 := filepath.Join(dir, "out2.gcode")
+
+	for _, p := range []string{input1, input2} {
+		if err := os.WriteFile(p, []byte("G0 Z5\nG1 Z-1 F300\n"), 0o644); err != nil {
+			t.Fatalf("WriteFile %s: %v", p, err)
+		}
+	}
+
+	var stdout, stderr bytes.Buffer
+	err := run(context.Background(), []string{
+		"-input", input1, "-output", output1,
+		"-input", input2, "-output", output2,
+		"-interactive",
+	}, strings.NewReader("y\ny\n"), &stdout, &stderr)
+	if err != nil {
+		t.Fatalf("run: %v (stderr: %s)", err, stderr.String())
+	}
+
+	if _, err := os.Stat(output1); err != nil {
+		t.Fatalf("output1 missing after accepting: %v", err)
+	}
+	if _, err := os.Stat(output2); err != nil {
+		t.Fatalf("output2 missing after accepting the second file's prompt, want the second \"y\" still available to read: %v", err)
+	}
+}
+
+func TestRun_JSONLogFormatEmitsParseableEvents(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.gcode")
+	output := filepath.Join(dir, "out.gcode")
+	os.WriteFile(input, []byte("G0 Z5\n"), 0o644)
+
+	var stdout, stderr bytes.Buffer
+	if err := run(context.Background(), []string{"-input", input, "-output", output, "-log-format", "json"}, strings.NewReader(""), &stdout, &stderr); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(stderr.String()), "\n")
+	if len(lines) < 2 {
+		t.Fatalf("expected at least 2 log lines, got %q", stderr.String())
+	}
+	for _, line := range lines {
+		var event map[string]any
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatalf("log line %q did not parse as JSON: %v", line, err)
+		}
+		if _, ok := event["phase"]; !ok {
+			t.Fatalf("log line %q missing phase field", line)
+		}
+	}
+}
+
+func TestRun_ProgressJSONEmitsOneLinePerFileInABatchRun(t *testing.T) {
+	dir := t.TempDir()
+	input1 := filepath.Join(dir, "a.gcode")
+	input2 := filepath.Join(dir, "b.gcode")
+	output1 := filepath.Join(dir, "a.out.gcode")
+	output2 := filepath.Join(dir, "b.out.gcode")
+	os.WriteFile(input1, []byte("G0 Z5\n"), 0o644)
+	os.WriteFile(input2, []byte("G0 Z5\n"), 0o644)
+
+	var stdout, stderr bytes.Buffer
+	args := []string{
+		"-input", input1, "-output", output1,
+		"-input", input2, "-output", output2,
+		"-progress-json",
+	}
+	if err := run(context.Background(), args, strings.NewReader(""), &stdout, &stderr); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(stderr.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 progress lines, got %d: %q", len(lines), stderr.String())
+	}
+	var last struct {
+		Done    int     `json:"done"`
+		Total   int     `json:"total"`
+		Percent float64 `json:"percent"`
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &last); err != nil {
+		t.Fatalf("progress line %q did not parse as JSON: %v", lines[1], err)
+	}
+	if last.Done != 2 || last.Total != 2 || last.Percent != 100 {
+		t.Fatalf("last progress event = %+v, want done=2 total=2 percent=100", last)
+	}
+}
+
+func TestRun_SummaryOnlyReportsStatsFromAPreMadeOutputWithoutReoptimizing(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.gcode")
+	output := filepath.Join(dir, "out.gcode")
+	os.WriteFile(input, []byte("G1 X1 Y0 Z5 F300\nG1 X1 Y0 Z-5 F300\n"), 0o644)
+	// A pre-made "optimized" file, written independently of this run's
+	// -allowance/-strategy flags, to prove -summary-only never re-runs them.
+	os.WriteFile(output, []byte("G1 X1 Y0 Z-5 F300\n"), 0o644)
+
+	var stdout, stderr bytes.Buffer
+	args := []string{"-input", input, "-output", output, "-summary-only", "-json"}
+	if err := run(context.Background(), args, strings.NewReader(""), &stdout, &stderr); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	var got struct {
+		LinesIn          int     `json:"LinesIn"`
+		LinesOut         int     `json:"LinesOut"`
+		LinesRemoved     int     `json:"LinesRemoved"`
+		ReductionPercent float64 `json:"ReductionPercent"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &got); err != nil {
+		t.Fatalf("summary JSON did not parse: %v (%q)", err, stdout.String())
+	}
+	if got.LinesIn != 2 || got.LinesOut != 1 || got.LinesRemoved != 1 {
+		t.Fatalf("summary = %+v, want LinesIn=2 LinesOut=1 LinesRemoved=1", got)
+	}
+}
+
+func TestRun_SummaryOnlyRequiresAMatchingOutput(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.gcode")
+	os.WriteFile(input, []byte("G1 X1 Y0 Z-5 F300\n"), 0o644)
+
+	// -dry-run normally allows -input with no -output; -summary-only has no
+	// use for that combination, since it reads -output rather than writing
+	// it, so it must reject a missing -output on its own.
+	var stdout, stderr bytes.Buffer
+	err := run(context.Background(), []string{"-input", input, "-summary-only", "-dry-run"}, strings.NewReader(""), &stdout, &stderr)
+	var usageErr *cli.UsageError
+	if !errors.As(err, &usageErr) {
+		t.Fatalf("err = %v, want a *cli.UsageError", err)
+	}
+}
+
+// slowReader emits one line at a time with a small delay, so a run against
+// it can reliably be interrupted mid-stream.
+type slowReader struct {
+	lines []string
+	i     int
+	delay time.Duration
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	if r.i >= len(r.lines) {
+		return 0, io.EOF
+	}
+	time.Sleep(r.delay)
+	n := copy(p, r.lines[r.i]+"\n")
+	r.i++
+	return n, nil
+}
+
+func TestRun_CancelFlushesPartialOutput(t *testing.T) {
+	dir := t.TempDir()
+	output := filepath.Join(dir, "out.gcode")
+	outFile, err := os.Create(output)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	defer outFile.Close()
+
+	lines := make([]string, 50)
+	for i := range lines {
+		lines[i] = "G1 Z-1 F300"
+	}
+	in := &slowReader{lines: lines, delay: 5 * time.Millisecond}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+
+	stats, err := optimizer.OptimizeContext(ctx, in, outFile, optimizer.Config{})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want context.DeadlineExceeded", err)
+	}
+	if stats.LinesIn == 0 || stats.LinesIn >= len(lines) {
+		t.Fatalf("LinesIn = %d, want a partial count between 1 and %d", stats.LinesIn, len(lines)-1)
+	}
+	got, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if !strings.Contains(string(got), "G1 Z-1 F300") {
+		t.Fatalf("output = %q, want the lines processed before cancellation flushed to it", got)
+	}
+}
+
+func TestRun_VersionJSONUnmarshalsWithVersionField(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if err := run(context.Background(), []string{"-version", "-json"}, strings.NewReader(""), &stdout, &stderr); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	var info struct {
+		Version string `json:"version"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &info); err != nil {
+		t.Fatalf("Unmarshal(%q): %v", stdout.String(), err)
+	}
+	if info.Version != cli.Version {
+		t.Fatalf("version = %q, want %q", info.Version, cli.Version)
+	}
+}
+
+func TestRun_CompletionCoversStrategyValues(t *testing.T) {
+	for _, shell := range []string{"bash", "zsh", "fish"} {
+		var stdout, stderr bytes.Buffer
+		if err := run(context.Background(), []string{"completion", shell}, strings.NewReader(""), &stdout, &stderr); err != nil {
+			t.Fatalf("run(completion %s): %v", shell, err)
+		}
+		got := stdout.String()
+		if got == "" {
+			t.Fatalf("completion %s: output is empty", shell)
+		}
+		for _, value := range []string{"safe", "split", "aggressive", "all-axes"} {
+			if !strings.Contains(got, value) {
+				t.Fatalf("completion %s: output missing strategy value %q:\n%s", shell, value, got)
+			}
+		}
+	}
+}
+
+func TestRun_CompletionRejectsUnknownShell(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if err := run(context.Background(), []string{"completion", "powershell"}, strings.NewReader(""), &stdout, &stderr); err == nil {
+		t.Fatal("expected error for unknown shell")
+	}
+}
+
+func TestRun_RefusesOverwriteWithoutForce(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.gcode")
+	output := filepath.Join(dir, "out.gcode")
+
+	os.WriteFile(input, []byte("G0 Z5\n"), 0o644)
+	os.WriteFile(output, []byte("stale"), 0o644)
+
+	var stdout, stderr bytes.Buffer
+	err := run(context.Background(), []string{"-input", input, "-output", output}, strings.NewReader(""), &stdout, &stderr)
+	if err == nil {
+		t.Fatal("expected error refusing to overwrite without -force")
+	}
+}
+
+func TestRun_RefusesSelfOverwriteAndLeavesInputUntouched(t *testing.T) {
+	dir := t.TempDir()
+	same := filepath.Join(dir, "same.cnc")
+	original := []byte("G1 X1 Z-1 F300\n")
+	os.WriteFile(same, original, 0o644)
+
+	var stdout, stderr bytes.Buffer
+	err := run(context.Background(), []string{"-input", same, "-output", same, "-force"}, strings.NewReader(""), &stdout, &stderr)
+	if err == nil {
+		t.Fatal("expected error refusing to use the same path for -input and -output")
+	}
+
+	got, readErr := os.ReadFile(same)
+	if readErr != nil {
+		t.Fatalf("ReadFile: %v", readErr)
+	}
+	if string(got) != string(original) {
+		t.Fatalf("input was modified: got %q, want untouched %q", got, original)
+	}
+}
+
+func TestRun_QuietWarningsDedupesAcrossBatchWithCount(t *testing.T) {
+	dir := t.TempDir()
+	input1 := filepath.Join(dir, "a.gcode")
+	input2 := filepath.Join(dir, "b.gcode")
+	output1 := filepath.Join(dir, "a.out.gcode")
+	output2 := filepath.Join(dir, "b.out.gcode")
+
+	os.WriteFile(input1, []byte("G1 X1 Z-1 F900\n"), 0o644)
+	os.WriteFile(input2, []byte("G1 X2 Z-1 F900\n"), 0o644)
+
+	var stdout, stderr bytes.Buffer
+	err := run(context.Background(), []string{
+		"-input", input1, "-output", output1,
+		"-input", input2, "-output", output2,
+		"-max-feed", "500", "-quiet-warnings",
+	}, strings.NewReader(""), &stdout, &stderr)
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	want := "warning: clamped one or more F words to -max-feed=500 (x2)"
+	if !strings.Contains(stderr.String(), want) {
+		t.Fatalf("stderr = %q, want a single deduped warning %q", stderr.String(), want)
+	}
+	if strings.Count(stderr.String(), "clamped one or more F words") != 1 {
+		t.Fatalf("stderr = %q, want the warning to appear exactly once", stderr.String())
+	}
+}
+
+func TestRun_RenumberFlagGaplessAfterRemoval(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.gcode")
+	output := filepath.Join(dir, "out.gcode")
+	// The first move crosses out to shallow from the initial deep state and
+	// the last crosses back deep, so both are kept entirely; the middle move
+	// stays shallow throughout and is removed, so renumbering has to close
+	// the gap it leaves between the two kept lines.
+	os.WriteFile(input, []byte("G1 X1 Z1 F300\nG1 X1.5 Z1 F300\nG1 X2 Z-1 F300\n"), 0o644)
+
+	var stdout, stderr bytes.Buffer
+	if err := run(context.Background(), []string{"-input", input, "-output", output, "-renumber"}, strings.NewReader(""), &stdout, &stderr); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	got, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	want := "N0 G1 X1 Z1 F300\nN10 G1 X2 Z-1 F300\n"
+	if string(got) != want {
+		t.Fatalf("output = %q, want %q", got, want)
+	}
+}
+
+func TestRun_AllowanceInchSuffixConvertsToFileUnits(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.gcode")
+	output := filepath.Join(dir, "out.gcode")
+	// File is in G20 (inch) mode; a move at Z=0.03in is shallower than the
+	// 0.04in allowance, so it should be removed once 0.04in is correctly
+	// converted into the file's own inch units rather than left as 0.04mm.
+	os.WriteFile(input, []byte("G20\nG1 X1 Z0.03 F300\nG1 X2 Z-1 F300\n"), 0o644)
+
+	var stdout, stderr bytes.Buffer
+	if err := run(context.Background(), []string{"-input", input, "-output", output, "-allowance", "0.04in"}, strings.NewReader(""), &stdout, &stderr); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	got, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if strings.Contains(string(got), "Z0.03") {
+		t.Fatalf("output = %q, want the shallow Z0.03 move removed", got)
+	}
+	if !strings.Contains(string(got), "Z-1") {
+		t.Fatalf("output = %q, want the deep Z-1 move kept", got)
+	}
+}
+
+func TestRun_ConfigFileProvidesDefaultStrategyOverriddenByExplicitFlag(t *testing.T) {
+	// CrossingLeave fixture from the strategy tests: StrategySplit keeps
+	// only the deep portion of the first move (landing at X5), corrected
+	// position then carried into the second move's own split. StrategySafe
+	// (the built-in default) never splits, so X5.0000 never appears.
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.gcode")
+	os.WriteFile(input, []byte("G0 X0 Z-1\nG1 X10 Z1 F300\nG1 X-5 Z5 F300\n"), 0o644)
+	configFile := filepath.Join(dir, "snapmaker.toml")
+	os.WriteFile(configFile, []byte("strategy = split\n"), 0o644)
+
+	output := filepath.Join(dir, "out.gcode")
+	var stdout, stderr bytes.Buffer
+	if err := run(context.Background(), []string{"-config", configFile, "-input", input, "-output", output}, strings.NewReader(""), &stdout, &stderr); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	got, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if !strings.Contains(string(got), "X5.0000") {
+		t.Fatalf("output = %q, want the config's strategy=split default applied", got)
+	}
+
+	// An explicit -strategy overrides the config file's default.
+	output2 := filepath.Join(dir, "out2.gcode")
+	stdout.Reset()
+	stderr.Reset()
+	if err := run(context.Background(), []string{"-config", configFile, "-strategy", "safe", "-input", input, "-output", output2}, strings.NewReader(""), &stdout, &stderr); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	got2, err := os.ReadFile(output2)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if strings.Contains(string(got2), "X5.0000") {
+		t.Fatalf("output = %q, want the explicit -strategy=safe to override the config file", got2)
+	}
+}
+
+func TestRun_ConfigFileRejectsUnknownKey(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.gcode")
+	output := filepath.Join(dir, "out.gcode")
+	os.WriteFile(input, []byte("G1 X1 Z-1 F300\n"), 0o644)
+	configFile := filepath.Join(dir, "snapmaker.toml")
+	os.WriteFile(configFile, []byte("not-a-real-flag = 1\n"), 0o644)
+
+	var stdout, stderr bytes.Buffer
+	err := run(context.Background(), []string{"-config", configFile, "-input", input, "-output", output}, strings.NewReader(""), &stdout, &stderr)
+	if err == nil {
+		t.Fatal("run with an unknown config key = nil error, want one")
+	}
+	var usageErr *cli.UsageError
+	if !errors.As(err, &usageErr) {
+		t.Fatalf("run error = %v (%T), want a *cli.UsageError", err, err)
+	}
+}
+
+func TestRun_EmptyInputReportsZeroPercentNotNaN(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.gcode")
+	output := filepath.Join(dir, "out.gcode")
+	os.WriteFile(input, []byte(""), 0o644)
+
+	var stdout, stderr bytes.Buffer
+	if err := run(context.Background(), []string{"-input", input, "-output", output, "-json"}, strings.NewReader(""), &stdout, &stderr); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	var got summary
+	if err := json.Unmarshal(stdout.Bytes(), &got); err != nil {
+		t.Fatalf("Unmarshal(%q): %v", stdout.String(), err)
+	}
+	if got.ReductionPercent != 0 {
+		t.Fatalf("ReductionPercent = %v, want 0", got.ReductionPercent)
+	}
+}
+
+func TestRun_StrategiesListsEveryStrategyValue(t *testing.T) {
+	for _, args := range [][]string{{"strategies"}, {"-list-strategies"}} {
+		var stdout, stderr bytes.Buffer
+		if err := run(context.Background(), args, strings.NewReader(""), &stdout, &stderr); err != nil {
+			t.Fatalf("run(%v): %v", args, err)
+		}
+		got := stdout.String()
+		for _, value := range []string{"safe", "split", "aggressive", "all-axes"} {
+			if !strings.Contains(got, value) {
+				t.Fatalf("run(%v): output missing strategy value %q:\n%s", args, value, got)
+			}
+		}
+	}
+}
+
+func TestRun_RangeFlagRejectsMalformedValue(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.gcode")
+	output := filepath.Join(dir, "out.gcode")
+	os.WriteFile(input, []byte("G1 X1 Z1 F300\n"), 0o644)
+
+	var stdout, stderr bytes.Buffer
+	err := run(context.Background(), []string{"-input", input, "-output", output, "-range", "oops"}, strings.NewReader(""), &stdout, &stderr)
+	if err == nil {
+		t.Fatal("expected error for malformed -range")
+	}
+	var usageErr *cli.UsageError
+	if !errors.As(err, &usageErr) {
+		t.Fatalf("error = %v, want a *cli.UsageError so main maps it to cli.ExitUsage", err)
+	}
+}
+
+func TestRun_RegionFlagRejectsMalformedValue(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.gcode")
+	output := filepath.Join(dir, "out.gcode")
+	os.WriteFile(input, []byte("G1 X1 Z1 F300\n"), 0o644)
+
+	var stdout, stderr bytes.Buffer
+	err := run(context.Background(), []string{"-input", input, "-output", output, "-region", "oops"}, strings.NewReader(""), &stdout, &stderr)
+	if err == nil {
+		t.Fatal("expected error for malformed -region")
+	}
+	var usageErr *cli.UsageError
+	if !errors.As(err, &usageErr) {
+		t.Fatalf("error = %v, want a *cli.UsageError so main maps it to cli.ExitUsage", err)
+	}
+}
+
+// TestRun_UsageErrorsAreDistinguishableFromOperationalErrors checks that
+// every command-line mistake run() can reject (as opposed to an
+// operational failure like a missing input file) returns an error
+// wrapped in *cli.UsageError, so main exits with cli.ExitUsage rather than
+// cli.ExitError - the two are meant to be told apart by a script invoking
+// this binary.
+func TestRun_UsageErrorsAreDistinguishableFromOperationalErrors(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.gcode")
+	output := filepath.Join(dir, "out.gcode")
+	os.WriteFile(input, []byte("G1 X1 Z1 F300\n"), 0o644)
+
+	cases := []struct {
+		name string
+		args []string
+	}{
+		{"missing input/output", []string{}},
+		{"mismatched output count", []string{"-input", input, "-input", input, "-output", output}},
+		{"unknown reference", []string{"-input", input, "-output", output, "-reference", "bogus"}},
+		{"unknown strategy", []string{"-input", input, "-output", output, "-strategy", "bogus"}},
+		{"unknown comment prefix", []string{"-input", input, "-output", output, "-comment-prefix", "#"}},
+		{"multi-char z-alias", []string{"-input", input, "-output", output, "-z-alias", "WW"}},
+		{"malformed range", []string{"-input", input, "-output", output, "-range", "oops"}},
+		{"invalid allowance", []string{"-input", input, "-output", output, "-allowance", "oops"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			err := run(context.Background(), c.args, strings.NewReader(""), &stdout, &stderr)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			var usageErr *cli.UsageError
+			if !errors.As(err, &usageErr) {
+				t.Fatalf("error = %v, want a *cli.UsageError", err)
+			}
+		})
+	}
+
+	// A genuine operational failure - the input file doesn't exist - must
+	// NOT be a UsageError, since the command line itself was well-formed.
+	t.Run("missing input file is not a usage error", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		err := run(context.Background(), []string{"-input", filepath.Join(dir, "missing.gcode"), "-output", output}, strings.NewReader(""), &stdout, &stderr)
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+		var usageErr *cli.UsageError
+		if errors.As(err, &usageErr) {
+			t.Fatal("missing input file was reported as a UsageError, want an operational error")
+		}
+	})
+}
+
+func TestRun_PermissionDeniedOutputDirGivesActionableMessage(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("permission bits don't apply the same way on Windows")
+	}
+	if os.Geteuid() == 0 {
+		t.Skip("running as root, which ignores permission bits")
+	}
+
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.gcode")
+	os.WriteFile(input, []byte("G1 X1 Z1 F300\n"), 0o644)
+
+	readOnlyDir := filepath.Join(dir, "readonly")
+	if err := os.Mkdir(readOnlyDir, 0o555); err != nil {
+		t.Fatalf("Mkdir: %v", err)
+	}
+	output := filepath.Join(readOnlyDir, "out.gcode")
+
+	var stdout, stderr bytes.Buffer
+	err := run(context.Background(), []string{"-input", input, "-output", output}, strings.NewReader(""), &stdout, &stderr)
+	if err == nil {
+		t.Fatal("expected an error writing into a read-only directory")
+	}
+	if !strings.Contains(err.Error(), "permission denied") || !strings.Contains(err.Error(), "check directory permissions") {
+		t.Fatalf("error = %q, want an actionable permission-denied message", err.Error())
+	}
+}
+
+func TestRun_SummaryReportsEstimatedMachiningTime(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.gcode")
+	output := filepath.Join(dir, "out.gcode")
+	// Z0->Z5 crosses out of material (kept, 5 units); Z5->Z15 stays
+	// shallow (removed, 10 units); Z15->Z0 crosses back into material
+	// (kept, 15 units, Z=0 itself counting as deep under the default
+	// boundary). 30 units total at F300: 2s removed + 4s kept = 6s
+	// original, 4s optimized.
+	os.WriteFile(input, []byte("G1 Z5 F300\nG1 Z15 F300\nG1 Z0 F300\n"), 0o644)
+
+	var stdout, stderr bytes.Buffer
+	if err := run(context.Background(), []string{"-input", input, "-output", output}, strings.NewReader(""), &stdout, &stderr); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "Estimated machining:") {
+		t.Fatalf("stdout missing estimated machining line: %q", stdout.String())
+	}
+
+	stdout.Reset()
+	stderr.Reset()
+	if err := run(context.Background(), []string{"-input", input, "-output", output, "-json", "-force"}, strings.NewReader(""), &stdout, &stderr); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	var got summary
+	if err := json.Unmarshal(stdout.Bytes(), &got); err != nil {
+		t.Fatalf("Unmarshal(%q): %v", stdout.String(), err)
+	}
+	if got.OriginalEstimatedSeconds != 6 || got.OptimizedEstimatedSeconds != 4 {
+		t.Fatalf("got original=%v optimized=%v, want 6 and 4", got.OriginalEstimatedSeconds, got.OptimizedEstimatedSeconds)
+	}
+}
+
+func TestRun_CompareAgainstMatchingBaselineSucceeds(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.gcode")
+	output := filepath.Join(dir, "out.gcode")
+	baseline := filepath.Join(dir, "baseline.json")
+	// Z5 crosses out of material (kept); Z3 and Z2 stay shallow (removed);
+	// Z-1 crosses back into material (kept): 2 of 4 lines removed, 50%.
+	os.WriteFile(input, []byte("G0 Z5\nG1 Z3 F300\nG1 Z2 F300\nG1 Z-1 F300\n"), 0o644)
+	os.WriteFile(baseline, []byte(`{"linesIn":4,"linesOut":2,"linesRemoved":2,"reductionPercent":50}`), 0o644)
+
+	var stdout, stderr bytes.Buffer
+	if err := run(context.Background(), []string{"-input", input, "-output", output, "-compare", baseline}, strings.NewReader(""), &stdout, &stderr); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+}
+
+func TestRun_CompareAgainstMismatchingBaselineFails(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.gcode")
+	output := filepath.Join(dir, "out.gcode")
+	baseline := filepath.Join(dir, "baseline.json")
+	os.WriteFile(input, []byte("G0 Z5\nG1 Z2 F300\nG1 Z-1 F300\nG1 Z0.5 F300\n"), 0o644)
+	os.WriteFile(baseline, []byte(`{"linesIn":4,"linesOut":1,"linesRemoved":3,"reductionPercent":90}`), 0o644)
+
+	var stdout, stderr bytes.Buffer
+	err := run(context.Background(), []string{"-input", input, "-output", output, "-compare", baseline}, strings.NewReader(""), &stdout, &stderr)
+	if err == nil {
+		t.Fatal("expected an error for a reduction percentage far from baseline")
+	}
+	if !strings.Contains(err.Error(), "-compare") {
+		t.Fatalf("error = %q, want it to mention -compare", err.Error())
+	}
+}
+
+func TestRun_StatsFileWritesSummarySeparatelyFromStdout(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.gcode")
+	output := filepath.Join(dir, "out.gcode")
+	statsFile := filepath.Join(dir, "stats.json")
+	os.WriteFile(input, []byte("G0 Z5\nG1 Z2 F300\nG1 Z-1 F300\nG1 Z0.5 F300\n"), 0o644)
+
+	var stdout, stderr bytes.Buffer
+	if err := run(context.Background(), []string{"-input", input, "-output", output, "-json", "-stats-file", statsFile}, strings.NewReader(""), &stdout, &stderr); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	data, err := os.ReadFile(statsFile)
+	if err != nil {
+		t.Fatalf("reading -stats-file: %v", err)
+	}
+	var got summary
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("Unmarshal(%q): %v", data, err)
+	}
+	if got.ReductionPercent != 25 {
+		t.Fatalf("stats-file reductionPercent = %v, want 25", got.ReductionPercent)
+	}
+	if stdout.Len() == 0 {
+		t.Fatal("-stats-file should not suppress the normal stdout summary")
+	}
+}
+
+func TestRun_ZAliasTreatsSecondaryAxisAsDepth(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.gcode")
+	output := filepath.Join(dir, "out.gcode")
+	// The first W0.5 move crosses out of material and is kept entirely;
+	// the second, staying at the same shallow W, is the one removed.
+	os.WriteFile(input, []byte("G1 X1 W0.5 F300\nG1 X1.5 W0.5 F300\nG1 X2 W-1 F300\n"), 0o644)
+
+	var stdout, stderr bytes.Buffer
+	if err := run(context.Background(), []string{"-input", input, "-output", output, "-z-alias", "W"}, strings.NewReader(""), &stdout, &stderr); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	got, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if strings.Contains(string(got), "X1.5") {
+		t.Fatalf("output = %q, want the second, NoCrossing W0.5 move removed", got)
+	}
+	if !strings.Contains(string(got), "W0.5") {
+		t.Fatalf("output = %q, want the crossing W0.5 move kept", got)
+	}
+	if !strings.Contains(string(got), "W-1") {
+		t.Fatalf("output = %q, want the deep W-1 move kept", got)
+	}
+}
+
+func TestRun_ZAliasRejectsMultiCharacterValue(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.gcode")
+	output := filepath.Join(dir, "out.gcode")
+	os.WriteFile(input, []byte("G1 X1 F300\n"), 0o644)
+
+	var stdout, stderr bytes.Buffer
+	err := run(context.Background(), []string{"-input", input, "-output", output, "-z-alias", "WW"}, strings.NewReader(""), &stdout, &stderr)
+	if err == nil {
+		t.Fatal("expected an error for a multi-character -z-alias")
+	}
+}
+
+func TestRun_FixFeedWithoutDefaultFeedFallsBackToDefaultFeedRate(t *testing.T) {
+	if optimizer.DefaultFeedRate != 1000 {
+		t.Fatalf("test assumes optimizer.DefaultFeedRate == 1000, got %v", optimizer.DefaultFeedRate)
+	}
+
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.gcode")
+	output := filepath.Join(dir, "out.gcode")
+	os.WriteFile(input, []byte("G1 X1 Y0 Z-1 F-100\n"), 0o644)
+
+	var stdout, stderr bytes.Buffer
+	if err := run(context.Background(), []string{"-input", input, "-output", output, "-fix-feed"}, strings.NewReader(""), &stdout, &stderr); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	got, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if !strings.Contains(string(got), "F1000") {
+		t.Fatalf("output = %q, want the invalid F word replaced with -default-feed's own default (optimizer.DefaultFeedRate)", got)
+	}
+}
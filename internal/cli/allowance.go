@@ -0,0 +1,38 @@
+package cli
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// mmPerInch converts an inch value to millimeters.
+const mmPerInch = 25.4
+
+// ParseAllowance parses a -allowance value, accepting an optional "mm" or
+// "in" unit suffix (e.g. "1.0mm", "0.04in") alongside a bare number, which
+// is taken to already be in millimeters. The result is always in
+// millimeters; the caller is responsible for converting to the input
+// file's own units (G20/G21) before handing it to optimizer.Config.
+func ParseAllowance(s string) (float64, error) {
+	trimmed := strings.TrimSpace(s)
+	numeric := trimmed
+	unit := ""
+	switch {
+	case strings.HasSuffix(strings.ToLower(trimmed), "mm"):
+		numeric = trimmed[:len(trimmed)-2]
+		unit = "mm"
+	case strings.HasSuffix(strings.ToLower(trimmed), "in"):
+		numeric = trimmed[:len(trimmed)-2]
+		unit = "in"
+	}
+	numeric = strings.TrimSpace(numeric)
+	value, err := strconv.ParseFloat(numeric, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid allowance %q: %w", s, err)
+	}
+	if unit == "in" {
+		value *= mmPerInch
+	}
+	return value, nil
+}
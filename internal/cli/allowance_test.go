@@ -0,0 +1,29 @@
+package cli
+
+import "testing"
+
+func TestParseAllowance_AcceptsMMSuffixINSuffixAndBareNumber(t *testing.T) {
+	cases := []struct {
+		in   string
+		want float64
+	}{
+		{"1.0mm", 1.0},
+		{"0.04in", 0.04 * mmPerInch},
+		{"1.0", 1.0},
+	}
+	for _, c := range cases {
+		got, err := ParseAllowance(c.in)
+		if err != nil {
+			t.Fatalf("ParseAllowance(%q): %v", c.in, err)
+		}
+		if got != c.want {
+			t.Errorf("ParseAllowance(%q) = %v, want %v", c.in, got, c.want)
+		}
+	}
+}
+
+func TestParseAllowance_RejectsGarbage(t *testing.T) {
+	if _, err := ParseAllowance("not-a-number"); err == nil {
+		t.Fatal("ParseAllowance(\"not-a-number\") = nil error, want one")
+	}
+}
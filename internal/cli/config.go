@@ -0,0 +1,145 @@
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ConfigFileNames are the filenames DiscoverConfigFile looks for in a
+// directory, in priority order, when -config isn't given explicitly.
+var ConfigFileNames = []string{"snapmaker.toml", ".snapmakerrc"}
+
+// ParseConfigFile parses a config file of "key = value" lines, one setting
+// per line: blank lines and lines starting with "#" are ignored, and a
+// value may optionally be quoted. Keys match flag names without their
+// leading dash (e.g. "strategy", "default-feed"), and values are the exact
+// string a user would pass after the flag's "=". This covers TOML's bare
+// key=value form too, so a snapmaker.toml that sticks to simple settings
+// (no tables, arrays, or multi-line strings) parses the same way a
+// .snapmakerrc does.
+func ParseConfigFile(r io.Reader) (map[string]string, error) {
+	cfg := make(map[string]string)
+	sc := bufio.NewScanner(r)
+	lineNo := 0
+	for sc.Scan() {
+		lineNo++
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		idx := strings.Index(line, "=")
+		if idx < 0 {
+			return nil, fmt.Errorf("line %d: missing \"=\" in %q", lineNo, line)
+		}
+		key := strings.TrimSpace(line[:idx])
+		if key == "" {
+			return nil, fmt.Errorf("line %d: empty key in %q", lineNo, line)
+		}
+		value := strings.TrimSpace(line[idx+1:])
+		value = strings.Trim(value, `"'`)
+		cfg[key] = value
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// LoadConfigFile opens path and parses it with ParseConfigFile.
+func LoadConfigFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseConfigFile(f)
+}
+
+// DiscoverConfigFile looks for each of ConfigFileNames in dir, in order,
+// and returns the first one found. ok is false if none exist, which is not
+// an error: auto-discovery is optional, unlike an explicit -config path.
+func DiscoverConfigFile(dir string) (path string, ok bool) {
+	for _, name := range ConfigFileNames {
+		candidate := filepath.Join(dir, name)
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate, true
+		}
+	}
+	return "", false
+}
+
+// ValidateConfigKeys reports an error naming every key in cfg that isn't in
+// known, so a typo'd setting fails loudly instead of silently being
+// ignored.
+func ValidateConfigKeys(cfg map[string]string, known []string) error {
+	allowed := make(map[string]bool, len(known))
+	for _, k := range known {
+		allowed[k] = true
+	}
+	var unknown []string
+	for k := range cfg {
+		if !allowed[k] {
+			unknown = append(unknown, k)
+		}
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+	sort.Strings(unknown)
+	return fmt.Errorf("unknown config key(s): %s", strings.Join(unknown, ", "))
+}
+
+// ConfigString returns cfg[key] if present, else fallback. Use this as a
+// flag's default when the flag's value is a bare string.
+func ConfigString(cfg map[string]string, key, fallback string) string {
+	if v, ok := cfg[key]; ok {
+		return v
+	}
+	return fallback
+}
+
+// ConfigBool parses cfg[key] as a bool if present, else returns fallback.
+func ConfigBool(cfg map[string]string, key string, fallback bool) (bool, error) {
+	v, ok := cfg[key]
+	if !ok {
+		return fallback, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("config key %q: invalid bool %q", key, v)
+	}
+	return b, nil
+}
+
+// ConfigFloat64 parses cfg[key] as a float64 if present, else returns
+// fallback.
+func ConfigFloat64(cfg map[string]string, key string, fallback float64) (float64, error) {
+	v, ok := cfg[key]
+	if !ok {
+		return fallback, nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, fmt.Errorf("config key %q: invalid number %q", key, v)
+	}
+	return f, nil
+}
+
+// ConfigInt parses cfg[key] as an int if present, else returns fallback.
+func ConfigInt(cfg map[string]string, key string, fallback int) (int, error) {
+	v, ok := cfg[key]
+	if !ok {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("config key %q: invalid integer %q", key, v)
+	}
+	return n, nil
+}
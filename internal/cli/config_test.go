@@ -0,0 +1,92 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseConfigFile_ParsesKeyValuePairsSkippingBlankAndCommentLines(t *testing.T) {
+	input := `
+# a comment
+strategy = split
+allowance = "1.0mm"
+precision=6
+
+`
+	cfg, err := ParseConfigFile(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("ParseConfigFile: %v", err)
+	}
+	want := map[string]string{"strategy": "split", "allowance": "1.0mm", "precision": "6"}
+	if len(cfg) != len(want) {
+		t.Fatalf("ParseConfigFile = %v, want %v", cfg, want)
+	}
+	for k, v := range want {
+		if cfg[k] != v {
+			t.Errorf("cfg[%q] = %q, want %q", k, cfg[k], v)
+		}
+	}
+}
+
+func TestParseConfigFile_RejectsALineWithoutEquals(t *testing.T) {
+	if _, err := ParseConfigFile(strings.NewReader("strategy split\n")); err == nil {
+		t.Fatal("ParseConfigFile with no \"=\" = nil error, want one")
+	}
+}
+
+func TestDiscoverConfigFile_FindsSnapmakerTOMLBeforeSnapmakerrc(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"snapmaker.toml", ".snapmakerrc"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("strategy = safe\n"), 0o644); err != nil {
+			t.Fatalf("WriteFile %s: %v", name, err)
+		}
+	}
+	path, ok := DiscoverConfigFile(dir)
+	if !ok {
+		t.Fatal("DiscoverConfigFile: ok = false, want true")
+	}
+	if filepath.Base(path) != "snapmaker.toml" {
+		t.Fatalf("DiscoverConfigFile = %s, want snapmaker.toml to take priority", path)
+	}
+}
+
+func TestDiscoverConfigFile_NotOKWhenNeitherFileExists(t *testing.T) {
+	if _, ok := DiscoverConfigFile(t.TempDir()); ok {
+		t.Fatal("DiscoverConfigFile: ok = true in an empty directory, want false")
+	}
+}
+
+func TestValidateConfigKeys_RejectsAnUnknownKey(t *testing.T) {
+	cfg := map[string]string{"strategy": "safe", "not-a-real-flag": "1"}
+	known := []string{"strategy", "allowance"}
+	err := ValidateConfigKeys(cfg, known)
+	if err == nil {
+		t.Fatal("ValidateConfigKeys with an unknown key = nil error, want one")
+	}
+	if !strings.Contains(err.Error(), "not-a-real-flag") {
+		t.Fatalf("ValidateConfigKeys error = %q, want it to name the unknown key", err.Error())
+	}
+}
+
+func TestValidateConfigKeys_AcceptsOnlyKnownKeys(t *testing.T) {
+	cfg := map[string]string{"strategy": "safe", "allowance": "1.0mm"}
+	if err := ValidateConfigKeys(cfg, []string{"strategy", "allowance"}); err != nil {
+		t.Fatalf("ValidateConfigKeys: %v", err)
+	}
+}
+
+func TestConfigFloat64_FallsBackWhenKeyAbsentAndErrorsOnGarbage(t *testing.T) {
+	cfg := map[string]string{"tolerance": "0.5", "precision": "not-a-number"}
+	got, err := ConfigFloat64(cfg, "tolerance", 0)
+	if err != nil || got != 0.5 {
+		t.Fatalf("ConfigFloat64(tolerance) = %v, %v, want 0.5, nil", got, err)
+	}
+	if _, err := ConfigFloat64(cfg, "missing", 9); err != nil {
+		t.Fatalf("ConfigFloat64(missing) should fall back without error, got %v", err)
+	}
+	if _, err := ConfigFloat64(cfg, "precision", 0); err == nil {
+		t.Fatal("ConfigFloat64(precision) with non-numeric value = nil error, want one")
+	}
+}
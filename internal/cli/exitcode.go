@@ -0,0 +1,35 @@
+package cli
+
+// Exit codes returned by gcode-optimizer, collected here as the single
+// source of truth so help text, documentation, and tests can't drift out
+// of sync with what the binary actually does.
+const (
+	// ExitOK is returned when the run completed successfully.
+	ExitOK = 0
+	// ExitError is returned for an operational failure: a file couldn't be
+	// read or written, optimization was refused without -force, etc.
+	ExitError = 1
+	// ExitUsage is returned when the command line itself was invalid: an
+	// unknown flag, a bad flag value, or a missing required flag.
+	ExitUsage = 2
+)
+
+// UsageError marks err as a command-line usage mistake (a bad flag value
+// or a missing required flag) rather than an operational failure, so main
+// can map it to ExitUsage instead of ExitError.
+type UsageError struct {
+	Err error
+}
+
+func (e *UsageError) Error() string { return e.Err.Error() }
+
+func (e *UsageError) Unwrap() error { return e.Err }
+
+// NewUsageError wraps err as a *UsageError. A nil err returns nil, so it's
+// safe to wrap a fmt.Errorf call directly at a return site.
+func NewUsageError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &UsageError{Err: err}
+}
@@ -0,0 +1,57 @@
+// Package cli holds the gcode-optimizer build-time version information and
+// its presentation, so both the text and JSON forms of "-version" derive
+// from the same values.
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"runtime"
+)
+
+// Version, GitCommit, and BuildDate are overridden at build time via
+// -ldflags, e.g.:
+//
+//	go build -ldflags "-X .../internal/cli.Version=v1.2.3 \
+//	  -X .../internal/cli.GitCommit=$(git rev-parse HEAD) \
+//	  -X .../internal/cli.BuildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// They default to placeholders for local, non-release builds.
+var (
+	Version   = "dev"
+	GitCommit = "none"
+	BuildDate = "unknown"
+)
+
+// VersionInfo is the JSON shape of "-version -json".
+type VersionInfo struct {
+	Version   string `json:"version"`
+	GoVersion string `json:"goVersion"`
+	OS        string `json:"os"`
+	Arch      string `json:"arch"`
+	GitCommit string `json:"gitCommit"`
+	BuildDate string `json:"buildDate"`
+}
+
+// GetVersionText returns the human-readable "-version" output.
+func GetVersionText() string {
+	return fmt.Sprintf("gcode-optimizer %s (commit %s, built %s, %s, %s/%s)",
+		Version, GitCommit, BuildDate, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+}
+
+// GetVersionJSON returns the "-version -json" output: a single-line JSON
+// object matching VersionInfo.
+func GetVersionJSON() (string, error) {
+	b, err := json.Marshal(VersionInfo{
+		Version:   Version,
+		GoVersion: runtime.Version(),
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+		GitCommit: GitCommit,
+		BuildDate: BuildDate,
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
@@ -0,0 +1,32 @@
+package cli
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestGetVersionJSON_FieldsMatchPackageVars(t *testing.T) {
+	text, err := GetVersionJSON()
+	if err != nil {
+		t.Fatalf("GetVersionJSON: %v", err)
+	}
+
+	var info VersionInfo
+	if err := json.Unmarshal([]byte(text), &info); err != nil {
+		t.Fatalf("Unmarshal(%q): %v", text, err)
+	}
+
+	if info.Version != Version {
+		t.Fatalf("Version = %q, want %q", info.Version, Version)
+	}
+	if info.GitCommit != GitCommit {
+		t.Fatalf("GitCommit = %q, want %q", info.GitCommit, GitCommit)
+	}
+}
+
+func TestGetVersionText_ContainsVersion(t *testing.T) {
+	if !strings.Contains(GetVersionText(), Version) {
+		t.Fatalf("GetVersionText() = %q, want it to contain %q", GetVersionText(), Version)
+	}
+}
@@ -0,0 +1,56 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/chrisns/snapmaker-cnc-finisher/internal/optimizer"
+)
+
+// PrintWarning renders w to out in the same "warning: <message>" form used
+// throughout the CLI, so a caller holding a Statistics.CodedWarnings entry
+// doesn't need to know the exact wording of non-quiet warning output.
+func PrintWarning(out io.Writer, w optimizer.Warning) error {
+	_, err := fmt.Fprintln(out, "warning:", w.Message)
+	return err
+}
+
+// WarningTracker deduplicates warning text across multiple runs (e.g. one
+// per file in a batch), so a warning common to every file is reported once
+// with an occurrence count instead of once per file. Statistics.AddWarning
+// already dedupes exact repeats within a single run; this is the same idea
+// carried across runs that don't share a Statistics.
+type WarningTracker struct {
+	order []string
+	count map[string]int
+}
+
+// NewWarningTracker returns an empty WarningTracker.
+func NewWarningTracker() *WarningTracker {
+	return &WarningTracker{count: make(map[string]int)}
+}
+
+// Add records one occurrence of msg, returning its new total count.
+func (t *WarningTracker) Add(msg string) int {
+	if _, ok := t.count[msg]; !ok {
+		t.order = append(t.order, msg)
+	}
+	t.count[msg]++
+	return t.count[msg]
+}
+
+// WarningSummary is one distinct warning Add has seen, and how many times.
+type WarningSummary struct {
+	Message string
+	Count   int
+}
+
+// Report returns every distinct warning Add has seen, in first-seen order,
+// alongside its total occurrence count.
+func (t *WarningTracker) Report() []WarningSummary {
+	out := make([]WarningSummary, len(t.order))
+	for i, msg := range t.order {
+		out[i] = WarningSummary{Message: msg, Count: t.count[msg]}
+	}
+	return out
+}
@@ -0,0 +1,27 @@
+package cli
+
+import "testing"
+
+func TestWarningTracker_DedupesAcrossTwoFilesWithCount(t *testing.T) {
+	tr := NewWarningTracker()
+
+	// Simulate two files' worth of warnings: both hit the same "no feed
+	// rate" warning, one also hits a file-specific warning.
+	for _, w := range []string{"no feed rate found; time estimate is approximate"} {
+		tr.Add(w)
+	}
+	for _, w := range []string{"no feed rate found; time estimate is approximate", "Z-reference fell back to surface"} {
+		tr.Add(w)
+	}
+
+	report := tr.Report()
+	if len(report) != 2 {
+		t.Fatalf("Report() has %d entries, want 2: %v", len(report), report)
+	}
+	if report[0].Message != "no feed rate found; time estimate is approximate" || report[0].Count != 2 {
+		t.Fatalf("report[0] = %+v, want {no feed rate..., 2}", report[0])
+	}
+	if report[1].Message != "Z-reference fell back to surface" || report[1].Count != 1 {
+		t.Fatalf("report[1] = %+v, want {Z-reference..., 1}", report[1])
+	}
+}
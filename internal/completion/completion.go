@@ -0,0 +1,122 @@
+// Package completion generates shell tab-completion scripts for the
+// gcode-optimizer CLI. The scripts are generated from a small static flag
+// list rather than introspecting flag.FlagSet, since the stdlib flag
+// package exposes no metadata (choices, whether a flag takes a file) beyond
+// name and default value.
+package completion
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Flags lists the gcode-optimizer flags completions should offer, in long
+// form (without the leading dash).
+var Flags = []string{
+	"allowance", "reference", "reference-z", "invert", "by-layer",
+	"precision", "normalize-endings", "strategy", "dry-run", "force",
+	"log-format", "cpuprofile", "memprofile", "input", "output",
+	"help", "version", "json", "dump-removed", "keep-zero-length", "rotary",
+	"calibrate", "max-feed", "stamp", "comment-prefix", "split-size", "checksum",
+	"strip-redundant-feed", "check-bounds", "spring-pass", "quiet-warnings", "renumber",
+	"list-strategies", "moves-only", "range", "tolerance", "collapse-blanks",
+	"header-lines", "optimize-rapids", "compare", "compare-tolerance", "z-alias", "tool-dia", "check-plunge", "floor", "collapse-retracts",
+	"recursive", "output-dir", "tool", "progress-json", "fix-feed", "default-feed", "summary-only",
+	"surface", "smooth", "ramp-angle", "config", "region", "stats-file",
+	"keep-above-surface", "footer-lines", "interactive",
+}
+
+// StrategyValues lists the accepted values for -strategy, matching
+// optimizer.Strategy's constants.
+var StrategyValues = []string{"safe", "split", "aggressive", "all-axes"}
+
+// Shell identifies a shell to generate a completion script for.
+type Shell string
+
+const (
+	Bash Shell = "bash"
+	Zsh  Shell = "zsh"
+	Fish Shell = "fish"
+)
+
+// Script returns the completion script for shell, or an error if shell
+// isn't one of Bash, Zsh, or Fish.
+func Script(shell Shell) (string, error) {
+	switch shell {
+	case Bash:
+		return bashScript(), nil
+	case Zsh:
+		return zshScript(), nil
+	case Fish:
+		return fishScript(), nil
+	default:
+		return "", fmt.Errorf("unknown shell %q: want bash, zsh, or fish", shell)
+	}
+}
+
+func flagWords() string {
+	words := make([]string, len(Flags))
+	for i, f := range Flags {
+		words[i] = "-" + f
+	}
+	return strings.Join(words, " ")
+}
+
+func bashScript() string {
+	return fmt.Sprintf(`# bash completion for gcode-optimizer
+_gcode_optimizer() {
+	local cur prev
+	cur="${COMP_WORDS[COMP_CWORD]}"
+	prev="${COMP_WORDS[COMP_CWORD-1]}"
+	if [[ "$prev" == "-strategy" ]]; then
+		COMPREPLY=($(compgen -W "%s" -- "$cur"))
+		return
+	fi
+	COMPREPLY=($(compgen -W "%s" -- "$cur"))
+}
+complete -F _gcode_optimizer gcode-optimizer
+`, strings.Join(StrategyValues, " "), flagWords())
+}
+
+func zshScript() string {
+	return fmt.Sprintf(`#compdef gcode-optimizer
+_gcode_optimizer() {
+	local -a flags strategies
+	flags=(%s)
+	strategies=(%s)
+	if [[ "${words[CURRENT-1]}" == "-strategy" ]]; then
+		_describe 'strategy' strategies
+		return
+	fi
+	_describe 'flag' flags
+}
+_gcode_optimizer
+`, strings.Join(quoteAll(flagWordsSlice()), " "), strings.Join(quoteAll(StrategyValues), " "))
+}
+
+func fishScript() string {
+	var b strings.Builder
+	for _, f := range Flags {
+		fmt.Fprintf(&b, "complete -c gcode-optimizer -l %s\n", f)
+	}
+	for _, v := range StrategyValues {
+		fmt.Fprintf(&b, "complete -c gcode-optimizer -n '__fish_seen_argument -l strategy' -a %s\n", v)
+	}
+	return b.String()
+}
+
+func flagWordsSlice() []string {
+	words := make([]string, len(Flags))
+	for i, f := range Flags {
+		words[i] = "-" + f
+	}
+	return words
+}
+
+func quoteAll(ss []string) []string {
+	out := make([]string, len(ss))
+	for i, s := range ss {
+		out[i] = "'" + s + "'"
+	}
+	return out
+}
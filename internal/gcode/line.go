@@ -0,0 +1,199 @@
+// Package gcode provides a minimal parser and model for the subset of
+// G-code emitted by Snapmaker Luban that gcode-optimizer needs to reason
+// about: motion commands, their numeric parameters and trailing comments.
+//
+// Parsing is deliberately lossless: a Line retains the exact raw text it
+// was parsed from, so a pass-through line can always be re-emitted byte
+// for byte. Only Lines explicitly constructed or mutated by the optimizer
+// are re-serialized from their structured fields.
+package gcode
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Param is a single letter/value word on a G-code line, e.g. "X12.5".
+type Param struct {
+	Letter byte
+	Value  float64
+	Raw    string // original text of the value, e.g. "12.500"
+}
+
+// Line is a single parsed line of G-code.
+type Line struct {
+	Raw    string // exact original text, without the trailing newline
+	Number int    // 1-based source line number
+
+	Code    string // e.g. "G1", "M3"; empty if the line has no command word
+	Params  []Param
+	Comment string // comment text including its delimiter, e.g. "; Header Start"
+
+	Blank       bool // line was empty (possibly whitespace only)
+	Synthesized bool // true if this Line was generated/mutated rather than parsed verbatim
+}
+
+// normalizeDecimalComma rewrites a comma decimal separator ("1,2") to a dot
+// ("1.2") so strconv.ParseFloat can parse it. GCode has no legitimate use
+// for a comma anywhere in a word's value, so any comma found is assumed to
+// be a locale-affected CAM post's decimal point; a value already containing
+// a dot is left untouched.
+func normalizeDecimalComma(s string) string {
+	if strings.Contains(s, ".") || !strings.Contains(s, ",") {
+		return s
+	}
+	return strings.Replace(s, ",", ".", 1)
+}
+
+// upperLetter uppercases a single ASCII letter byte, leaving anything else
+// unchanged.
+func upperLetter(b byte) byte {
+	if b >= 'a' && b <= 'z' {
+		return b - ('a' - 'A')
+	}
+	return b
+}
+
+// Parse parses a single raw line of G-code (no trailing newline) into a Line.
+func Parse(raw string, lineNo int) Line {
+	l := Line{Raw: raw, Number: lineNo}
+
+	body := raw
+	if idx := strings.IndexByte(body, ';'); idx >= 0 {
+		l.Comment = strings.TrimRight(body[idx:], "\r")
+		body = body[:idx]
+	}
+	if idx := strings.IndexByte(body, '('); idx >= 0 {
+		if end := strings.IndexByte(body[idx:], ')'); end >= 0 {
+			if l.Comment == "" {
+				l.Comment = body[idx : idx+end+1]
+			}
+			body = body[:idx] + body[idx+end+1:]
+		}
+	}
+
+	fields := strings.Fields(body)
+
+	// The command word is normally the first field, but Luban sometimes
+	// prefixes a line with an N-word (a line number, not the command), so
+	// look for the command one field later when that happens.
+	commandPos := 0
+	if len(fields) > 0 && upperLetter(fields[0][0]) == 'N' {
+		commandPos = 1
+	}
+
+	for i, f := range fields {
+		letter := upperLetter(f[0])
+		if i == commandPos && (letter == 'G' || letter == 'M' || letter == 'T') {
+			l.Code = strings.ToUpper(f)
+			continue
+		}
+		if len(f) < 2 {
+			continue
+		}
+		valRaw := normalizeDecimalComma(f[1:])
+		val, err := strconv.ParseFloat(valRaw, 64)
+		if err != nil {
+			continue
+		}
+		l.Params = append(l.Params, Param{Letter: letter, Value: val, Raw: valRaw})
+	}
+
+	if strings.TrimSpace(raw) == "" {
+		l.Blank = true
+	}
+
+	return l
+}
+
+// IsProgramDelimiter reports whether the line is a lone "%", the
+// Fanuc-style program start/end marker. Parse already treats it like any
+// other line with no recognized command word - Code is left empty and Raw
+// is preserved untouched - so this exists only for a caller that wants to
+// recognize the marker explicitly, not to change how it's handled.
+func (l Line) IsProgramDelimiter() bool {
+	return strings.TrimSpace(l.Raw) == "%"
+}
+
+// Get returns the value of the parameter with the given letter and whether
+// it was present on the line.
+func (l Line) Get(letter byte) (float64, bool) {
+	for _, p := range l.Params {
+		if p.Letter == letter {
+			return p.Value, true
+		}
+	}
+	return 0, false
+}
+
+// GetRaw returns the original text of the parameter's value, as it
+// appeared in the source, and whether it was present on the line.
+func (l Line) GetRaw(letter byte) (string, bool) {
+	for _, p := range l.Params {
+		if p.Letter == letter {
+			return p.Raw, true
+		}
+	}
+	return "", false
+}
+
+// paramOrder is the axis/word order Snapmaker Luban emits on a motion line,
+// e.g. "G1 X10.000 Y5.000 Z-1.000 F300". String uses it to order a
+// Synthesized line's Params so a regenerated line reads the way Luban itself
+// would have, rather than whatever order the optimizer happened to build
+// Params in.
+var paramOrder = map[byte]int{'X': 0, 'Y': 1, 'Z': 2, 'B': 3, 'F': 4}
+
+// orderedParams returns params sorted to paramOrder, with any letters
+// paramOrder doesn't know about sorted alphabetically after the known ones,
+// so a line's output is deterministic regardless of the order Params was
+// built in.
+func orderedParams(params []Param) []Param {
+	out := make([]Param, len(params))
+	copy(out, params)
+	sort.SliceStable(out, func(i, j int) bool {
+		oi, oki := paramOrder[out[i].Letter]
+		oj, okj := paramOrder[out[j].Letter]
+		if !oki && !okj {
+			return out[i].Letter < out[j].Letter
+		}
+		if !oki {
+			oi = len(paramOrder)
+		}
+		if !okj {
+			oj = len(paramOrder)
+		}
+		return oi < oj
+	})
+	return out
+}
+
+// String renders the line back to text. Lines parsed from source and left
+// untouched return their exact original text; Synthesized lines are
+// rebuilt deterministically from their structured fields, with Params
+// ordered to match Luban's own convention (see paramOrder).
+func (l Line) String() string {
+	if !l.Synthesized {
+		return l.Raw
+	}
+
+	var b strings.Builder
+	if l.Code != "" {
+		b.WriteString(l.Code)
+	}
+	for _, p := range orderedParams(l.Params) {
+		if b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		fmt.Fprintf(&b, "%c%s", p.Letter, p.Raw)
+	}
+	if l.Comment != "" {
+		if b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(l.Comment)
+	}
+	return b.String()
+}
@@ -0,0 +1,137 @@
+package gcode
+
+import "testing"
+
+func TestParse_NumericFormats(t *testing.T) {
+	cases := []struct {
+		raw    string
+		letter byte
+		want   float64
+	}{
+		{"G1 Z-.5", 'Z', -0.5},
+		{"G1 Z.5", 'Z', 0.5},
+		{"G1 Z1e-2", 'Z', 0.01},
+		{"G1 Z+1.0", 'Z', 1.0},
+		{"G1 Z-1,2", 'Z', -1.2},
+	}
+
+	for _, c := range cases {
+		t.Run(c.raw, func(t *testing.T) {
+			line := Parse(c.raw, 1)
+			got, ok := line.Get(c.letter)
+			if !ok {
+				t.Fatalf("Get(%c) not found for %q", c.letter, c.raw)
+			}
+			if got != c.want {
+				t.Fatalf("Get(%c) = %v, want %v", c.letter, got, c.want)
+			}
+		})
+	}
+}
+
+// TestParse_CommaDecimalParamRawIsNormalizedToDot checks that a comma
+// decimal, once parsed, also has its stored Param.Raw normalized to dot
+// form - so a Synthesized line built from it later renders with a dot,
+// not the locale-affected comma that broke parsing in the first place.
+func TestParse_CommaDecimalParamRawIsNormalizedToDot(t *testing.T) {
+	line := Parse("G1 X1,5 Y2,50 Z-1,2 F300", 1)
+
+	for _, c := range []struct {
+		letter byte
+		want   string
+	}{
+		{'X', "1.5"}, {'Y', "2.50"}, {'Z', "-1.2"},
+	} {
+		raw, ok := line.GetRaw(c.letter)
+		if !ok {
+			t.Fatalf("GetRaw(%c) not found", c.letter)
+		}
+		if raw != c.want {
+			t.Fatalf("GetRaw(%c) = %q, want %q", c.letter, raw, c.want)
+		}
+	}
+
+	// The line's own Raw text - what a pass-through line re-emits
+	// verbatim - is untouched by the normalization.
+	if line.Raw != "G1 X1,5 Y2,50 Z-1,2 F300" {
+		t.Fatalf("Raw = %q, want original comma-decimal text preserved", line.Raw)
+	}
+}
+
+func TestParse_LeadingNWordDoesNotHideCommand(t *testing.T) {
+	line := Parse("N20 G1 X1 Z-1 F300", 1)
+	if line.Code != "G1" {
+		t.Fatalf("Code = %q, want G1", line.Code)
+	}
+	if n, ok := line.Get('N'); !ok || n != 20 {
+		t.Fatalf("Get('N') = %v, %v, want 20, true", n, ok)
+	}
+	if x, ok := line.Get('X'); !ok || x != 1 {
+		t.Fatalf("Get('X') = %v, %v, want 1, true", x, ok)
+	}
+}
+
+func TestParse_PercentDelimiterHasNoCodeAndIsRecognized(t *testing.T) {
+	line := Parse("%", 1)
+	if line.Code != "" {
+		t.Fatalf("Code = %q, want empty", line.Code)
+	}
+	if !line.IsProgramDelimiter() {
+		t.Fatal("IsProgramDelimiter() = false, want true for a lone %")
+	}
+	if line.String() != "%" {
+		t.Fatalf("String() = %q, want %q", line.String(), "%")
+	}
+}
+
+func TestParse_NonDelimiterLineIsNotAProgramDelimiter(t *testing.T) {
+	line := Parse("G1 X1 F300", 1)
+	if line.IsProgramDelimiter() {
+		t.Fatal("IsProgramDelimiter() = true, want false for a motion line")
+	}
+}
+
+// TestString_UnknownParamsSortAlphabeticallyAndDeterministically checks that
+// letters orderedParams doesn't recognize (here U and A, neither in
+// paramOrder) always render in a fixed, alphabetical relative order, rather
+// than whatever order they happened to be built in.
+func TestString_UnknownParamsSortAlphabeticallyAndDeterministically(t *testing.T) {
+	line := Line{
+		Code:        "G1",
+		Synthesized: true,
+		Params: []Param{
+			{Letter: 'U', Value: 1, Raw: "1"},
+			{Letter: 'X', Value: 2, Raw: "2"},
+			{Letter: 'A', Value: 3, Raw: "3"},
+		},
+	}
+
+	want := "G1 X2 A3 U1"
+	for i := 0; i < 20; i++ {
+		if got := line.String(); got != want {
+			t.Fatalf("String() = %q, want %q (attempt %d)", got, want, i)
+		}
+	}
+}
+
+// TestString_SynthesizedParamOrderMatchesLuban checks a Synthesized line
+// built with Params out of order still renders in Luban's own convention
+// (X Y Z B F), matching a real Luban-emitted reference line such as
+// "G1 X12.500 Y3.200 Z-1.000 F1500".
+func TestString_SynthesizedParamOrderMatchesLuban(t *testing.T) {
+	line := Line{
+		Code:        "G1",
+		Synthesized: true,
+		Params: []Param{
+			{Letter: 'F', Value: 1500, Raw: "1500"},
+			{Letter: 'Z', Value: -1, Raw: "-1.000"},
+			{Letter: 'X', Value: 12.5, Raw: "12.500"},
+			{Letter: 'Y', Value: 3.2, Raw: "3.200"},
+		},
+	}
+
+	want := "G1 X12.500 Y3.200 Z-1.000 F1500"
+	if got := line.String(); got != want {
+		t.Fatalf("String() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,144 @@
+package gcode
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Metadata is the set of Luban header fields gcode-optimizer understands.
+// Header comments look like ";key: value" or ";key(unit): value"; the unit
+// suffix, if present, is stripped from the key before matching.
+type Metadata struct {
+	HeaderType           string
+	FileTotalLines       int
+	EstimatedTimeSeconds float64
+	WorkSpeed            float64
+	JogSpeed             float64
+
+	// Is4Axis reports whether the header's "is_rotate" field is true,
+	// meaning the job drives a rotary B axis alongside X/Y/Z.
+	Is4Axis bool
+
+	// MinX, MaxX, MinY, MaxY are the job's declared work area, from the
+	// header's "min_x"/"max_x"/"min_y"/"max_y" fields. HasXYBounds is false
+	// unless all four were present and parsed.
+	MinX, MaxX, MinY, MaxY float64
+	HasXYBounds            bool
+
+	HasEstimatedTime bool
+	HasWorkSpeed     bool
+	HasJogSpeed      bool
+}
+
+// DefaultHeaderScanLines is how many leading lines ExtractMetadata scans
+// looking for header comments before giving up, for tool chains whose
+// header runs long enough (tool tables, material notes) to push fields
+// like min_x/max_x past a shorter default. ExtractMetadata itself has no
+// limit; this is only the CLI's default for -header-lines.
+const DefaultHeaderScanLines = 50
+
+// ExtractMetadata scans the leading header comments of r (stopping at the
+// first non-comment, non-blank line) and returns the fields it recognizes.
+// It never gives up early; use ExtractMetadataWithLimit to cap how many
+// lines are scanned.
+func ExtractMetadata(r io.Reader) (Metadata, error) {
+	return ExtractMetadataWithLimit(r, 0)
+}
+
+// ExtractMetadataWithLimit is ExtractMetadata with an upper bound on how
+// many leading lines are scanned. maxLines <= 0 means no limit (the same
+// behavior as ExtractMetadata). Reaching the limit simply stops the scan;
+// it isn't an error, and fields not yet found keep their zero value.
+func ExtractMetadataWithLimit(r io.Reader, maxLines int) (Metadata, error) {
+	var md Metadata
+	var hasMinX, hasMaxX, hasMinY, hasMaxY bool
+
+	scanner := bufio.NewScanner(r)
+	for lineNo := 0; scanner.Scan(); lineNo++ {
+		if maxLines > 0 && lineNo >= maxLines {
+			break
+		}
+		raw := strings.TrimSpace(scanner.Text())
+		if raw == "" {
+			continue
+		}
+		if !strings.HasPrefix(raw, ";") {
+			break
+		}
+
+		body := strings.TrimPrefix(raw, ";")
+		key, value, ok := splitHeaderKV(body)
+		if !ok {
+			continue
+		}
+
+		switch key {
+		case "header_type":
+			md.HeaderType = value
+		case "file_total_lines":
+			if n, err := strconv.Atoi(value); err == nil {
+				md.FileTotalLines = n
+			}
+		case "estimated_time":
+			if f, err := strconv.ParseFloat(normalizeDecimalComma(value), 64); err == nil {
+				md.EstimatedTimeSeconds = f
+				md.HasEstimatedTime = true
+			}
+		case "work_speed":
+			if f, err := strconv.ParseFloat(normalizeDecimalComma(value), 64); err == nil {
+				md.WorkSpeed = f
+				md.HasWorkSpeed = true
+			}
+		case "jog_speed":
+			if f, err := strconv.ParseFloat(normalizeDecimalComma(value), 64); err == nil {
+				md.JogSpeed = f
+				md.HasJogSpeed = true
+			}
+		case "is_rotate":
+			if b, err := strconv.ParseBool(value); err == nil {
+				md.Is4Axis = b
+			}
+		case "min_x":
+			if f, err := strconv.ParseFloat(normalizeDecimalComma(value), 64); err == nil {
+				md.MinX = f
+				hasMinX = true
+			}
+		case "max_x":
+			if f, err := strconv.ParseFloat(normalizeDecimalComma(value), 64); err == nil {
+				md.MaxX = f
+				hasMaxX = true
+			}
+		case "min_y":
+			if f, err := strconv.ParseFloat(normalizeDecimalComma(value), 64); err == nil {
+				md.MinY = f
+				hasMinY = true
+			}
+		case "max_y":
+			if f, err := strconv.ParseFloat(normalizeDecimalComma(value), 64); err == nil {
+				md.MaxY = f
+				hasMaxY = true
+			}
+		}
+	}
+	md.HasXYBounds = hasMinX && hasMaxX && hasMinY && hasMaxY
+
+	return md, scanner.Err()
+}
+
+// splitHeaderKV splits "key: value" or "key(unit): value" header comment
+// bodies into a normalized key (unit suffix stripped) and its value.
+func splitHeaderKV(body string) (key, value string, ok bool) {
+	idx := strings.Index(body, ":")
+	if idx < 0 {
+		return "", "", false
+	}
+	key = strings.TrimSpace(body[:idx])
+	value = strings.TrimSpace(body[idx+1:])
+	if p := strings.Index(key, "("); p >= 0 {
+		key = key[:p]
+	}
+	key = strings.TrimSpace(key)
+	return key, value, key != ""
+}
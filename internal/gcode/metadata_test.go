@@ -0,0 +1,142 @@
+package gcode
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestExtractMetadata_UnitSuffixedKeys(t *testing.T) {
+	input := ";Header Start\n" +
+		";header_type: cnc\n" +
+		";file_total_lines: 42\n" +
+		";estimated_time(s): 12.5\n" +
+		";work_speed(mm/minute): 300\n" +
+		";jog_speed(mm/minute): 1500\n" +
+		";Header End\n" +
+		"G90\n"
+
+	md, err := ExtractMetadata(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("ExtractMetadata: %v", err)
+	}
+
+	if md.HeaderType != "cnc" {
+		t.Errorf("HeaderType = %q, want cnc", md.HeaderType)
+	}
+	if md.FileTotalLines != 42 {
+		t.Errorf("FileTotalLines = %d, want 42", md.FileTotalLines)
+	}
+	if !md.HasEstimatedTime || md.EstimatedTimeSeconds != 12.5 {
+		t.Errorf("EstimatedTimeSeconds = %v (has=%v), want 12.5", md.EstimatedTimeSeconds, md.HasEstimatedTime)
+	}
+	if !md.HasWorkSpeed || md.WorkSpeed != 300 {
+		t.Errorf("WorkSpeed = %v (has=%v), want 300", md.WorkSpeed, md.HasWorkSpeed)
+	}
+	if !md.HasJogSpeed || md.JogSpeed != 1500 {
+		t.Errorf("JogSpeed = %v (has=%v), want 1500", md.JogSpeed, md.HasJogSpeed)
+	}
+}
+
+func TestExtractMetadata_XYBounds(t *testing.T) {
+	input := ";Header Start\n" +
+		";min_x(mm): -10\n" +
+		";max_x(mm): 100\n" +
+		";min_y(mm): -20\n" +
+		";max_y(mm): 50\n" +
+		";Header End\n" +
+		"G90\n"
+
+	md, err := ExtractMetadata(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("ExtractMetadata: %v", err)
+	}
+
+	if !md.HasXYBounds {
+		t.Fatalf("HasXYBounds = false, want true")
+	}
+	if md.MinX != -10 || md.MaxX != 100 || md.MinY != -20 || md.MaxY != 50 {
+		t.Errorf("bounds = [%v,%v]x[%v,%v], want [-10,100]x[-20,50]", md.MinX, md.MaxX, md.MinY, md.MaxY)
+	}
+}
+
+// TestExtractMetadata_CommaDecimalBoundsParseCorrectly checks that header
+// bounds written with a comma decimal separator (some European CAM posts)
+// parse the same as their dot-decimal equivalent instead of being silently
+// dropped by strconv.ParseFloat.
+func TestExtractMetadata_CommaDecimalBoundsParseCorrectly(t *testing.T) {
+	input := ";Header Start\n" +
+		";min_x(mm): -10,5\n" +
+		";max_x(mm): 100,25\n" +
+		";min_y(mm): -20,0\n" +
+		";max_y(mm): 50,75\n" +
+		";estimated_time(s): 12,5\n" +
+		";Header End\n" +
+		"G90\n"
+
+	md, err := ExtractMetadata(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("ExtractMetadata: %v", err)
+	}
+
+	if !md.HasXYBounds {
+		t.Fatalf("HasXYBounds = false, want true")
+	}
+	if md.MinX != -10.5 || md.MaxX != 100.25 || md.MinY != -20.0 || md.MaxY != 50.75 {
+		t.Errorf("bounds = [%v,%v]x[%v,%v], want [-10.5,100.25]x[-20,50.75]", md.MinX, md.MaxX, md.MinY, md.MaxY)
+	}
+	if !md.HasEstimatedTime || md.EstimatedTimeSeconds != 12.5 {
+		t.Errorf("EstimatedTimeSeconds = %v (HasEstimatedTime=%v), want 12.5", md.EstimatedTimeSeconds, md.HasEstimatedTime)
+	}
+}
+
+func TestExtractMetadata_PartialXYBoundsLeavesHasXYBoundsFalse(t *testing.T) {
+	input := ";Header Start\n" +
+		";min_x(mm): -10\n" +
+		";max_x(mm): 100\n" +
+		";Header End\n" +
+		"G90\n"
+
+	md, err := ExtractMetadata(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("ExtractMetadata: %v", err)
+	}
+
+	if md.HasXYBounds {
+		t.Fatalf("HasXYBounds = true, want false with only min_x/max_x present")
+	}
+}
+
+func TestExtractMetadataWithLimit_FindsFieldsPastDefaultScanDepthWhenRaised(t *testing.T) {
+	var b strings.Builder
+	b.WriteString(";Header Start\n")
+	for i := 0; i < 77; i++ {
+		fmt.Fprintf(&b, ";note: filler line %d\n", i)
+	}
+	b.WriteString(";min_x(mm): -10\n")
+	b.WriteString(";max_x(mm): 100\n")
+	b.WriteString(";min_y(mm): -20\n")
+	b.WriteString(";max_y(mm): 50\n")
+	b.WriteString(";Header End\n")
+	b.WriteString("G90\n")
+	input := b.String()
+
+	missed, err := ExtractMetadataWithLimit(strings.NewReader(input), DefaultHeaderScanLines)
+	if err != nil {
+		t.Fatalf("ExtractMetadataWithLimit: %v", err)
+	}
+	if missed.HasXYBounds {
+		t.Fatalf("HasXYBounds = true at the default scan depth, want false: bounds sit past line %d", DefaultHeaderScanLines)
+	}
+
+	found, err := ExtractMetadataWithLimit(strings.NewReader(input), 100)
+	if err != nil {
+		t.Fatalf("ExtractMetadataWithLimit: %v", err)
+	}
+	if !found.HasXYBounds {
+		t.Fatalf("HasXYBounds = false with a raised limit, want true")
+	}
+	if found.MinX != -10 || found.MaxX != 100 || found.MinY != -20 || found.MaxY != 50 {
+		t.Errorf("bounds = [%v,%v]x[%v,%v], want [-10,100]x[-20,50]", found.MinX, found.MaxX, found.MinY, found.MaxY)
+	}
+}
@@ -0,0 +1,45 @@
+// Package logging provides a minimal structured logger for observability,
+// used by both the CLI and the optimizer library to report progress
+// events without coupling either to a specific output format.
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Logger emits phase events. Fields is an optional set of extra key/value
+// pairs to attach to the event.
+type Logger interface {
+	Log(phase string, fields map[string]any)
+}
+
+// NopLogger discards all events.
+type NopLogger struct{}
+
+// Log implements Logger.
+func (NopLogger) Log(string, map[string]any) {}
+
+// JSONLogger writes one JSON object per event to an io.Writer, each with
+// "time" and "phase" fields plus any caller-supplied fields.
+type JSONLogger struct {
+	W io.Writer
+}
+
+// Log implements Logger.
+func (l JSONLogger) Log(phase string, fields map[string]any) {
+	event := map[string]any{
+		"time":  time.Now().UTC().Format(time.RFC3339Nano),
+		"phase": phase,
+	}
+	for k, v := range fields {
+		event[k] = v
+	}
+	b, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	fmt.Fprintln(l.W, string(b))
+}
@@ -0,0 +1,153 @@
+package optimizer
+
+import (
+	"bufio"
+	"io"
+	"strings"
+
+	"github.com/chrisns/snapmaker-cnc-finisher/internal/gcode"
+)
+
+// MoveClassification categorizes a single line of a G-code program for
+// Classify.
+type MoveClassification int
+
+const (
+	// ClassNonCutting is every line that isn't a G1/G2/G3 cutting move:
+	// G0 rapids, structural commands, comments, and blank lines.
+	ClassNonCutting MoveClassification = iota
+	// ClassShallow is a cutting move whose whole length stays shallow.
+	ClassShallow
+	// ClassDeep is a cutting move whose whole length stays deep.
+	ClassDeep
+	// ClassCrossing is a cutting move that crosses the depth threshold
+	// between its start and end point.
+	ClassCrossing
+)
+
+// Options controls Classify, the read-only counterpart of Config used for
+// reporting rather than rewriting a program.
+type Options struct {
+	// Allowance and Reference/ReferenceZ are interpreted exactly as in
+	// Config: a move is shallow when its Z is above (ReferenceZ -
+	// Allowance).
+	Allowance  float64
+	Reference  ReferenceMode
+	ReferenceZ float64
+	// SurfaceBoundary mirrors Config.SurfaceBoundary: whether a move
+	// exactly at the threshold counts as shallow (SurfaceRemove) or deep
+	// (SurfaceKeep, the default).
+	SurfaceBoundary SurfaceBoundary
+	// Metadata is the job's header metadata; Classify doesn't currently
+	// use it beyond accepting it for parity with Config.
+	Metadata gcode.Metadata
+	// Tolerance mirrors Config.Tolerance: a safety margin folded into
+	// Allowance before classifying, so a move within Tolerance of the
+	// threshold counts as deep rather than shallow.
+	Tolerance float64
+	// ZAlias mirrors Config.ZAlias: an additional axis letter treated as
+	// depth alongside Z.
+	ZAlias byte
+}
+
+// effectiveAllowance mirrors Config.effectiveAllowance.
+func (o Options) effectiveAllowance() float64 {
+	return o.Allowance - o.Tolerance
+}
+
+// Classification is Classify's result: how many lines of the input fall
+// into each MoveClassification, plus the Z range they span and the
+// resolved depth threshold they were measured against.
+type Classification struct {
+	LinesIn int
+
+	NonCuttingLines int
+	ShallowMoves    int
+	DeepMoves       int
+	CrossingMoves   int
+
+	// MinZ and MaxZ are the lowest and highest Z reached by any G1/G2/G3
+	// cutting move. HasZRange is false if the program has no cutting moves.
+	MinZ, MaxZ float64
+	HasZRange  bool
+
+	// Threshold is the resolved depth threshold
+	// (ReferenceZ - Allowance + Tolerance) moves were classified against.
+	Threshold float64
+}
+
+// Classify scans r and reports how its moves split across shallow, deep,
+// crossing, and non-cutting lines, without writing any output. It's a
+// richer read-only analysis than Analyze, which only reports
+// keep/remove/warning Statistics; Classify instead exposes the raw
+// crossing classification so tooling can make its own filtering decisions.
+// It makes its own single forward pass over r with a fresh modal State,
+// reusing the same IsShallowDepth/ClassifyCrossing logic Optimize itself
+// uses, so the two can never disagree about what counts as shallow.
+func Classify(r io.Reader, opts Options) (Classification, error) {
+	var c Classification
+	c.Threshold = opts.ReferenceZ - opts.effectiveAllowance()
+
+	state := NewState()
+	state.DepthAlias = opts.ZAlias
+	br := bufio.NewReaderSize(r, 64*1024)
+
+	lineNo := 0
+	for {
+		raw, terminated, err := readLine(br)
+		if err != nil {
+			return c, err
+		}
+		if raw == "" && !terminated {
+			break
+		}
+		lineNo++
+		c.LinesIn++
+
+		line := gcode.Parse(strings.TrimSuffix(raw, "\r"), lineNo)
+
+		prevZ := state.Z
+		UpdateState(state, line)
+
+		switch line.Code {
+		case "G1", "G2", "G3":
+			// G0 is deliberately excluded here: a rapid can pre-position
+			// anywhere, including well below the deepest real cut, and
+			// folding that into MinZ/MaxZ would skew the reported Z range
+			// (and anything derived from it, like a threshold based on
+			// the deepest cut) around positioning moves rather than
+			// actual material removal.
+			if !c.HasZRange {
+				c.MinZ, c.MaxZ = state.Z, state.Z
+				c.HasZRange = true
+			} else {
+				if state.Z < c.MinZ {
+					c.MinZ = state.Z
+				}
+				if state.Z > c.MaxZ {
+					c.MaxZ = state.Z
+				}
+			}
+		}
+
+		switch line.Code {
+		case "G1", "G2", "G3":
+			startShallow := IsShallowDepth(prevZ, opts.ReferenceZ, opts.effectiveAllowance(), opts.SurfaceBoundary)
+			endShallow := IsShallowDepth(state.Z, opts.ReferenceZ, opts.effectiveAllowance(), opts.SurfaceBoundary)
+			switch ClassifyCrossing(startShallow, endShallow) {
+			case CrossingEnter, CrossingLeave:
+				c.CrossingMoves++
+			default:
+				if endShallow {
+					c.ShallowMoves++
+				} else {
+					c.DeepMoves++
+				}
+			}
+		default:
+			c.NonCuttingLines++
+		}
+	}
+
+	return c, nil
+}
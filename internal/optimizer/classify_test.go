@@ -0,0 +1,77 @@
+package optimizer
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestClassify_CountsSumToLinesIn(t *testing.T) {
+	input := "G90\n" +
+		"G0 X0 Z5\n" +
+		"G1 X1 Z0.5 F300\n" + // stays shallow (prev Z5, end Z0.5, threshold 0)
+		"G1 X2 Z-1 F300\n" + // crosses shallow->deep
+		"G1 X3 Z-1 F300\n" + // stays deep
+		"M5\n"
+
+	c, err := Classify(strings.NewReader(input), Options{Allowance: 0, Reference: ReferenceSurface, ReferenceZ: 0})
+	if err != nil {
+		t.Fatalf("Classify: %v", err)
+	}
+
+	if c.LinesIn != 6 {
+		t.Fatalf("LinesIn = %d, want 6", c.LinesIn)
+	}
+	sum := c.NonCuttingLines + c.ShallowMoves + c.DeepMoves + c.CrossingMoves
+	if sum != c.LinesIn {
+		t.Fatalf("classification counts sum to %d, want LinesIn %d", sum, c.LinesIn)
+	}
+	if c.ShallowMoves != 1 {
+		t.Fatalf("ShallowMoves = %d, want 1", c.ShallowMoves)
+	}
+	if c.DeepMoves != 1 {
+		t.Fatalf("DeepMoves = %d, want 1", c.DeepMoves)
+	}
+	if c.CrossingMoves != 1 {
+		t.Fatalf("CrossingMoves = %d, want 1", c.CrossingMoves)
+	}
+	if c.NonCuttingLines != 3 {
+		t.Fatalf("NonCuttingLines = %d, want 3 (G90, G0, M5)", c.NonCuttingLines)
+	}
+	// G0 X0 Z5 is excluded from the range: only cutting moves (G1/G2/G3)
+	// count, so the deepest/shallowest points come from the G1 lines.
+	if !c.HasZRange || c.MinZ != -1 || c.MaxZ != 0.5 {
+		t.Fatalf("ZRange = [%v,%v] (has=%v), want [-1,0.5]", c.MinZ, c.MaxZ, c.HasZRange)
+	}
+	if c.Threshold != 0 {
+		t.Fatalf("Threshold = %v, want 0", c.Threshold)
+	}
+}
+
+func TestClassify_ZRangeIgnoresRapidPositioningDepth(t *testing.T) {
+	// The G0 pre-positions far deeper (Z-50) than any actual cut; the
+	// reported MinZ should still reflect only the cutting moves, not the
+	// rapid.
+	input := "G0 X0 Z-50\nG1 X1 Z-2 F300\nG1 X2 Z-1 F300\n"
+
+	c, err := Classify(strings.NewReader(input), Options{Allowance: 0, Reference: ReferenceSurface, ReferenceZ: 0})
+	if err != nil {
+		t.Fatalf("Classify: %v", err)
+	}
+
+	if !c.HasZRange || c.MinZ != -2 || c.MaxZ != -1 {
+		t.Fatalf("ZRange = [%v,%v] (has=%v), want [-2,-1] (the G0's Z-50 ignored)", c.MinZ, c.MaxZ, c.HasZRange)
+	}
+}
+
+func TestClassify_NoMotionLinesHasZRangeFalse(t *testing.T) {
+	c, err := Classify(strings.NewReader("G90\nM3 S1000\n"), Options{})
+	if err != nil {
+		t.Fatalf("Classify: %v", err)
+	}
+	if c.HasZRange {
+		t.Fatal("HasZRange should be false with no motion commands")
+	}
+	if c.NonCuttingLines != 2 {
+		t.Fatalf("NonCuttingLines = %d, want 2", c.NonCuttingLines)
+	}
+}
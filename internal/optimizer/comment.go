@@ -0,0 +1,17 @@
+package optimizer
+
+// DefaultCommentPrefix is used when Config.CommentPrefix is empty.
+const DefaultCommentPrefix = ";"
+
+// formatComment wraps text in the configured comment delimiter: a leading
+// "; " for the default semicolon style, or a balanced "( ... )" pair for
+// controllers that use parenthesis comments instead.
+func formatComment(prefix, text string) string {
+	if prefix == "" {
+		prefix = DefaultCommentPrefix
+	}
+	if prefix == "(" {
+		return "( " + text + " )"
+	}
+	return prefix + " " + text
+}
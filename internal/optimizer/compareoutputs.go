@@ -0,0 +1,58 @@
+package optimizer
+
+import (
+	"bufio"
+	"io"
+)
+
+// CompareOutputs computes comparison Statistics for an original file and an
+// already-produced optimized file, without re-running any filtering logic -
+// the backing for -summary-only, when a report is wanted after the fact
+// from two files already on disk instead of the usual single forward pass
+// over one file that both filters and counts at once.
+//
+// Only the fields line/byte counting alone can derive are populated:
+// LinesIn, LinesOut, LinesRemoved, BytesIn, and BytesOut. Everything else
+// (warnings, spans, time estimates, ...) depends on the per-line removal
+// decisions OptimizeContext makes and is left zero.
+func CompareOutputs(original, optimized io.Reader) (Statistics, error) {
+	var stats Statistics
+
+	linesIn, bytesIn, err := countLinesAndBytes(original)
+	if err != nil {
+		return stats, err
+	}
+	linesOut, bytesOut, err := countLinesAndBytes(optimized)
+	if err != nil {
+		return stats, err
+	}
+
+	stats.LinesIn = linesIn
+	stats.LinesOut = linesOut
+	if linesIn > linesOut {
+		stats.LinesRemoved = linesIn - linesOut
+	}
+	stats.BytesIn = bytesIn
+	stats.BytesOut = bytesOut
+	return stats, nil
+}
+
+// countLinesAndBytes counts r's lines (including a final unterminated one)
+// and total bytes, using readLine and the same BytesIn accounting
+// OptimizeContext's main loop uses, so a -summary-only report and a normal
+// run agree on what "in" and "out" mean for the same files.
+func countLinesAndBytes(r io.Reader) (lines int, bytes int64, err error) {
+	br := bufio.NewReaderSize(r, 64*1024)
+	for {
+		raw, terminated, err := readLine(br)
+		if err != nil {
+			return lines, bytes, err
+		}
+		if raw == "" && !terminated {
+			break
+		}
+		lines++
+		bytes += int64(len(raw)) + 1
+	}
+	return lines, bytes, nil
+}
@@ -0,0 +1,44 @@
+package optimizer
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCompareOutputs_ReductionStatsMatchAPreMadeOptimizedFile(t *testing.T) {
+	original := "G1 X1 Y0 Z5 F300\n" +
+		"G1 X1 Y0 Z-5 F300\n" +
+		"G1 X2 Y0 Z5 F300\n"
+	optimized := "G1 X1 Y0 Z-5 F300\n"
+
+	stats, err := CompareOutputs(strings.NewReader(original), strings.NewReader(optimized))
+	if err != nil {
+		t.Fatalf("CompareOutputs: %v", err)
+	}
+	if stats.LinesIn != 3 {
+		t.Fatalf("LinesIn = %d, want 3", stats.LinesIn)
+	}
+	if stats.LinesOut != 1 {
+		t.Fatalf("LinesOut = %d, want 1", stats.LinesOut)
+	}
+	if stats.LinesRemoved != 2 {
+		t.Fatalf("LinesRemoved = %d, want 2", stats.LinesRemoved)
+	}
+	if stats.ReductionPercent() < 66 || stats.ReductionPercent() > 67 {
+		t.Fatalf("ReductionPercent = %v, want ~66.7", stats.ReductionPercent())
+	}
+}
+
+func TestCompareOutputs_LinesRemovedIsZeroWhenOutputIsNotSmaller(t *testing.T) {
+	original := "G1 X1 Y0 Z-5 F300\n"
+	optimized := "G1 X1 Y0 Z-5 F300\n" +
+		"; stamp\n"
+
+	stats, err := CompareOutputs(strings.NewReader(original), strings.NewReader(optimized))
+	if err != nil {
+		t.Fatalf("CompareOutputs: %v", err)
+	}
+	if stats.LinesRemoved != 0 {
+		t.Fatalf("LinesRemoved = %d, want 0", stats.LinesRemoved)
+	}
+}
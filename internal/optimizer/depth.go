@@ -0,0 +1,90 @@
+package optimizer
+
+// ReferenceMode selects what a Z value is measured against when deciding
+// whether a move is "shallow" (safe to remove) or "deep" (must be kept).
+type ReferenceMode int
+
+const (
+	// ReferenceSurface measures depth relative to the stock surface, Z=0.
+	// This is the common case: Luban G-code zeroes Z at the top of the
+	// stock, so referenceZ is conventionally 0 regardless of allowance.
+	ReferenceSurface ReferenceMode = iota
+	// ReferenceMachineOrigin measures depth relative to the machine's Z
+	// origin rather than the stock surface. referenceZ should be the
+	// machine-space Z of the stock surface (e.g. a negative number if the
+	// origin sits above the stock), not 0.
+	ReferenceMachineOrigin
+	// ReferenceMetadata uses a reference Z read from the file's Luban
+	// header metadata (e.g. a stated stock thickness). referenceZ is
+	// whatever value was parsed from that header, in file units.
+	ReferenceMetadata
+)
+
+// SurfaceBoundary controls how a move sitting exactly on the reference
+// plane (z == referenceZ-allowance - the common case is Z=0 with zero
+// allowance) is classified, since callers disagree on whether a move
+// exactly at the surface is still cutting or just skimming it.
+type SurfaceBoundary int
+
+const (
+	// SurfaceKeep treats a move exactly at the boundary as deep, so it's
+	// kept. This is the default, matching IsShallowDepth's historical
+	// strict "z > threshold" behavior.
+	SurfaceKeep SurfaceBoundary = iota
+	// SurfaceRemove treats a move exactly at the boundary as shallow, so
+	// it's removed along with everything strictly above it.
+	SurfaceRemove
+)
+
+// isShallow reports whether z counts as shallow relative to threshold under
+// boundary: SurfaceKeep requires z strictly above threshold; SurfaceRemove
+// also counts z == threshold as shallow.
+func isShallow(z, threshold float64, boundary SurfaceBoundary) bool {
+	if boundary == SurfaceRemove {
+		return z >= threshold
+	}
+	return z > threshold
+}
+
+// IsShallowDepth reports whether a move at the given Z is shallow enough to
+// be removed: above (referenceZ - allowance), regardless of which
+// ReferenceMode produced referenceZ. With allowance=0, only moves above the
+// reference surface (z > referenceZ, or z >= referenceZ under
+// SurfaceRemove) are shallow; this holds identically for all three
+// reference modes once referenceZ has been resolved into the same
+// coordinate space as z. boundary controls whether a move exactly at the
+// threshold is shallow (SurfaceRemove) or deep (SurfaceKeep, the default).
+func IsShallowDepth(z, referenceZ, allowance float64, boundary SurfaceBoundary) bool {
+	return isShallow(z, referenceZ-allowance, boundary)
+}
+
+// RotaryBThreshold is the minimum B-axis rotation, in degrees, that
+// ShouldFilterMove treats as a meaningful rotary contour move rather than
+// noise, when rotary mode is enabled.
+const RotaryBThreshold = 0.01
+
+// ShouldFilterMove reports whether a move should be removed, given its
+// plain Z-depth classification (shallow) and, in rotary mode, how far its B
+// axis rotated (deltaB). Rotary mode is best-effort: on 4-axis jobs "depth"
+// is really radial and a Z-shallow reading can still be an essential
+// contour move, so any move that rotates B by more than RotaryBThreshold is
+// kept regardless of shallow.
+//
+// This is a thin wrapper around FilterMove/DefaultPredicates for callers
+// that only have the shallow/deltaB/rotary summary rather than a full
+// MoveContext; shallow is encoded as a Z/Threshold pair (1/0 vs -1/0) since
+// depthPredicate only ever compares the two. StartZ is set equal to Z, so
+// crossingPredicate always sees a NoCrossing move and defers to
+// depthPredicate - there's no start position to classify a genuine
+// crossing from here, and this wrapper's callers never had one before
+// crossingPredicate existed either.
+func ShouldFilterMove(shallow bool, deltaB float64, rotary bool) bool {
+	ctx := MoveContext{Threshold: 0, DeltaB: deltaB, Rotary: rotary}
+	if shallow {
+		ctx.Z = 1
+	} else {
+		ctx.Z = -1
+	}
+	ctx.StartZ = ctx.Z
+	return FilterMove(ctx)
+}
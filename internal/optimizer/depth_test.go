@@ -0,0 +1,82 @@
+package optimizer
+
+import "testing"
+
+func TestIsShallowDepth_ZeroAllowanceAcrossReferenceModes(t *testing.T) {
+	cases := []struct {
+		name        string
+		mode        ReferenceMode
+		referenceZ  float64
+		z           float64
+		wantShallow bool
+	}{
+		{"surface above", ReferenceSurface, 0, 0.5, true},
+		{"surface at", ReferenceSurface, 0, 0, false},
+		{"surface below", ReferenceSurface, 0, -0.5, false},
+
+		{"machine origin above", ReferenceMachineOrigin, -10, -9.5, true},
+		{"machine origin at", ReferenceMachineOrigin, -10, -10, false},
+		{"machine origin below", ReferenceMachineOrigin, -10, -10.5, false},
+
+		{"metadata above", ReferenceMetadata, 3, 3.2, true},
+		{"metadata at", ReferenceMetadata, 3, 3, false},
+		{"metadata below", ReferenceMetadata, 3, 2.8, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := IsShallowDepth(c.z, c.referenceZ, 0, SurfaceKeep)
+			if got != c.wantShallow {
+				t.Errorf("mode=%v z=%v referenceZ=%v: got %v, want %v", c.mode, c.z, c.referenceZ, got, c.wantShallow)
+			}
+		})
+	}
+}
+
+func TestIsShallowDepth_SurfaceBoundaryAtTheReferencePlaneAcrossReferenceModes(t *testing.T) {
+	cases := []struct {
+		name       string
+		mode       ReferenceMode
+		referenceZ float64
+		z          float64
+	}{
+		{"surface", ReferenceSurface, 0, 0},
+		{"machine origin", ReferenceMachineOrigin, -10, -10},
+		{"metadata", ReferenceMetadata, 3, 3},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := IsShallowDepth(c.z, c.referenceZ, 0, SurfaceKeep); got {
+				t.Errorf("SurfaceKeep: mode=%v z=%v referenceZ=%v: got shallow, want deep (kept)", c.mode, c.z, c.referenceZ)
+			}
+			if got := IsShallowDepth(c.z, c.referenceZ, 0, SurfaceRemove); !got {
+				t.Errorf("SurfaceRemove: mode=%v z=%v referenceZ=%v: got deep, want shallow (removed)", c.mode, c.z, c.referenceZ)
+			}
+		})
+	}
+}
+
+func TestShouldFilterMove_RotaryKeepsSignificantBRotation(t *testing.T) {
+	cases := []struct {
+		name    string
+		shallow bool
+		deltaB  float64
+		rotary  bool
+		want    bool
+	}{
+		{"non-rotary shallow still filtered", true, 45, false, true},
+		{"rotary shallow but big B rotation is kept", true, 45, true, false},
+		{"rotary shallow with negligible B rotation is filtered", true, 0.001, true, true},
+		{"rotary deep is never filtered regardless of B", false, 45, true, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := ShouldFilterMove(c.shallow, c.deltaB, c.rotary)
+			if got != c.want {
+				t.Errorf("ShouldFilterMove(%v, %v, %v) = %v, want %v", c.shallow, c.deltaB, c.rotary, got, c.want)
+			}
+		})
+	}
+}
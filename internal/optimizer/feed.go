@@ -0,0 +1,75 @@
+package optimizer
+
+import (
+	"math"
+
+	"github.com/chrisns/snapmaker-cnc-finisher/internal/gcode"
+)
+
+// ClampFeedRate caps line's F word at maxFeed, if present and over it. The
+// returned line is otherwise identical; clamped reports whether a change
+// was made, so callers can warn and count occurrences without re-checking.
+// A line with no F word, or one at or below maxFeed, is returned unchanged
+// (and not marked Synthesized, so passthrough lines keep their exact
+// original text).
+func ClampFeedRate(line gcode.Line, maxFeed float64, precision int) (out gcode.Line, clamped bool) {
+	f, ok := line.Get('F')
+	if !ok || f <= maxFeed {
+		return line, false
+	}
+
+	out = line
+	out.Synthesized = true
+	out.Params = make([]gcode.Param, len(line.Params))
+	copy(out.Params, line.Params)
+	for i, p := range out.Params {
+		if p.Letter == 'F' {
+			out.Params[i] = gcode.Param{Letter: 'F', Value: maxFeed, Raw: formatCoord(maxFeed, precision)}
+		}
+	}
+	return out, true
+}
+
+// FixInvalidFeed replaces line's F word with replacement if it's present and
+// non-positive (F0, or a malformed negative value). A line with no F word,
+// or one that's already positive, is returned unchanged.
+func FixInvalidFeed(line gcode.Line, replacement float64, precision int) (out gcode.Line, fixed bool) {
+	f, ok := line.Get('F')
+	if !ok || f > 0 {
+		return line, false
+	}
+
+	out = line
+	out.Synthesized = true
+	out.Params = make([]gcode.Param, len(line.Params))
+	copy(out.Params, line.Params)
+	for i, p := range out.Params {
+		if p.Letter == 'F' {
+			out.Params[i] = gcode.Param{Letter: 'F', Value: replacement, Raw: formatCoord(replacement, precision)}
+		}
+	}
+	return out, true
+}
+
+// StripRedundantFeed drops line's F word if it's within feedRateEpsilon of
+// modalFeed, since the controller already has that feed rate in effect from
+// an earlier line. The caller is responsible for not calling this across a
+// G0 or a change of motion command, where modal feed continuity isn't
+// something worth relying on. A line with no F word is returned unchanged.
+func StripRedundantFeed(line gcode.Line, modalFeed float64) (out gcode.Line, stripped bool) {
+	f, ok := line.Get('F')
+	if !ok || math.Abs(f-modalFeed) > feedRateEpsilon {
+		return line, false
+	}
+
+	out = line
+	out.Synthesized = true
+	out.Params = make([]gcode.Param, 0, len(line.Params)-1)
+	for _, p := range line.Params {
+		if p.Letter == 'F' {
+			continue
+		}
+		out.Params = append(out.Params, p)
+	}
+	return out, true
+}
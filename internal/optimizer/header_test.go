@@ -0,0 +1,38 @@
+package optimizer
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestOptimize_PreservesHeaderVerbatim ensures the Luban header block is
+// reproduced byte-for-byte: no re-serialization through Line.String()
+// should touch comment spacing or field order.
+func TestOptimize_PreservesHeaderVerbatim(t *testing.T) {
+	data, err := os.ReadFile("testdata/header_block.gcode")
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+
+	out := &bytes.Buffer{}
+	if _, err := Optimize(bytes.NewReader(data), out, Config{Allowance: 0, Reference: ReferenceSurface, ReferenceZ: 0}); err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+
+	headerLines := []string{
+		";Header Start",
+		";header_type: cnc",
+		";file_total_lines: 4",
+		";estimated_time(s): 12.5",
+		";Header End",
+		"",
+	}
+	got := strings.Split(out.String(), "\n")
+	for i, want := range headerLines {
+		if got[i] != want {
+			t.Fatalf("line %d = %q, want %q", i, got[i], want)
+		}
+	}
+}
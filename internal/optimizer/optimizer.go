@@ -0,0 +1,1234 @@
+// Package optimizer implements the "finishing" pass: filtering a Snapmaker
+// Luban G-code program down to the moves that cut below a given depth
+// allowance, so a roughed part can be re-run for just its finishing moves.
+package optimizer
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/chrisns/snapmaker-cnc-finisher/internal/gcode"
+	"github.com/chrisns/snapmaker-cnc-finisher/internal/writer"
+)
+
+// Config controls how a program is optimized.
+type Config struct {
+	// Allowance is the material thickness, in file units, to keep above
+	// the reference depth. Moves above (ReferenceZ - Allowance) are
+	// considered shallow and removed.
+	Allowance float64
+	// Reference selects what ReferenceZ is measured against.
+	Reference ReferenceMode
+	// ReferenceZ is the Z value moves are compared to. For
+	// ReferenceSurface this is conventionally 0.
+	ReferenceZ float64
+	// SurfaceBoundary controls whether a move exactly at the reference
+	// plane (ReferenceZ - Allowance, e.g. Z=0 with zero Allowance) is
+	// classified as shallow (SurfaceRemove) or deep (SurfaceKeep, the
+	// default).
+	SurfaceBoundary SurfaceBoundary
+	// Invert flips which side of the threshold is kept: instead of
+	// removing shallow moves to produce a finishing pass, it removes deep
+	// moves to produce a "skim only the top" pass. Structural commands
+	// (everything but G1/G2/G3) are unaffected either way.
+	Invert bool
+	// ByLayer, when set, breaks Statistics.Sections down per "; Layer N"
+	// or tool-change checkpoint comment, in addition to the file totals.
+	ByLayer bool
+	// Precision is the number of decimal places SplitMove rounds
+	// generated coordinates to. Zero means DefaultPrecision.
+	Precision int
+	// NormalizeEndings forces a consistent line terminator in the output:
+	// "lf" for "\n", "crlf" for "\r\n". Empty (the default) is "auto": the
+	// output replicates whichever terminator the input's first line used,
+	// so a caller writing to a plain io.Writer never has to sniff a
+	// filename or detect this itself.
+	NormalizeEndings string
+	// Strategy selects how threshold-crossing moves are handled. Empty
+	// means StrategySafe.
+	Strategy Strategy
+	// DumpRemoved, if set, receives every removed move as valid G-code
+	// (including its original feed rate), so the cut material can be
+	// visualized separately from the kept output.
+	DumpRemoved io.Writer
+	// KeepZeroLength disables the default behavior of dropping cutting
+	// moves that don't change the tracked position (within
+	// zeroLengthEpsilon), which otherwise accumulate after splitting or
+	// merging and waste a line without cutting anything.
+	KeepZeroLength bool
+	// Metadata is the job's header metadata, as extracted by
+	// gcode.ExtractMetadata. It's the caller's responsibility to extract
+	// it (typically by reading the header before rewinding r), since
+	// Optimize itself only makes a single forward pass over r.
+	Metadata gcode.Metadata
+	// Force allows a run to proceed despite a condition that would
+	// otherwise be refused, such as a non-safe Strategy on a 4-axis job.
+	Force bool
+	// Rotary enables experimental B-axis-aware filtering on 4-axis jobs
+	// (Metadata.Is4Axis): a move is kept outright if it rotates B by more
+	// than RotaryBThreshold, regardless of its Z-depth classification.
+	Rotary bool
+	// CalibratedFeedRate, if set (> 0), is used in place of each removed
+	// move's own feed rate/mode when reporting TimeSavedSeconds. See
+	// CalibratedFeedRate (the function) for how to derive it from a
+	// header's estimated_time and the program's total travel distance.
+	CalibratedFeedRate float64
+	// MaxFeed, if set (> 0), caps every emitted F word at this value
+	// (mm/min or the file's native units), warning when clamping occurs.
+	// Useful after merging collinear moves or on pathological inputs that
+	// might otherwise exceed the machine's real maximum.
+	MaxFeed float64
+	// Stamp, when set, writes an "optimized_by" comment as the first line
+	// of output, so a downstream viewer can tell the file was processed.
+	Stamp bool
+	// CommentPrefix selects the delimiter for comments the tool writes
+	// itself (currently just Stamp): ";" (the default) or "(", the two
+	// styles G-code controllers accept.
+	CommentPrefix string
+	// SplitSize, if set (> 0), caps each output part at roughly this many
+	// bytes. When the next line would push the current part over the
+	// limit, Optimize closes it with a generated safety retract and
+	// spindle-off, then calls NewPart to start the next one, replaying
+	// the leading header comments and the last spindle-on command so
+	// each part is independently runnable.
+	SplitSize int64
+	// NewPart supplies the io.Writer for part n (n >= 2; part 1 is always
+	// the w passed to Optimize/OptimizeContext itself). Required when
+	// SplitSize > 0. If the returned writer implements io.Closer, it's
+	// closed once that part is finished.
+	NewPart func(part int) (io.Writer, error)
+	// Checksum, when set, appends a trailing comment with a CRC32 (IEEE)
+	// checksum and line count of the emitted lines, for integrity
+	// verification after transferring the file to a machine. Each
+	// -split-size part gets its own independent checksum over just its
+	// own lines.
+	Checksum bool
+	// StripRedundantFeed drops an emitted F word when it repeats the
+	// current modal feed rate (within feedRateEpsilon), since the
+	// controller already has that feed rate in effect. The first F after
+	// a G0 or a change of motion command is always kept, since modal feed
+	// continuity across those isn't something worth relying on.
+	StripRedundantFeed bool
+	// CheckBounds warns when an emitted move's X or Y endpoint falls
+	// outside Metadata's declared work area (Metadata.HasXYBounds). It's a
+	// catch for split math errors or a bad input file, not a filter: an
+	// out-of-bounds move is still written, only flagged.
+	CheckBounds bool
+	// SpringPass, when set, re-emits the final contiguous run of kept
+	// cutting moves at a single Z level a second time at the end of
+	// output - a no-stepdown "spring pass" over the deepest contour, for
+	// surface finish. Doing this while streaming requires buffering that
+	// level's moves rather than passing them straight through, so the
+	// buffered region is capped at springPassMaxBufferedLines; a level
+	// longer than that only has its most recent lines repeated, with a
+	// warning.
+	SpringPass bool
+	// MinZ and MaxZ are the program's full cut-depth span, as computed by
+	// ZRange before Optimize's own single forward pass (the same pattern
+	// CalibratedFeedRate uses with TotalDistance). HasZRange is false
+	// unless the caller supplies them, in which case Allowance is never
+	// checked against the toolpath's actual depth.
+	MinZ, MaxZ float64
+	HasZRange  bool
+	// Renumber, when set, rewrites every emitted line's leading N-word
+	// sequentially starting at RenumberStart and counting by RenumberStep,
+	// inserting one on lines that didn't already have it - including
+	// synthesized lines like the -stamp comment, -smooth's ramp inserts,
+	// and the -split-size/-checksum/-spring-pass footers, not just lines
+	// that came from the input. This keeps the N sequence gapless after
+	// lines are removed, for controllers that expect monotonic line
+	// numbers. The one exception is FooterLines' queued tail, which is
+	// flushed byte-exact by design and so is never renumbered either.
+	Renumber bool
+	// RenumberStart is the first N value Renumber emits. Zero means 0.
+	RenumberStart int
+	// RenumberStep is the increment between successive N values Renumber
+	// emits. Zero means 10, the conventional Luban spacing.
+	RenumberStep int
+	// MovesOnly drops every emitted line that isn't a G0/G1/G2/G3 motion
+	// command, including comments and M-codes, for feeding a minimal
+	// toolpath into a simulator. The result is not a runnable program on
+	// its own (no spindle control); Optimize warns once when this is set.
+	MovesOnly bool
+	// RangeStart and RangeEnd restrict depth filtering to source lines in
+	// [RangeStart, RangeEnd] (Line.Number, 1-based and inclusive); every
+	// line outside the range passes through untouched, though modal State
+	// is still updated for it as usual, so filtering inside the range
+	// stays correct regardless of where it starts. HasRange is false
+	// unless the caller sets it, in which case the whole file is filtered.
+	RangeStart, RangeEnd int
+	HasRange             bool
+	// OptimizeRapids drops a G0 rapid that doesn't actually change
+	// position (within zeroLengthEpsilon), the way a redundant
+	// repositioning move accumulates after editing or generator quirks.
+	// It only ever applies above the depth threshold (IsShallowDepth), so
+	// a G0 that approaches or moves within the stock is always preserved
+	// untouched.
+	OptimizeRapids bool
+	// CollapseBlanks drops a blank line that immediately follows another
+	// blank line in the output, for size-sensitive transfers. The default
+	// (false) preserves every blank line Luban writes as-is, including
+	// runs of them, for readability.
+	CollapseBlanks bool
+	// Tolerance is a safety margin, in file units, subtracted from Allowance
+	// when deciding whether a move is shallow, which raises the threshold to
+	// (ReferenceZ - Allowance + Tolerance). A move within Tolerance of the
+	// threshold is kept even though it's technically shallow, so surface
+	// variation in the source file can't cause a move to be removed right
+	// at the boundary. Zero means no margin: the threshold is exactly
+	// (ReferenceZ - Allowance), as if Tolerance didn't exist.
+	Tolerance float64
+	// ZAlias, if set, is an additional axis letter (e.g. 'W' for a quill
+	// axis) that's treated as depth alongside Z: a line carrying ZAlias
+	// updates State.Z exactly as a Z word would, so every depth-based
+	// decision (filtering, modal tracking) sees it. Zero (the default)
+	// disables aliasing; only Z itself is tracked.
+	ZAlias byte
+	// ToolDiameter, if set (> 0), is the cutting tool's diameter in file
+	// units, used only to turn Statistics.RemovedXYDistance into
+	// Statistics.RemovedCoverageArea - a rough estimate of how much wasted
+	// "air cutting" area was eliminated. It doesn't affect filtering.
+	ToolDiameter float64
+	// CheckPlunge warns when a cutting run's Z reverses direction instead
+	// of moving monotonically in one direction, a common sign of a CAM
+	// bug. It's read-only, purely diagnostic: it never alters filtering. A
+	// run is a maximal sequence of consecutive G1/G2/G3 moves, the same
+	// grouping MoveContext.InCuttingRun uses; it resets on a G0 rapid.
+	CheckPlunge bool
+	// FloorThreshold and HasFloor implement the -floor safety backstop: a
+	// move ending at or below FloorThreshold is always kept, regardless of
+	// Allowance/Strategy, protecting the deepest portion of the toolpath
+	// from an over-aggressive allowance. They're computed the same way
+	// MinZ/MaxZ are, by DepthPercentile before Optimize's own forward pass.
+	// HasFloor is false unless the caller supplies them.
+	FloorThreshold float64
+	HasFloor       bool
+
+	// CollapseRetracts removes a pure-Z G0 retract immediately followed by
+	// a pure-Z G0 approach back to the same Z, with nothing else kept
+	// between them - wasted motion that removal of a shallow span
+	// commonly leaves behind. It's applied in the output stage with a
+	// one-move lookahead, after every other filtering decision.
+	CollapseRetracts bool
+
+	// ToolNumber and HasToolFilter restrict depth filtering to the
+	// section of a multi-tool file where ToolNumber is the active tool
+	// (tracked via State.Tool, last set by a "T<N>" word): every other
+	// tool's moves pass through untouched, the same way -range's lines
+	// outside the window do. HasToolFilter is false unless -tool was set.
+	ToolNumber    int
+	HasToolFilter bool
+
+	// RegionX1, RegionY1, RegionX2, RegionY2 restrict depth filtering to
+	// cutting moves whose tracked endpoint (State.X, State.Y) falls inside
+	// this rectangle; a move outside it passes through untouched, the same
+	// way -range's lines outside the window do. The rectangle's corners
+	// don't need to be given in any particular order. HasRegion is false
+	// unless -region was set, in which case the whole file is filtered.
+	RegionX1, RegionY1, RegionX2, RegionY2 float64
+	HasRegion                              bool
+
+	// FixFeed replaces a motion line's non-positive F word (F0, or a
+	// malformed negative value like F-100) with the last valid modal feed
+	// rate seen, or DefaultFeed if none has been seen yet. Without FixFeed,
+	// an invalid F word is still detected and warned about but left as-is
+	// in the output.
+	FixFeed bool
+	// DefaultFeed is the feed rate FixFeed falls back to when a non-positive
+	// F word is seen before any valid one, so a malformed first move still
+	// gets a usable feed rate instead of being left unfixed. Zero (the
+	// default) disables the fallback: a malformed F word with no prior
+	// valid feed rate is left as-is even when FixFeed is set.
+	DefaultFeed float64
+
+	// Smooth inserts a ramp move wherever removal has left a kept cutting
+	// move descending more steeply than RampAngle from the position
+	// output actually left off at - surface quality suffers when a finish
+	// pass jumps straight down into a cut that the original program
+	// reached gradually through moves that got removed as shallow. This
+	// changes the toolpath: the ramp isn't present in the source file.
+	Smooth bool
+	// RampAngle is the steepest descent angle, in degrees from horizontal,
+	// Smooth allows before inserting a ramp. Zero means DefaultRampAngle.
+	// It only ever makes a move shallower, never steeper: a move already
+	// at or below this angle is left untouched.
+	RampAngle float64
+
+	// KeepAboveSurface forces every move ending above ReferenceZ to be
+	// kept outright, regardless of Allowance or Strategy. It's for
+	// engraving jobs referenced above the stock surface, where the usual
+	// zero-allowance removal of positive-Z "air" moves would delete
+	// intended engraving travel rather than wasted motion.
+	KeepAboveSurface bool
+
+	// FooterLines, when positive, guarantees the last FooterLines physical
+	// lines of the input are written byte-exact, regardless of filtering,
+	// splitting, or any other transform - mirroring the guarantee already
+	// made for the leading header (comments and blank lines before the
+	// first motion/M command are never removed). Unlike the header, the
+	// footer (the closing retract/spindle-off/program-end sequence) has no
+	// reliable marker to detect while streaming, so the caller names how
+	// many trailing lines to protect instead.
+	FooterLines int
+}
+
+// effectiveAllowance folds Tolerance into Allowance: IsShallowDepth treats
+// the threshold as (ReferenceZ - effectiveAllowance()), which shifts it
+// conservatively by Tolerance without IsShallowDepth itself needing to know
+// about Tolerance.
+func (c Config) effectiveAllowance() float64 {
+	return c.Allowance - c.Tolerance
+}
+
+// feedRateEpsilon is the tolerance StripRedundantFeed uses when comparing
+// an F word to the tracked modal feed rate, to absorb rounding noise from
+// earlier stages (e.g. ClampFeedRate's formatCoord rounding).
+const feedRateEpsilon = 1e-6
+
+// springPassMaxBufferedLines caps how many lines of the final Z level
+// Config.SpringPass buffers in memory, since buffering conflicts with pure
+// streaming over a non-seekable io.Reader. A level longer than this only has
+// its most recent springPassMaxBufferedLines lines repeated.
+const springPassMaxBufferedLines = 5000
+
+// splitSafeRetractClearance is how far above the depth reference the
+// generated safety retract at a split boundary lifts to, in file units.
+// It's a conservative placeholder, not a machine-specific safe height.
+const splitSafeRetractClearance = 5.0
+
+// ErrRotaryStrategyUnsafe is returned when a non-safe Strategy is used on a
+// 4-axis job without Force, since Z-depth filtering alone can't tell a
+// contour move with a necessary B-axis rotation from a genuinely shallow
+// one.
+var ErrRotaryStrategyUnsafe = errors.New("strategy is unsafe for 4-axis rotary jobs without -force: Z-depth filtering can remove B-axis contour moves that aren't actually shallow")
+
+// ErrAllowanceExceedsDepth is returned when Allowance meets or exceeds the
+// toolpath's full cut depth (Config.MinZ/MaxZ) without Force, since that
+// threshold sits above the entire program and would remove every cutting
+// move - almost always a units mistake rather than an intentional allowance.
+var ErrAllowanceExceedsDepth = errors.New("allowance meets or exceeds the toolpath's full cut depth without -force: this would remove every cutting move")
+
+// ErrNonCNCToolHead is returned when the header's declared header_type
+// names a tool head other than CNC without Force, since the optimizer's
+// depth-based filtering is meaningless (and potentially destructive) on a
+// laser or 3D-printing job that was never meant to be read this way.
+var ErrNonCNCToolHead = errors.New("header declares a non-CNC tool head without -force: depth-based filtering doesn't apply to this job")
+
+// zeroLengthEpsilon is the distance, in file units, below which a move is
+// considered to not change position.
+const zeroLengthEpsilon = 1e-6
+
+func (c Config) precision() int {
+	if c.Precision == 0 {
+		return DefaultPrecision
+	}
+	return c.Precision
+}
+
+func (c Config) renumberStep() int {
+	if c.RenumberStep == 0 {
+		return 10
+	}
+	return c.RenumberStep
+}
+
+// applyRenumber rewrites line's N-word from *next and advances *next by
+// cfg's step, if cfg.Renumber is set and line isn't blank. It's called at
+// every site that emits a line - not just the ones flowing through
+// processLine's main per-line path - so synthesized lines (the -stamp
+// comment, -smooth's ramp inserts, -split-size/-checksum footers, the
+// -spring-pass repeated contour) get spliced into the same gapless N
+// sequence Config.Renumber promises instead of slipping through unnumbered.
+func applyRenumber(line gcode.Line, cfg Config, next *int) gcode.Line {
+	if !cfg.Renumber || line.Blank {
+		return line
+	}
+	line.Raw = RenumberLine(line.String(), *next)
+	line.Synthesized = false
+	*next += cfg.renumberStep()
+	return line
+}
+
+func (c Config) rampAngle() float64 {
+	if c.RampAngle == 0 {
+		return DefaultRampAngle
+	}
+	return c.RampAngle
+}
+
+// hasMotionParam reports whether line carries at least one of the axis
+// words that matter to motion (X, Y, Z, or B), so a bare coordinate-only
+// line (no command word of its own) can be told apart from an unrelated
+// code-less line, such as a blank line or one holding only a comment.
+func hasMotionParam(line gcode.Line) bool {
+	for _, letter := range [...]byte{'X', 'Y', 'Z', 'B'} {
+		if _, ok := line.Get(letter); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// regionContains reports whether (x, y) falls inside the rectangle
+// described by cfg's Region fields, in either corner order.
+func regionContains(cfg Config, x, y float64) bool {
+	minX, maxX := cfg.RegionX1, cfg.RegionX2
+	if minX > maxX {
+		minX, maxX = maxX, minX
+	}
+	minY, maxY := cfg.RegionY1, cfg.RegionY2
+	if minY > maxY {
+		minY, maxY = maxY, minY
+	}
+	return x >= minX && x <= maxX && y >= minY && y <= maxY
+}
+
+// Optimize reads a G-code program from r, removes cutting moves shallower
+// than cfg's allowance, and writes the result to w.
+func Optimize(r io.Reader, w io.Writer, cfg Config) (Statistics, error) {
+	return OptimizeContext(context.Background(), r, w, cfg)
+}
+
+// OptimizeContext is Optimize with cancellation support: if ctx is canceled
+// mid-run (e.g. on SIGINT), processing stops after the current line, the
+// output writer is flushed so it ends on a clean line boundary, and
+// ctx.Err() is returned alongside the partial Statistics gathered so far.
+//
+// Every kept move is emitted independently; there is no merge/dedupe stage
+// that could collapse two moves sharing an XY path at different Z, so
+// finishing stepdowns that retrace the same contour deeper are always
+// preserved.
+func OptimizeContext(ctx context.Context, r io.Reader, w io.Writer, cfg Config) (Statistics, error) {
+	var stats Statistics
+
+	if cfg.Metadata.Is4Axis && cfg.strategy() != StrategySafe {
+		stats.AddWarningCode(WarningRotaryUnsafe, "strategy "+string(cfg.strategy())+" is unreliable on 4-axis rotary jobs: Z-depth filtering can remove B-axis contour moves that aren't actually shallow")
+		if !cfg.Force {
+			return stats, ErrRotaryStrategyUnsafe
+		}
+	}
+
+	if ht := cfg.Metadata.HeaderType; ht != "" && !strings.EqualFold(ht, "cnc") {
+		stats.AddWarningCode(WarningNonCNCToolHead, fmt.Sprintf(
+			"header declares header_type=%s, not cnc; depth-based optimization is meaningless for this tool head", ht))
+		if !cfg.Force {
+			return stats, ErrNonCNCToolHead
+		}
+	}
+
+	if cfg.MovesOnly {
+		stats.AddWarningCode(WarningMovesOnlyNotRunnable, "moves-only output strips comments and M-codes, including spindle control; it is not a runnable program on its own")
+	}
+
+	if cfg.HasZRange && cfg.MaxZ > cfg.MinZ && cfg.Allowance >= cfg.MaxZ-cfg.MinZ {
+		stats.AddWarningCode(WarningAllowanceExceedsDepth, fmt.Sprintf(
+			"allowance %v meets or exceeds the toolpath's full cut depth %v (Z %v to %v); this removes everything and likely indicates a units mismatch",
+			cfg.Allowance, cfg.MaxZ-cfg.MinZ, cfg.MinZ, cfg.MaxZ))
+		if !cfg.Force {
+			return stats, ErrAllowanceExceedsDepth
+		}
+	}
+
+	state := NewState()
+	state.DepthAlias = cfg.ZAlias
+	accum := TimeAccumulator{CalibratedFeedRate: cfg.CalibratedFeedRate}
+	keptAccum := TimeAccumulator{CalibratedFeedRate: cfg.CalibratedFeedRate}
+
+	var spanMoves int
+	var spanDistance, spanSeconds float64
+
+	rawOut := w
+	out := writer.NewWriter(w)
+	newline := "\n"
+	autoNewline := cfg.NormalizeEndings == ""
+	switch cfg.NormalizeEndings {
+	case "crlf":
+		newline = "\r\n"
+	}
+	out.SetNewline(newline)
+	if cfg.Checksum {
+		out.EnableChecksum()
+	}
+	defer func() { out.Flush() }()
+
+	renumberNext := cfg.RenumberStart
+
+	if cfg.Stamp {
+		stampLine := gcode.Line{Comment: formatComment(cfg.CommentPrefix, "optimized_by: gcode-optimizer"), Synthesized: true}
+		stampLine = applyRenumber(stampLine, cfg, &renumberNext)
+		if err := out.WriteLine(stampLine); err != nil {
+			return stats, err
+		}
+		stats.AddedLines++
+	}
+
+	var dump *writer.Writer
+	if cfg.DumpRemoved != nil {
+		dump = writer.NewWriter(cfg.DumpRemoved)
+		dump.SetNewline(out.Newline())
+		defer dump.Flush()
+	}
+
+	var section *SectionStats
+	if cfg.ByLayer {
+		stats.Sections = append(stats.Sections, SectionStats{Name: "(before first layer)"})
+		section = &stats.Sections[len(stats.Sections)-1]
+	}
+
+	partNum := 1
+	var partBytes int64
+	var totalBytesOut int64
+	var headerLines []gcode.Line
+	inHeader := true
+	var spindleOn *gcode.Line
+	var sawSpindleOn bool
+	var warnedNoSpindle bool
+
+	var plungeRunActive bool
+	var plungeDir int
+
+	var pendingRetract *gcode.Line
+	var pendingRetractZ float64
+	// pendingRetractNFrom/To are renumberNext before and after the pending
+	// retract took its N, so a collapsed pair gives that N back.
+	var pendingRetractNFrom, pendingRetractNTo int
+
+	// outputX, outputY, outputZ track the position the output file itself
+	// last left the machine at, as opposed to prevX/prevY/prevZ below
+	// (the true continuous position per the source file's own modal
+	// state): a removed shallow span leaves the two disagreeing, which is
+	// exactly the gap cfg.Smooth ramps across.
+	var outputX, outputY, outputZ float64
+	var hasOutputPos bool
+
+	var modalFeed float64
+	var modalFeedSet bool
+	var lastMotionCode string
+	var prevLineBlank bool
+
+	var springLevelZ float64
+	var springLevelSet bool
+	var springBuffer []gcode.Line
+	springPassTruncated := false
+
+	br := bufio.NewReaderSize(r, 64*1024)
+	var crlfLines, lfLines int
+	warnedMixed := false
+
+	// lastLineTerminated tracks whether the most recently read input line
+	// ended in a newline, so a final line that didn't (no trailing newline
+	// at EOF) isn't given one it never had - out.WriteLine always appends
+	// its configured terminator otherwise, which would turn a byte-exact
+	// no-op pass into one that silently adds a trailing newline.
+	lastLineTerminated := true
+
+	type footerEntry struct {
+		raw    string
+		lineNo int
+	}
+	var footerQueue []footerEntry
+
+	processLine := func(raw string, lineNo int) error {
+		line := gcode.Parse(raw, lineNo)
+
+		isHeaderLine := false
+		if inHeader {
+			if line.Blank || strings.HasPrefix(strings.TrimSpace(line.Raw), ";") {
+				headerLines = append(headerLines, line)
+				isHeaderLine = true
+			} else {
+				inHeader = false
+			}
+		}
+		switch line.Code {
+		case "M3", "M4":
+			l := line
+			spindleOn = &l
+			sawSpindleOn = true
+		case "M5":
+			spindleOn = nil
+		case "G1", "G2", "G3":
+			if !sawSpindleOn && !warnedNoSpindle {
+				stats.AddWarningCode(WarningNoSpindleBeforeCut, "cutting moves begin before any M3/M4 spindle-on command; running this file as-is would plunge a stationary tool")
+				warnedNoSpindle = true
+			}
+		}
+
+		if cfg.ByLayer {
+			if name, ok := sectionName(line); ok {
+				stats.Sections = append(stats.Sections, SectionStats{Name: name})
+				section = &stats.Sections[len(stats.Sections)-1]
+			}
+		}
+
+		switch line.Code {
+		case "G0", "G1", "G2", "G3":
+			if f, ok := line.Get('F'); ok && f <= 0 {
+				stats.InvalidFeedRates++
+				replacement, hasReplacement := 0.0, false
+				if state.HasFeedRate && state.FeedRate > 0 {
+					replacement, hasReplacement = state.FeedRate, true
+				} else if cfg.DefaultFeed > 0 {
+					replacement, hasReplacement = cfg.DefaultFeed, true
+				}
+				if cfg.FixFeed && hasReplacement {
+					if fixedLine, fixed := FixInvalidFeed(line, replacement, cfg.precision()); fixed {
+						line = fixedLine
+						stats.ModifiedLines++
+						stats.AddWarningCode(WarningInvalidFeedRate, fmt.Sprintf(
+							"line %d: feed rate %v is not positive; replaced with %v", lineNo, f, replacement))
+					}
+				} else {
+					stats.AddWarningCode(WarningInvalidFeedRate, fmt.Sprintf(
+						"line %d: feed rate %v is not positive; pass -fix-feed to replace it with the last valid feed rate or -default-feed", lineNo, f))
+				}
+			}
+		}
+
+		prevX, prevY, prevZ, prevB := state.X, state.Y, state.Z, state.B
+		warnings := UpdateState(state, line)
+		for _, wmsg := range warnings {
+			stats.AddWarningCode(WarningSkippedFeedMode, wmsg)
+		}
+
+		if cfg.CheckPlunge {
+			switch line.Code {
+			case "G1", "G2", "G3":
+				if !plungeRunActive {
+					plungeRunActive = true
+					plungeDir = 0
+				}
+				if dz := state.Z - prevZ; dz != 0 {
+					sign := 1
+					if dz < 0 {
+						sign = -1
+					}
+					if plungeDir == 0 {
+						plungeDir = sign
+					} else if sign != plungeDir {
+						stats.AddWarningCode(WarningOscillatingPlunge, "a cutting run's Z reverses direction instead of moving monotonically; this often indicates a CAM bug")
+						plungeDir = sign
+					}
+				}
+			case "G0":
+				plungeRunActive = false
+			}
+		}
+
+		remove := false
+		removedByDepthFilter := false
+		var depthFilterDistance float64
+		inRange := !cfg.HasRange || (line.Number >= cfg.RangeStart && line.Number <= cfg.RangeEnd)
+		inTool := !cfg.HasToolFilter || state.Tool == cfg.ToolNumber
+		inRegion := !cfg.HasRegion || regionContains(cfg, state.X, state.Y)
+		// motionCode resolves a bare coordinate-only line (no G-word of
+		// its own, Luban's own output never does this but a hand-edited
+		// or third-party file sometimes does) to the modal motion command
+		// it continues, so it's filtered - and timed - the same as an
+		// explicit one. A feed-only or otherwise non-motion line with no
+		// X/Y/Z/B word is left alone; UpdateState already tracks
+		// state.FeedRate for it regardless of Code.
+		motionCode := line.Code
+		if motionCode == "" && hasMotionParam(line) {
+			switch state.Motion {
+			case "G0", "G1", "G2", "G3":
+				motionCode = state.Motion
+			}
+		}
+		switch {
+		case !inRange || !inTool || !inRegion:
+			// Outside -range, the active tool isn't -tool's target, or
+			// the move's endpoint falls outside -region: every line
+			// passes through untouched; modal state above has already
+			// been updated as usual.
+		case motionCode == "G0":
+			rapidShallow := IsShallowDepth(state.Z, cfg.ReferenceZ, cfg.effectiveAllowance(), cfg.SurfaceBoundary)
+			// A rapid is only a real crash risk if it's diving, not just
+			// traveling above the stock with XY motion - so this only
+			// fires on an endpoint below the reference surface.
+			rapidBelowSurface := !IsShallowDepth(state.Z, cfg.ReferenceZ, 0, cfg.SurfaceBoundary)
+			if rapidBelowSurface && (state.X != prevX || state.Y != prevY) {
+				stats.UnsafeRapids++
+				stats.AddWarningCode(WarningUnsafeRapid, "input contains a G0 rapid that moves in X/Y while diving below the reference Z; this is often a source-file error (G0 moves are always preserved as-is)")
+			}
+			if cfg.OptimizeRapids && rapidShallow && Distance(prevX, prevY, prevZ, state.X, state.Y, state.Z) < zeroLengthEpsilon {
+				remove = true
+			}
+		case motionCode == "G1", motionCode == "G2", motionCode == "G3":
+			if (motionCode == "G2" || motionCode == "G3") && cfg.strategy() != StrategySafe {
+				stats.AddWarningCode(WarningArcDepthImprecise, "arc (G2/G3) depth filtering only looks at the endpoint Z; a helical arc's mid-arc depth variation is ignored, which "+string(cfg.strategy())+" strategy makes more likely to matter")
+			}
+			if !cfg.KeepZeroLength && Distance(prevX, prevY, prevZ, state.X, state.Y, state.Z) < zeroLengthEpsilon {
+				remove = true
+				stats.ZeroLengthRemoved++
+				break
+			}
+
+			startShallow := IsShallowDepth(prevZ, cfg.ReferenceZ, cfg.effectiveAllowance(), cfg.SurfaceBoundary)
+			endShallow := IsShallowDepth(state.Z, cfg.ReferenceZ, cfg.effectiveAllowance(), cfg.SurfaceBoundary)
+
+			if cfg.strategy().splits() && ClassifyCrossing(startShallow, endShallow) == CrossingLeave && !cfg.Invert {
+				threshold := cfg.ReferenceZ - cfg.effectiveAllowance()
+				splitSrc := line
+				splitSrc.Code = motionCode
+				var deepPart gcode.Line
+				var split bool
+				if motionCode == "G2" || motionCode == "G3" {
+					deepPart, _, split = SplitArc(splitSrc, prevX, prevY, prevZ, threshold, cfg.precision())
+				}
+				if !split {
+					deepPart, _ = SplitMove(splitSrc, prevX, prevY, prevZ, threshold, cfg.precision())
+				}
+				ix, iy, iz := deepPart.Params[0].Value, deepPart.Params[1].Value, deepPart.Params[2].Value
+				// The discarded shallow tail - from the threshold crossing
+				// to the move's original (now unreachable) endpoint - is
+				// genuinely removed from the output, so it has to be
+				// credited the same way the generic remove path credits a
+				// whole removed move, or it simply vanishes from the
+				// stats: counted in neither accum (removed) nor keptAccum
+				// (kept), which would undercount both the original
+				// estimated time and the reported savings.
+				accum.Add(Distance(ix, iy, iz, state.X, state.Y, state.Z), state)
+				stats.RemovedXYDistance += DistanceXY(ix, iy, state.X, state.Y)
+				line = deepPart
+				// The shallow tail was never written, so the tracked
+				// position must reflect where output actually stops -
+				// the threshold crossing - not the original endpoint,
+				// or the next line's distance/crossing math would be
+				// computed from a position the tool never reached.
+				state.X, state.Y, state.Z = ix, iy, iz
+				stats.ModifiedLines++
+				break
+			}
+
+			// A CrossingEnter's shallow lead-in can't be trimmed from the
+			// output at all - there's no way to start a single G-code move
+			// partway along its own path, so the line is always emitted
+			// whole, endpoint and all. For an arc this matters doubly:
+			// recomputing I/J relative to the threshold-crossing point
+			// would center it on a position the tool never actually
+			// reaches. Since nothing is genuinely removed, the lead-in
+			// isn't credited as saved time either - it's still real,
+			// uncut travel the kept line performs, exactly like safe mode
+			// already treats it.
+
+			rotary := cfg.Rotary && cfg.Metadata.Is4Axis
+			var filter bool
+			if cfg.Invert {
+				filter = ShouldFilterMove(endShallow, state.B-prevB, rotary)
+			} else {
+				// The full MoveContext (not just endShallow) lets
+				// crossingPredicate keep an entering or leaving move
+				// outright, so a pure-Z plunge or retract with no X/Y
+				// travel is never mistaken for a shallow move just
+				// because depthPredicate alone only looks at the end Z.
+				moveCtx := MoveContext{
+					Cmd:    Command(motionCode),
+					StartX: prevX, StartY: prevY, StartZ: prevZ,
+					X: state.X, Y: state.Y, Z: state.Z,
+					Threshold:        cfg.ReferenceZ - cfg.effectiveAllowance(),
+					DeltaB:           state.B - prevB,
+					Rotary:           rotary,
+					AllAxes:          cfg.strategy() == StrategyAllAxes,
+					Boundary:         cfg.SurfaceBoundary,
+					FloorThreshold:   cfg.FloorThreshold,
+					HasFloor:         cfg.HasFloor,
+					ReferenceZ:       cfg.ReferenceZ,
+					KeepAboveSurface: cfg.KeepAboveSurface,
+				}
+				filter = FilterMove(moveCtx)
+
+				// A move the rotary protection spares is only "kept by
+				// strategy" if StrategyAllAxes would have removed it - the
+				// same move, judged the same way, with only the rotary
+				// protection disabled. Skip the extra evaluation unless
+				// it's rotary and not already running under AllAxes, where
+				// there'd be nothing to compare against.
+				if !filter && rotary && !moveCtx.AllAxes {
+					allAxesCtx := moveCtx
+					allAxesCtx.AllAxes = true
+					if FilterMove(allAxesCtx) {
+						stats.KeptByStrategy++
+					}
+				}
+			}
+			if filter != cfg.Invert {
+				remove = true
+				d := Distance(prevX, prevY, prevZ, state.X, state.Y, state.Z)
+				accum.Add(d, state)
+				stats.RemovedXYDistance += DistanceXY(prevX, prevY, state.X, state.Y)
+				removedByDepthFilter = true
+				depthFilterDistance = d
+			}
+		}
+
+		if removedByDepthFilter {
+			spanMoves++
+			spanDistance += depthFilterDistance
+			if secs, ok := CalculateTimeSaved(depthFilterDistance, state, accum.CalibratedFeedRate); ok {
+				spanSeconds += secs
+			}
+			if spanMoves > stats.LargestRemovedSpanMoves {
+				stats.LargestRemovedSpanMoves = spanMoves
+				stats.LargestRemovedSpanDistance = spanDistance
+				stats.LargestRemovedSpanSeconds = spanSeconds
+			}
+		} else {
+			spanMoves, spanDistance, spanSeconds = 0, 0, 0
+		}
+
+		// A header line (blank or a comment, before the first motion/M
+		// command) is never a candidate for removal under any strategy,
+		// regardless of what the switch above concluded - this is an
+		// explicit invariant, not an accident of header lines always
+		// parsing with an empty Code, so a future change to how header
+		// content is classified can't silently start stripping it.
+		if isHeaderLine {
+			remove = false
+		}
+
+		if remove {
+			stats.LinesRemoved++
+			if section != nil {
+				section.LinesRemoved++
+			}
+			switch motionCode {
+			case "G1", "G2", "G3":
+				if state.HasFeedRate {
+					if stats.RemovedFeedCount == 0 || state.FeedRate < stats.RemovedFeedMin {
+						stats.RemovedFeedMin = state.FeedRate
+					}
+					if state.FeedRate > stats.RemovedFeedMax {
+						stats.RemovedFeedMax = state.FeedRate
+					}
+					stats.RemovedFeedSum += state.FeedRate
+					stats.RemovedFeedCount++
+				}
+			}
+			if dump != nil {
+				if err := dump.WriteLine(line); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		switch line.Code {
+		case "G1", "G2", "G3":
+			keptAccum.Add(Distance(prevX, prevY, prevZ, state.X, state.Y, state.Z), state)
+		}
+
+		if cfg.MovesOnly {
+			switch line.Code {
+			case "G0", "G1", "G2", "G3":
+			default:
+				stats.LinesRemoved++
+				if section != nil {
+					section.LinesRemoved++
+				}
+				return nil
+			}
+		}
+
+		if cfg.CollapseBlanks && line.Blank && prevLineBlank {
+			stats.LinesRemoved++
+			if section != nil {
+				section.LinesRemoved++
+			}
+			return nil
+		}
+		prevLineBlank = line.Blank
+
+		if cfg.CheckBounds && cfg.Metadata.HasXYBounds {
+			switch line.Code {
+			case "G0", "G1", "G2", "G3":
+				if state.X < cfg.Metadata.MinX || state.X > cfg.Metadata.MaxX ||
+					state.Y < cfg.Metadata.MinY || state.Y > cfg.Metadata.MaxY {
+					stats.OutOfBoundsMoves++
+					stats.AddWarningCode(WarningOutOfBounds, fmt.Sprintf(
+						"one or more moves fall outside the header's declared work area [%v,%v]x[%v,%v]",
+						cfg.Metadata.MinX, cfg.Metadata.MaxX, cfg.Metadata.MinY, cfg.Metadata.MaxY))
+				}
+			}
+		}
+
+		if cfg.MaxFeed > 0 {
+			if clampedLine, clamped := ClampFeedRate(line, cfg.MaxFeed, cfg.precision()); clamped {
+				line = clampedLine
+				stats.FeedRateClamped++
+				stats.ModifiedLines++
+				stats.AddWarningCode(WarningFeedRateClamped, fmt.Sprintf("clamped one or more F words to -max-feed=%v", cfg.MaxFeed))
+			}
+		}
+
+		switch line.Code {
+		case "G0", "G1", "G2", "G3":
+			if f, ok := line.Get('F'); ok {
+				if cfg.StripRedundantFeed && modalFeedSet && line.Code == lastMotionCode && line.Code != "G0" {
+					if strippedLine, stripped := StripRedundantFeed(line, modalFeed); stripped {
+						line = strippedLine
+						stats.RedundantFeedStripped++
+						stats.ModifiedLines++
+					}
+				}
+				modalFeed = f
+				modalFeedSet = true
+			}
+			lastMotionCode = line.Code
+		}
+
+		if cfg.SplitSize > 0 && cfg.NewPart != nil {
+			need := int64(len(line.String())) + int64(len(out.Newline()))
+			if partBytes > 0 && partBytes+need > cfg.SplitSize {
+				if err := writeSplitFooter(out, cfg, &renumberNext); err != nil {
+					return err
+				}
+				stats.AddedLines += 2
+				if cfg.Checksum {
+					if err := writeChecksumFooter(out, cfg, &renumberNext); err != nil {
+						return err
+					}
+					stats.AddedLines++
+				}
+				if err := out.Flush(); err != nil {
+					return err
+				}
+				if closer, ok := rawOut.(io.Closer); ok {
+					closer.Close()
+				}
+
+				partNum++
+				nextW, err := cfg.NewPart(partNum)
+				if err != nil {
+					return err
+				}
+				rawOut = nextW
+				totalBytesOut += out.BytesWritten()
+				out = writer.NewWriter(nextW)
+				out.SetNewline(newline)
+				if cfg.Checksum {
+					out.EnableChecksum()
+				}
+				partBytes = 0
+
+				for _, hl := range headerLines {
+					if err := out.WriteLine(hl); err != nil {
+						return err
+					}
+					stats.AddedLines++
+				}
+				if spindleOn != nil {
+					if err := out.WriteLine(*spindleOn); err != nil {
+						return err
+					}
+					stats.AddedLines++
+				}
+			}
+			partBytes += need
+		}
+
+		if cfg.SpringPass {
+			switch line.Code {
+			case "G1", "G2", "G3":
+				if !springLevelSet || math.Abs(state.Z-springLevelZ) > zeroLengthEpsilon {
+					springLevelZ = state.Z
+					springLevelSet = true
+					springBuffer = springBuffer[:0]
+				}
+				if len(springBuffer) < springPassMaxBufferedLines {
+					springBuffer = append(springBuffer, line)
+				} else if !springPassTruncated {
+					stats.AddWarningCode(WarningSpringPassBufferCapped, fmt.Sprintf("spring pass buffer capped at %d lines; the repeated final contour may be incomplete", springPassMaxBufferedLines))
+					springPassTruncated = true
+				}
+			}
+		}
+
+		if cfg.CollapseRetracts {
+			_, hasX := line.Get('X')
+			_, hasY := line.Get('Y')
+			z, hasZ := line.Get('Z')
+			pureZRapid := line.Code == "G0" && hasZ && !hasX && !hasY
+
+			if pureZRapid && pendingRetract != nil && math.Abs(z-pendingRetractZ) < zeroLengthEpsilon {
+				// This approach returns to exactly the Z the pending
+				// retract left, with nothing kept between them: the pair
+				// is pure wasted motion, so neither line is written.
+				stats.LinesOut--
+				if section != nil {
+					section.LinesKept--
+				}
+				stats.LinesRemoved += 2
+				if section != nil {
+					section.LinesRemoved += 2
+				}
+				if renumberNext == pendingRetractNTo {
+					renumberNext = pendingRetractNFrom
+				}
+				pendingRetract = nil
+				return nil
+			}
+
+			if pendingRetract != nil {
+				if err := out.WriteLine(*pendingRetract); err != nil {
+					return err
+				}
+				outputZ = pendingRetractZ
+				hasOutputPos = true
+				pendingRetract = nil
+			}
+
+			if pureZRapid {
+				stats.LinesOut++
+				if section != nil {
+					section.LinesKept++
+				}
+				pendingRetractNFrom = renumberNext
+				l := applyRenumber(line, cfg, &renumberNext)
+				pendingRetractNTo = renumberNext
+				pendingRetract = &l
+				pendingRetractZ = z
+				return nil
+			}
+		}
+
+		if cfg.Smooth && hasOutputPos {
+			switch line.Code {
+			case "G1", "G2", "G3":
+				gap := math.Abs(outputX-prevX) > zeroLengthEpsilon ||
+					math.Abs(outputY-prevY) > zeroLengthEpsilon ||
+					math.Abs(outputZ-prevZ) > zeroLengthEpsilon
+				if gap {
+					if ramp, ok := RampMove(outputX, outputY, outputZ, line, state.X, state.Y, state.Z, cfg.rampAngle(), cfg.precision()); ok {
+						ramp = applyRenumber(ramp, cfg, &renumberNext)
+						if err := out.WriteLine(ramp); err != nil {
+							return err
+						}
+						stats.AddedLines++
+						stats.LinesOut++
+						if section != nil {
+							section.LinesKept++
+						}
+					}
+				}
+			}
+		}
+
+		stats.LinesOut++
+		if section != nil {
+			section.LinesKept++
+		}
+		line = applyRenumber(line, cfg, &renumberNext)
+		if err := out.WriteLine(line); err != nil {
+			return err
+		}
+		outputX, outputY, outputZ = state.X, state.Y, state.Z
+		hasOutputPos = true
+		return nil
+	}
+
+	lineNo := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+
+		raw, terminated, err := readLine(br)
+		if err != nil {
+			return stats, err
+		}
+		if raw == "" && !terminated {
+			break
+		}
+
+		lineNo++
+		stats.BytesIn += int64(len(raw)) + 1
+		stats.LinesIn++
+		lastLineTerminated = terminated
+
+		isCRLF := strings.HasSuffix(raw, "\r")
+		if isCRLF {
+			raw = raw[:len(raw)-1]
+			crlfLines++
+		} else if terminated {
+			lfLines++
+		}
+		if lineNo == 1 && autoNewline && terminated {
+			if isCRLF {
+				newline = "\r\n"
+			}
+			out.SetNewline(newline)
+		}
+		if crlfLines > 0 && lfLines > 0 && !warnedMixed {
+			stats.AddWarningCode(WarningMixedLineEndings, "input mixes CRLF and LF line endings; pass -normalize-endings=lf or -normalize-endings=crlf to force a consistent terminator")
+			warnedMixed = true
+		}
+
+		if cfg.FooterLines <= 0 {
+			if err := processLine(raw, lineNo); err != nil {
+				return stats, err
+			}
+			continue
+		}
+
+		// FooterLines delays every decision by up to FooterLines lines, so
+		// that whichever lines are still queued once the input runs out -
+		// the true physical tail of the file - can be flushed verbatim
+		// instead of through processLine, guaranteeing them byte-exact
+		// regardless of filtering or any other transform. A line evicted to
+		// make room for a new one wasn't the tail after all, so it's
+		// processed normally, exactly as if FooterLines were unset.
+		footerQueue = append(footerQueue, footerEntry{raw: raw, lineNo: lineNo})
+		if len(footerQueue) > cfg.FooterLines {
+			oldest := footerQueue[0]
+			footerQueue = footerQueue[1:]
+			if err := processLine(oldest.raw, oldest.lineNo); err != nil {
+				return stats, err
+			}
+		}
+	}
+
+	if cfg.FooterLines > 0 {
+		for _, entry := range footerQueue {
+			fl := gcode.Parse(entry.raw, entry.lineNo)
+			stats.LinesOut++
+			if section != nil {
+				section.LinesKept++
+			}
+			if err := out.WriteLine(fl); err != nil {
+				return stats, err
+			}
+		}
+	}
+
+	if !lastLineTerminated {
+		out.SetFinalNewline(false)
+	}
+
+	if cfg.CollapseRetracts && pendingRetract != nil {
+		if err := out.WriteLine(*pendingRetract); err != nil {
+			return stats, err
+		}
+	}
+
+	if cfg.ToolDiameter > 0 {
+		stats.RemovedCoverageArea = stats.RemovedXYDistance * cfg.ToolDiameter
+	}
+
+	stats.TimeSavedSeconds = accum.SecondsSaved
+	stats.OptimizedEstimatedSeconds = keptAccum.SecondsSaved
+	stats.OriginalEstimatedSeconds = keptAccum.SecondsSaved + accum.SecondsSaved
+	if accum.SkippedFeedModeMoves > 0 || keptAccum.SkippedFeedModeMoves > 0 {
+		stats.AddWarningCode(WarningSkippedFeedMode, "time estimate excludes moves made under G93/G95 feed modes")
+	}
+
+	if cfg.SpringPass && len(springBuffer) > 0 {
+		marker := gcode.Line{Comment: formatComment(cfg.CommentPrefix, "spring pass: repeating final contour"), Synthesized: true}
+		marker = applyRenumber(marker, cfg, &renumberNext)
+		if err := out.WriteLine(marker); err != nil {
+			return stats, err
+		}
+		stats.AddedLines++
+		for _, sl := range springBuffer {
+			sl = applyRenumber(sl, cfg, &renumberNext)
+			if err := out.WriteLine(sl); err != nil {
+				return stats, err
+			}
+			stats.AddedLines++
+		}
+	}
+
+	if cfg.Metadata.FileTotalLines != 0 {
+		if delta := stats.LinesIn - cfg.Metadata.FileTotalLines; delta > fileTotalLinesMargin || delta < -fileTotalLinesMargin {
+			stats.AddWarningCode(WarningFileTotalLinesMismatch, fmt.Sprintf(
+				"header declares file_total_lines=%d but %d lines were actually processed; using the real count",
+				cfg.Metadata.FileTotalLines, stats.LinesIn))
+		}
+	}
+
+	if cfg.Checksum {
+		if err := writeChecksumFooter(out, cfg, &renumberNext); err != nil {
+			return stats, err
+		}
+		stats.AddedLines++
+	}
+
+	// Flushed explicitly (rather than left to the deferred Flush above) so
+	// BytesOut reflects whether the final line's terminator was actually
+	// written, which out.BytesWritten() wouldn't yet know about otherwise.
+	if err := out.Flush(); err != nil {
+		return stats, err
+	}
+	stats.BytesOut = totalBytesOut + out.BytesWritten()
+
+	return stats, nil
+}
+
+// writeChecksumFooter appends a trailing comment reporting a CRC32 (IEEE)
+// checksum and line count over every line out has written so far
+// (EnableChecksum must already have been called), for -checksum. renumberNext
+// is threaded through (and advanced) so the footer gets spliced into the
+// same N sequence as every other line when -renumber is also set.
+func writeChecksumFooter(out *writer.Writer, cfg Config, renumberNext *int) error {
+	line := applyRenumber(gcode.Line{
+		Comment:     formatComment(cfg.CommentPrefix, fmt.Sprintf("checksum: crc32=%08x lines=%d", out.Checksum(), out.LineCount())),
+		Synthesized: true,
+	}, cfg, renumberNext)
+	return out.WriteLine(line)
+}
+
+// writeSplitFooter closes out a split part with a generated retract above
+// the depth reference and a spindle-off, so the part is independently
+// runnable even though the source program didn't actually end there.
+// renumberNext is threaded through (and advanced) so both generated lines
+// get spliced into the same N sequence as everything else when -renumber is
+// also set.
+func writeSplitFooter(out *writer.Writer, cfg Config, renumberNext *int) error {
+	retractZ := cfg.ReferenceZ + cfg.Allowance + splitSafeRetractClearance
+	retract := gcode.Line{
+		Code:        "G0",
+		Synthesized: true,
+		Params:      []gcode.Param{{Letter: 'Z', Value: retractZ, Raw: formatCoord(retractZ, cfg.precision())}},
+		Comment:     formatComment(cfg.CommentPrefix, "generated safety retract at split part boundary"),
+	}
+	retract = applyRenumber(retract, cfg, renumberNext)
+	if err := out.WriteLine(retract); err != nil {
+		return err
+	}
+	m5 := applyRenumber(gcode.Line{Code: "M5", Synthesized: true}, cfg, renumberNext)
+	return out.WriteLine(m5)
+}
+
+// fileTotalLinesMargin is how far stats.LinesIn may drift from the header's
+// declared file_total_lines (e.g. the header line itself isn't always
+// included in the count) before it's treated as a real mismatch worth
+// warning about.
+const fileTotalLinesMargin = 1
+
+// Analyze reports the Statistics that Optimize would produce for r without
+// writing any output. It shares Optimize's classification code, so the two
+// can never diverge on what counts as removable.
+func Analyze(r io.Reader, cfg Config) (Statistics, error) {
+	return Optimize(r, io.Discard, cfg)
+}
+
+// readLine reads one line from br, including its terminator if present.
+// terminated reports whether a "\n" was found (the returned line has it
+// stripped); when terminated is false and line is "", the reader is
+// exhausted. A final line lacking a trailing newline is still returned,
+// with terminated=false.
+func readLine(br *bufio.Reader) (line string, terminated bool, err error) {
+	s, rerr := br.ReadString('\n')
+	if rerr != nil {
+		if rerr == io.EOF {
+			return s, false, nil
+		}
+		return "", false, rerr
+	}
+	return s[:len(s)-1], true, nil
+}
@@ -0,0 +1,2048 @@
+package optimizer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/chrisns/snapmaker-cnc-finisher/internal/gcode"
+)
+
+func TestOptimize_FiltersShallowMoves(t *testing.T) {
+	// G1 Z2 stays shallow throughout (removed); G1 Z-1 crosses into
+	// material (kept, the plunge); G1 Z0.5 crosses back out of it
+	// (CrossingLeave, kept entirely since this isn't a splitting
+	// strategy - removing it would discard the deep portion of the cut).
+	input := "G0 Z5\nG1 Z2 F300\nG1 Z-1 F300\nG1 Z0.5 F300\n"
+
+	out := &bytes.Buffer{}
+	stats, err := Optimize(strings.NewReader(input), out, Config{Allowance: 0, Reference: ReferenceSurface, ReferenceZ: 0})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+
+	if stats.LinesRemoved != 1 {
+		t.Fatalf("LinesRemoved = %d, want 1", stats.LinesRemoved)
+	}
+	if stats.LinesOut != 3 {
+		t.Fatalf("LinesOut = %d, want 3", stats.LinesOut)
+	}
+}
+
+func TestOptimize_KeepsPureZPlungeEvenThoughItStartsShallow(t *testing.T) {
+	// G1 Z0.3 is shallow; G0 never filters; the pure-Z plunge to Z-2 has no
+	// X/Y travel at all but still must be kept whole since it ends deep.
+	input := "G0 Z5\nG1 Z0.3 F300\nG1 Z-2 F300\n"
+
+	out := &bytes.Buffer{}
+	stats, err := Optimize(strings.NewReader(input), out, Config{Allowance: 0, Reference: ReferenceSurface, ReferenceZ: 0})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+
+	if stats.LinesRemoved != 1 {
+		t.Fatalf("LinesRemoved = %d, want 1 (only the shallow Z0.3 move)", stats.LinesRemoved)
+	}
+	if !strings.Contains(out.String(), "G1 Z-2 F300") {
+		t.Fatalf("expected the plunge to Z-2 kept intact, got %q", out.String())
+	}
+}
+
+func TestOptimize_ChecksumFooterMatchesIndependentComputation(t *testing.T) {
+	input := "G1 X1 Z-1 F300\nG1 X2 Z-1 F300\nG1 X3 Z-1 F300\n"
+
+	out := &bytes.Buffer{}
+	stats, err := Optimize(strings.NewReader(input), out, Config{
+		Allowance: 0, Reference: ReferenceSurface, ReferenceZ: 0, Checksum: true,
+	})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+
+	text := out.String()
+	lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+	footer := lines[len(lines)-1]
+	body := strings.Join(lines[:len(lines)-1], "\n") + "\n"
+
+	wantCRC := crc32.ChecksumIEEE([]byte(body))
+	wantComment := fmt.Sprintf("; checksum: crc32=%08x lines=%d", wantCRC, len(lines)-1)
+	if footer != wantComment {
+		t.Fatalf("footer = %q, want %q", footer, wantComment)
+	}
+	if stats.AddedLines != 1 {
+		t.Fatalf("AddedLines = %d, want 1", stats.AddedLines)
+	}
+}
+
+func TestOptimize_SplitStrategyCorrectsPositionAfterCrossingLeave(t *testing.T) {
+	// The first G1 starts deep (Z-1) and ends shallow (Z1): a CrossingLeave.
+	// With StrategySplit only its deep portion (up to Z0, X5) is kept; the
+	// second G1 then retraces from that corrected position - not the
+	// discarded move's original endpoint (X10) - back down to Z0 before
+	// leaving again. Its own split point lands exactly on the first split's
+	// X, so a wrong carried-over position would show up directly in X.
+	input := "G0 X0 Z-1\nG1 X10 Z1 F300\nG1 X-5 Z5 F300\n"
+
+	out := &bytes.Buffer{}
+	_, err := Optimize(strings.NewReader(input), out, Config{
+		Allowance: 0, Reference: ReferenceSurface, ReferenceZ: 0, Strategy: StrategySplit,
+	})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+
+	got := out.String()
+	if !strings.Contains(got, "X5.0000") {
+		t.Fatalf("expected both splits to land at X5.0000 (corrected position), got %q", got)
+	}
+	if strings.Contains(got, "X10.0000") {
+		t.Fatalf("output carried the discarded move's endpoint instead of the split position: %q", got)
+	}
+}
+
+func TestOptimize_SplitStrategyCreditsDiscardedCrossingLeaveTail(t *testing.T) {
+	// G1 X10 Z1 starts deep (Z-1) and ends shallow (Z1): a CrossingLeave.
+	// StrategySplit keeps only the deep portion (up to the threshold
+	// crossing at X5, Z0); the shallow tail from there to the original
+	// endpoint (X10, Z1) is discarded from the output entirely and must be
+	// credited to RemovedXYDistance/TimeSavedSeconds, not simply dropped.
+	input := "G0 X0 Z-1\nG1 X10 Z1 F300\n"
+
+	ix, _, iz := CalculateIntersection(0, 0, -1, 10, 0, 1, 0)
+	wantTailDist := Distance(ix, 0, iz, 10, 0, 1)
+	wantTailXYDist := DistanceXY(ix, 0, 10, 0)
+
+	out := &bytes.Buffer{}
+	stats, err := Optimize(strings.NewReader(input), out, Config{
+		Allowance: 0, Reference: ReferenceSurface, ReferenceZ: 0, Strategy: StrategySplit,
+	})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+
+	wantSaved := wantTailDist / 300 * 60
+	if math.Abs(stats.TimeSavedSeconds-wantSaved) > 1e-6 {
+		t.Fatalf("TimeSavedSeconds = %v, want %v (the discarded shallow tail)", stats.TimeSavedSeconds, wantSaved)
+	}
+	if math.Abs(stats.RemovedXYDistance-wantTailXYDist) > 1e-6 {
+		t.Fatalf("RemovedXYDistance = %v, want %v", stats.RemovedXYDistance, wantTailXYDist)
+	}
+}
+
+func TestOptimize_SplitStrategyNeverDiscountsCrossingEnterLeadIn(t *testing.T) {
+	// ReferenceZ=-5 makes the initial Z=0 state shallow, so this single
+	// move (X0,Z0 -> X10,Z-10) starts shallow and ends deep: a
+	// CrossingEnter. The line still has to be emitted whole - there's no
+	// way to start a G-code move partway along its own path - so its
+	// shallow lead-in is genuinely uncut travel the kept line performs,
+	// not saved time, even under StrategySplit.
+	input := "G1 X10 Y0 Z-10 F300\n"
+
+	out := &bytes.Buffer{}
+	stats, err := Optimize(strings.NewReader(input), out, Config{
+		Allowance: 0, Reference: ReferenceSurface, ReferenceZ: -5, Strategy: StrategySplit,
+	})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+
+	if got := out.String(); got != input {
+		t.Fatalf("output = %q, want the line emitted byte-identical to the input (the line can't be split in place)", got)
+	}
+
+	if stats.TimeSavedSeconds != 0 {
+		t.Fatalf("TimeSavedSeconds = %v, want 0 (the whole line is still emitted, nothing was actually removed)", stats.TimeSavedSeconds)
+	}
+	if stats.RemovedXYDistance != 0 {
+		t.Fatalf("RemovedXYDistance = %v, want 0", stats.RemovedXYDistance)
+	}
+	if stats.ModifiedLines != 0 {
+		t.Fatalf("ModifiedLines = %d, want 0 (the line was never trimmed)", stats.ModifiedLines)
+	}
+}
+
+func TestOptimize_SplitStrategyKeepsCrossingEnterArcWhole(t *testing.T) {
+	// A quarter-circle G3 centered on the origin, helically descending
+	// from Z0 to Z-10; with ReferenceZ=-5 the start (Z0) is shallow and
+	// the end (Z-10) is deep, a CrossingEnter. Trimming the lead-in would
+	// recompute I/J relative to the threshold-crossing point, but nothing
+	// ever moves the tool there for real - the whole arc still has to be
+	// emitted, since a single G-code move can't start partway along its
+	// own path - so the center the controller would actually cut from
+	// would be wrong. The arc must therefore be emitted byte-identical to
+	// the input, the same as safe mode keeps every CrossingEnter move.
+	input := "G3 X0 Y10 Z-10 I-10 J0 F300\n"
+
+	out := &bytes.Buffer{}
+	stats, err := Optimize(strings.NewReader(input), out, Config{
+		Allowance: 0, Reference: ReferenceSurface, ReferenceZ: -5, Strategy: StrategySplit,
+	})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+
+	if got := out.String(); !strings.Contains(got, input) {
+		t.Fatalf("output = %q, want the arc emitted byte-identical to the input", got)
+	}
+	if stats.ModifiedLines != 0 {
+		t.Fatalf("ModifiedLines = %d, want 0 (the arc was never trimmed)", stats.ModifiedLines)
+	}
+}
+
+func TestOptimize_KeepAboveSurfaceProtectsPositiveZMovesOnlyWhenEnabled(t *testing.T) {
+	// With zero allowance and the default reference plane, G1 Z1 is
+	// shallow and would ordinarily be removed.
+	input := "G0 Z5\nG1 Z1 F300\nG1 Z-1 F300\n"
+
+	out := &bytes.Buffer{}
+	stats, err := Optimize(strings.NewReader(input), out, Config{
+		Allowance: 0, Reference: ReferenceSurface, ReferenceZ: 0,
+	})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+	if strings.Contains(out.String(), "Z1.0000") {
+		t.Fatalf("Z1 move should be removed with KeepAboveSurface off, got %q", out.String())
+	}
+	if stats.LinesRemoved != 1 {
+		t.Fatalf("RemovedLines = %d, want 1", stats.LinesRemoved)
+	}
+
+	out.Reset()
+	stats, err = Optimize(strings.NewReader(input), out, Config{
+		Allowance: 0, Reference: ReferenceSurface, ReferenceZ: 0, KeepAboveSurface: true,
+	})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+	if !strings.Contains(out.String(), "Z1 F300") {
+		t.Fatalf("Z1 move should be kept with KeepAboveSurface on, got %q", out.String())
+	}
+	if stats.LinesRemoved != 0 {
+		t.Fatalf("RemovedLines = %d, want 0", stats.LinesRemoved)
+	}
+}
+
+func TestOptimize_DumpRemovedReceivesFilteredMoves(t *testing.T) {
+	input := "G0 Z5\nG1 Z2 F300\nG1 Z-1 F300\nG1 Z0.5 F300\n"
+
+	out := &bytes.Buffer{}
+	dump := &bytes.Buffer{}
+	stats, err := Optimize(strings.NewReader(input), out, Config{
+		Allowance: 0, Reference: ReferenceSurface, ReferenceZ: 0, DumpRemoved: dump,
+	})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+
+	dumpLines := strings.Count(dump.String(), "\n")
+	if dumpLines != stats.LinesRemoved {
+		t.Fatalf("dump has %d lines, want %d (LinesRemoved)", dumpLines, stats.LinesRemoved)
+	}
+	if !strings.Contains(dump.String(), "G1 Z2 F300") {
+		t.Fatalf("dump missing removed move, got %q", dump.String())
+	}
+
+	outLines := strings.Count(out.String(), "\n")
+	if outLines != stats.LinesOut {
+		t.Fatalf("output has %d lines, want %d (LinesOut)", outLines, stats.LinesOut)
+	}
+}
+
+func TestOptimize_DropsZeroLengthMovesByDefault(t *testing.T) {
+	input := "G1 Z-1 F300\nG1 Z-1 F300\nG1 X0 Y0 Z-1 F300\nG1 Z-2 F300\n"
+
+	out := &bytes.Buffer{}
+	stats, err := Optimize(strings.NewReader(input), out, Config{Allowance: 0, Reference: ReferenceSurface, ReferenceZ: 0})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+
+	if stats.ZeroLengthRemoved != 2 {
+		t.Fatalf("ZeroLengthRemoved = %d, want 2", stats.ZeroLengthRemoved)
+	}
+	if stats.LinesOut != 2 {
+		t.Fatalf("LinesOut = %d, want 2", stats.LinesOut)
+	}
+}
+
+func TestOptimize_KeepZeroLengthRetainsRedundantMoves(t *testing.T) {
+	input := "G1 Z-1 F300\nG1 Z-1 F300\n"
+
+	out := &bytes.Buffer{}
+	stats, err := Optimize(strings.NewReader(input), out, Config{
+		Allowance: 0, Reference: ReferenceSurface, ReferenceZ: 0, KeepZeroLength: true,
+	})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+
+	if stats.ZeroLengthRemoved != 0 {
+		t.Fatalf("ZeroLengthRemoved = %d, want 0", stats.ZeroLengthRemoved)
+	}
+	if stats.LinesOut != 2 {
+		t.Fatalf("LinesOut = %d, want 2", stats.LinesOut)
+	}
+}
+
+func TestOptimize_RefusesAggressiveStrategyOnRotaryJobWithoutForce(t *testing.T) {
+	data, err := os.ReadFile("testdata/rotary_job.gcode")
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	md, err := gcode.ExtractMetadata(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("ExtractMetadata: %v", err)
+	}
+	if !md.Is4Axis {
+		t.Fatal("fixture's is_rotate header wasn't picked up")
+	}
+
+	out := &bytes.Buffer{}
+	stats, err := Optimize(bytes.NewReader(data), out, Config{
+		Allowance: 0, Reference: ReferenceSurface, ReferenceZ: 0,
+		Strategy: StrategyAggressive, Metadata: md,
+	})
+	if !errors.Is(err, ErrRotaryStrategyUnsafe) {
+		t.Fatalf("err = %v, want ErrRotaryStrategyUnsafe", err)
+	}
+	if len(stats.Warnings) == 0 {
+		t.Fatal("expected a warning about the rotary job even though the run was refused")
+	}
+}
+
+func TestOptimize_RefusesLaserHeaderWithoutForce(t *testing.T) {
+	data, err := os.ReadFile("testdata/laser_job.gcode")
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	md, err := gcode.ExtractMetadata(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("ExtractMetadata: %v", err)
+	}
+	if md.HeaderType != "laser" {
+		t.Fatalf("HeaderType = %q, want laser", md.HeaderType)
+	}
+
+	out := &bytes.Buffer{}
+	stats, err := Optimize(bytes.NewReader(data), out, Config{
+		Allowance: 0, Reference: ReferenceSurface, ReferenceZ: 0, Metadata: md,
+	})
+	if !errors.Is(err, ErrNonCNCToolHead) {
+		t.Fatalf("err = %v, want ErrNonCNCToolHead", err)
+	}
+	if !hasCodedWarning(stats.CodedWarnings, WarningNonCNCToolHead) {
+		t.Fatal("expected a WarningNonCNCToolHead warning even though the run was refused")
+	}
+}
+
+func TestOptimize_Refuses3DPHeaderWithoutForce(t *testing.T) {
+	data, err := os.ReadFile("testdata/3dp_job.gcode")
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	md, err := gcode.ExtractMetadata(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("ExtractMetadata: %v", err)
+	}
+
+	out := &bytes.Buffer{}
+	_, err = Optimize(bytes.NewReader(data), out, Config{
+		Allowance: 0, Reference: ReferenceSurface, ReferenceZ: 0, Metadata: md,
+	})
+	if !errors.Is(err, ErrNonCNCToolHead) {
+		t.Fatalf("err = %v, want ErrNonCNCToolHead", err)
+	}
+}
+
+func TestOptimize_CNCHeaderProceedsNormally(t *testing.T) {
+	data, err := os.ReadFile("testdata/header_block.gcode")
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	md, err := gcode.ExtractMetadata(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("ExtractMetadata: %v", err)
+	}
+
+	out := &bytes.Buffer{}
+	if _, err := Optimize(bytes.NewReader(data), out, Config{
+		Allowance: 0, Reference: ReferenceSurface, ReferenceZ: 0, Metadata: md,
+	}); err != nil {
+		t.Fatalf("Optimize with cnc header: %v", err)
+	}
+}
+
+func TestExtractMetadata_IsRotateHeaderDetectedWithoutAnyBWord(t *testing.T) {
+	// This fixture declares ";is_rotate: true" in the header but never
+	// actually uses a B word in the body, so Is4Axis must come from the
+	// header field itself rather than from noticing B motion.
+	data, err := os.ReadFile("testdata/rotary_job_header_only.gcode")
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	md, err := gcode.ExtractMetadata(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("ExtractMetadata: %v", err)
+	}
+	if !md.Is4Axis {
+		t.Fatal("Is4Axis should be set from the is_rotate header even with no B word in the body")
+	}
+
+	// With Strategy left unset (the CLI's own -strategy default is also
+	// "safe"), a rotary job optimizes without tripping the
+	// ErrRotaryStrategyUnsafe refusal.
+	out := &bytes.Buffer{}
+	if _, err := Optimize(bytes.NewReader(data), out, Config{
+		Allowance: 0, Reference: ReferenceSurface, ReferenceZ: 0, Metadata: md,
+	}); err != nil {
+		t.Fatalf("Optimize with default (safe) strategy on rotary job: %v", err)
+	}
+}
+
+func TestOptimize_AggressiveStrategyOnRotaryJobProceedsWithForce(t *testing.T) {
+	input := "G1 X10 B90 Z0.5 F300\n"
+
+	out := &bytes.Buffer{}
+	_, err := Optimize(strings.NewReader(input), out, Config{
+		Allowance: 0, Reference: ReferenceSurface, ReferenceZ: 0,
+		Strategy: StrategyAggressive, Metadata: gcode.Metadata{Is4Axis: true}, Force: true,
+	})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+}
+
+func TestOptimize_RotaryKeepsShallowContourMoveByBRotation(t *testing.T) {
+	// The first move starts deep (Z=0, the program's initial position)
+	// and crosses out to shallow Z=0.5 - a CrossingLeave, kept entirely
+	// regardless of rotary mode. The second move stays shallow throughout
+	// (NoCrossing); without rotary mode it's filtered like any other
+	// shallow move, but with rotary mode its large B rotation keeps it.
+	input := "G1 X10 Z0.5 F300\nG1 X20 B90 Z0.5 F300\n"
+
+	plain := &bytes.Buffer{}
+	stats, err := Optimize(strings.NewReader(input), plain, Config{
+		Allowance: 0, Reference: ReferenceSurface, ReferenceZ: 0,
+		Metadata: gcode.Metadata{Is4Axis: true},
+	})
+	if err != nil {
+		t.Fatalf("Optimize (non-rotary): %v", err)
+	}
+	if stats.LinesRemoved != 1 {
+		t.Fatalf("non-rotary LinesRemoved = %d, want 1", stats.LinesRemoved)
+	}
+
+	rotary := &bytes.Buffer{}
+	stats, err = Optimize(strings.NewReader(input), rotary, Config{
+		Allowance: 0, Reference: ReferenceSurface, ReferenceZ: 0,
+		Metadata: gcode.Metadata{Is4Axis: true}, Rotary: true,
+	})
+	if err != nil {
+		t.Fatalf("Optimize (rotary): %v", err)
+	}
+	if stats.LinesRemoved != 0 {
+		t.Fatalf("rotary LinesRemoved = %d, want 0", stats.LinesRemoved)
+	}
+	if !strings.Contains(rotary.String(), "B90") {
+		t.Fatalf("rotary output missing the kept B90 move, got %q", rotary.String())
+	}
+}
+
+func TestOptimize_AllAxesStrategyRemovesRotaryMoveSafeKeepsAndCountsIt(t *testing.T) {
+	// The leading G0 puts the tool at a shallow Z before the G1 runs, so
+	// the G1 (also shallow, Z=0.5 > referenceZ=0) stays shallow throughout
+	// rather than crossing the threshold - otherwise crossingPredicate
+	// would keep it outright regardless of strategy, and this test
+	// wouldn't be exercising rotary protection at all. Rotary protection
+	// spares the shallow, B-rotating G1 under the default (safe)
+	// strategy. Under StrategyAllAxes that protection is disabled, so the
+	// move is removed on depth alone, and the safe run's KeptByStrategy
+	// counts it.
+	input := "G0 Z0.5\nG1 X10 B90 Z0.5 F300\n"
+
+	safe := &bytes.Buffer{}
+	stats, err := Optimize(strings.NewReader(input), safe, Config{
+		Allowance: 0, Reference: ReferenceSurface, ReferenceZ: 0,
+		Metadata: gcode.Metadata{Is4Axis: true}, Rotary: true,
+	})
+	if err != nil {
+		t.Fatalf("Optimize (safe): %v", err)
+	}
+	if stats.LinesRemoved != 0 {
+		t.Fatalf("safe LinesRemoved = %d, want 0", stats.LinesRemoved)
+	}
+	if stats.KeptByStrategy != 1 {
+		t.Fatalf("safe KeptByStrategy = %d, want 1", stats.KeptByStrategy)
+	}
+
+	allAxes := &bytes.Buffer{}
+	stats, err = Optimize(strings.NewReader(input), allAxes, Config{
+		Allowance: 0, Reference: ReferenceSurface, ReferenceZ: 0,
+		Metadata: gcode.Metadata{Is4Axis: true}, Rotary: true,
+		Strategy: StrategyAllAxes, Force: true,
+	})
+	if err != nil {
+		t.Fatalf("Optimize (all-axes): %v", err)
+	}
+	if stats.LinesRemoved != 1 {
+		t.Fatalf("all-axes LinesRemoved = %d, want 1", stats.LinesRemoved)
+	}
+	if stats.KeptByStrategy != 0 {
+		t.Fatalf("all-axes KeptByStrategy = %d, want 0 (nothing to compare against under all-axes itself)", stats.KeptByStrategy)
+	}
+}
+
+func TestOptimize_HelicalArcUnderAllAxesStrategyWarnsAboutEndpointOnlyDepth(t *testing.T) {
+	// A helical arc (G2 with a Z change, so its depth isn't constant along
+	// the cut) filtered under StrategyAllAxes should warn that depth
+	// filtering only looks at its endpoint, since that's imprecise for a
+	// helix. The same arc under the default (safe) strategy gets no such
+	// warning.
+	input := "G1 X0 Y0 Z-5 F300\nG2 X10 Y0 Z-2 I5 J0 F300\n"
+
+	allAxes := &bytes.Buffer{}
+	stats, err := Optimize(strings.NewReader(input), allAxes, Config{
+		Allowance: 0, Reference: ReferenceSurface, ReferenceZ: 0,
+		Strategy: StrategyAllAxes,
+	})
+	if err != nil {
+		t.Fatalf("Optimize (all-axes): %v", err)
+	}
+	found := false
+	for _, w := range stats.CodedWarnings {
+		if w.Code == WarningArcDepthImprecise {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("Optimize (all-axes) warnings = %v, want a WarningArcDepthImprecise", stats.CodedWarnings)
+	}
+
+	safe := &bytes.Buffer{}
+	stats, err = Optimize(strings.NewReader(input), safe, Config{
+		Allowance: 0, Reference: ReferenceSurface, ReferenceZ: 0,
+	})
+	if err != nil {
+		t.Fatalf("Optimize (safe): %v", err)
+	}
+	for _, w := range stats.CodedWarnings {
+		if w.Code == WarningArcDepthImprecise {
+			t.Fatalf("Optimize (safe) unexpectedly warned about arc depth: %v", stats.CodedWarnings)
+		}
+	}
+}
+
+func TestOptimize_CalibratedFeedRateMatchesHandComputedRatio(t *testing.T) {
+	// Total travel is 30 units (0->10, then 10->-10); a stated
+	// estimated_time of 60s gives an effective feed of 30/(60/60) = 30
+	// units/min.
+	input := "G1 Z10 F9999\nG1 Z-10 F300\n"
+
+	totalDistance, err := TotalDistance(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("TotalDistance: %v", err)
+	}
+	if totalDistance != 30 {
+		t.Fatalf("TotalDistance = %v, want 30", totalDistance)
+	}
+
+	feedRate, ok := CalibratedFeedRate(totalDistance, 60)
+	if !ok || feedRate != 30 {
+		t.Fatalf("CalibratedFeedRate = (%v, %v), want (30, true)", feedRate, ok)
+	}
+
+	out := &bytes.Buffer{}
+	stats, err := Optimize(strings.NewReader(input), out, Config{
+		// ReferenceZ=-1 (rather than 0) keeps the program's initial Z=0
+		// classified shallow like the first move's own Z10 endpoint, so
+		// that move is a plain shallow NoCrossing removal rather than a
+		// CrossingLeave starting exactly on the threshold.
+		Allowance: 0, Reference: ReferenceSurface, ReferenceZ: -1, CalibratedFeedRate: feedRate,
+	})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+
+	// The only removed move (the shallow first G1, Z0->Z10) travels 10
+	// units at the calibrated 30 units/min, i.e. 20 seconds - regardless
+	// of its literal F9999.
+	if stats.TimeSavedSeconds != 20 {
+		t.Fatalf("TimeSavedSeconds = %v, want 20", stats.TimeSavedSeconds)
+	}
+}
+
+func TestOptimize_OriginalEstimateEqualsOptimizedPlusSaved(t *testing.T) {
+	// G1 Z0->Z10 (shallow, removed) then Z10->Z-10 (deep, kept), both at
+	// F300: 10 units removed (2s) and 20 units kept (4s). ReferenceZ=-1
+	// (rather than 0) keeps the program's initial Z=0 shallow like Z10,
+	// so the first move is a plain shallow removal rather than a
+	// CrossingLeave starting exactly on the threshold.
+	input := "G1 Z10 F300\nG1 Z-10 F300\n"
+
+	out := &bytes.Buffer{}
+	stats, err := Optimize(strings.NewReader(input), out, Config{
+		Allowance: 0, Reference: ReferenceSurface, ReferenceZ: -1,
+	})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+
+	const tolerance = 1e-9
+	if math.Abs(stats.OptimizedEstimatedSeconds-(stats.OriginalEstimatedSeconds-stats.TimeSavedSeconds)) > tolerance {
+		t.Fatalf("OptimizedEstimatedSeconds = %v, want original(%v)-saved(%v) = %v",
+			stats.OptimizedEstimatedSeconds, stats.OriginalEstimatedSeconds, stats.TimeSavedSeconds,
+			stats.OriginalEstimatedSeconds-stats.TimeSavedSeconds)
+	}
+	if stats.OriginalEstimatedSeconds != 6 {
+		t.Fatalf("OriginalEstimatedSeconds = %v, want 6", stats.OriginalEstimatedSeconds)
+	}
+	if stats.OptimizedEstimatedSeconds != 4 {
+		t.Fatalf("OptimizedEstimatedSeconds = %v, want 4", stats.OptimizedEstimatedSeconds)
+	}
+}
+
+func TestOptimize_WarnsOnFileTotalLinesMismatch(t *testing.T) {
+	input := "G1 Z0.5 F300\nG1 Z-1 F300\nG1 Z0.5 F300\n"
+
+	out := &bytes.Buffer{}
+	stats, err := Optimize(strings.NewReader(input), out, Config{
+		Allowance: 0, Reference: ReferenceSurface, ReferenceZ: 0,
+		Metadata: gcode.Metadata{FileTotalLines: 50},
+	})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+
+	found := false
+	for _, w := range stats.Warnings {
+		if strings.Contains(w, "file_total_lines=50") && strings.Contains(w, "3 lines were actually processed") {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("expected a file_total_lines mismatch warning, got %v", stats.Warnings)
+	}
+}
+
+func TestOptimize_PreservesIndentationOfKeptLines(t *testing.T) {
+	// Kept lines are never re-serialized, so any operator indentation or
+	// alignment survives untouched; only genuinely Synthesized lines (not
+	// exercised here) are reformatted.
+	input := "  G1 Z-1 F300\n    G1 X5 Z-2 F300\n"
+
+	out := &bytes.Buffer{}
+	if _, err := Optimize(strings.NewReader(input), out, Config{Allowance: 0, Reference: ReferenceSurface, ReferenceZ: 0}); err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+
+	if out.String() != input {
+		t.Fatalf("output = %q, want indentation preserved verbatim: %q", out.String(), input)
+	}
+}
+
+func TestOptimize_MaxFeedClampsOverMaxFWord(t *testing.T) {
+	input := "G1 Z-1 F5000\nG1 X5 Z-2 F300\n"
+
+	out := &bytes.Buffer{}
+	stats, err := Optimize(strings.NewReader(input), out, Config{
+		Allowance: 0, Reference: ReferenceSurface, ReferenceZ: 0, MaxFeed: 1000,
+	})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+
+	if stats.FeedRateClamped != 1 {
+		t.Fatalf("FeedRateClamped = %d, want 1", stats.FeedRateClamped)
+	}
+	if !strings.Contains(out.String(), "F1000.0000") {
+		t.Fatalf("expected clamped F1000.0000, got %q", out.String())
+	}
+	if strings.Contains(out.String(), "F5000") {
+		t.Fatalf("over-max F5000 leaked into output: %q", out.String())
+	}
+	if !strings.Contains(out.String(), "F300") {
+		t.Fatalf("under-max F300 should pass through unchanged: %q", out.String())
+	}
+	if stats.ModifiedLines != 1 {
+		t.Fatalf("ModifiedLines = %d, want 1", stats.ModifiedLines)
+	}
+}
+
+func TestOptimize_StripRedundantFeedDropsRepeatedFButKeepsFirstAndAfterG0(t *testing.T) {
+	input := "G1 X1 Z-1 F300\nG1 X2 Z-1 F300\nG0 X3 Z5\nG1 X4 Z-1 F300\nG1 X5 Z-1 F600\n"
+
+	out := &bytes.Buffer{}
+	stats, err := Optimize(strings.NewReader(input), out, Config{
+		Allowance: 0, Reference: ReferenceSurface, ReferenceZ: 0, StripRedundantFeed: true,
+	})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+
+	if stats.RedundantFeedStripped != 1 {
+		t.Fatalf("RedundantFeedStripped = %d, want 1", stats.RedundantFeedStripped)
+	}
+	if stats.ModifiedLines != 1 {
+		t.Fatalf("ModifiedLines = %d, want 1", stats.ModifiedLines)
+	}
+	if strings.Count(out.String(), "F300") != 2 {
+		t.Fatalf("expected F300 to survive on the first line and again after the G0, got %q", out.String())
+	}
+	if !strings.Contains(out.String(), "F600") {
+		t.Fatalf("a genuinely new feed rate should never be stripped: %q", out.String())
+	}
+}
+
+func TestOptimize_RenumberProducesGaplessSequenceAfterRemoval(t *testing.T) {
+	// N10/N20 stay deep throughout (kept); N30 crosses out to shallow
+	// (CrossingLeave, kept entirely); N40 stays shallow throughout
+	// (NoCrossing, the only move actually removed).
+	input := "N10 G1 X1 Z-1 F300\nN20 G1 X2 Z-1 F300\nN30 G1 X3 Z1 F300\nN40 G1 X4 Z1 F300\n"
+
+	out := &bytes.Buffer{}
+	stats, err := Optimize(strings.NewReader(input), out, Config{
+		Allowance: 0, Reference: ReferenceSurface, ReferenceZ: 0, Renumber: true,
+	})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+
+	if stats.LinesOut != 3 {
+		t.Fatalf("LinesOut = %d, want 3", stats.LinesOut)
+	}
+	want := "N0 G1 X1 Z-1 F300\nN10 G1 X2 Z-1 F300\nN20 G1 X3 Z1 F300\n"
+	if out.String() != want {
+		t.Fatalf("output = %q, want %q", out.String(), want)
+	}
+}
+
+// renumberedNs extracts every line's leading "N<digits>" word, in output
+// order, for asserting a gapless sequence. RenumberLine's N-word is a
+// positional text prefix, not a gcode.Param, so it's parsed the same way
+// RenumberLine itself writes it rather than through gcode.Parse.
+func renumberedNs(t *testing.T, text string) []int {
+	t.Helper()
+	var ns []int
+	for _, raw := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+		if raw == "" {
+			continue
+		}
+		if !strings.HasPrefix(raw, "N") {
+			t.Fatalf("line %q has no leading N-word", raw)
+		}
+		var n int
+		if _, err := fmt.Sscanf(raw, "N%d", &n); err != nil {
+			t.Fatalf("line %q: parsing N-word: %v", raw, err)
+		}
+		ns = append(ns, n)
+	}
+	return ns
+}
+
+// assertGaplessSequence fails unless ns is strictly increasing by step,
+// starting at start - the sequence Config.Renumber promises every emitted
+// line a place in.
+func assertGaplessSequence(t *testing.T, ns []int, start, step int) {
+	t.Helper()
+	for i, n := range ns {
+		want := start + i*step
+		if n != want {
+			t.Fatalf("N sequence = %v, want N%d at position %d (strictly increasing by %d from %d)", ns, want, i, step, start)
+		}
+	}
+}
+
+func TestOptimize_RenumberIncludesStampLine(t *testing.T) {
+	out := &bytes.Buffer{}
+	_, err := Optimize(strings.NewReader("G1 X1 Z-1 F300\n"), out, Config{
+		Allowance: 0, Reference: ReferenceSurface, ReferenceZ: 0,
+		Stamp: true, Renumber: true,
+	})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+	assertGaplessSequence(t, renumberedNs(t, out.String()), 0, 10)
+}
+
+func TestOptimize_RenumberIncludesSmoothRampInsert(t *testing.T) {
+	// Same shape as TestOptimize_SmoothInsertsARampAcrossAGapLeftByRemoval:
+	// the no-op middle move is removed, leaving a gap Smooth bridges with a
+	// generated ramp. The ramp is written before the move that follows it,
+	// so it must consume the earlier N in the sequence, not a later one.
+	input := "G1 X0 Y0 Z-5 F300\n" +
+		"G1 X0 Y0 Z1 F300\n" +
+		"G1 X40 Y0 Z1 F300\n" +
+		"G1 X40 Y0 Z-15 F300\n"
+
+	out := &bytes.Buffer{}
+	_, err := Optimize(strings.NewReader(input), out, Config{
+		Allowance: 0, Reference: ReferenceSurface, ReferenceZ: 0,
+		Smooth: true, Renumber: true,
+	})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+	assertGaplessSequence(t, renumberedNs(t, out.String()), 0, 10)
+}
+
+func TestOptimize_RenumberIncludesSplitAndChecksumFooters(t *testing.T) {
+	var b strings.Builder
+	for i := 1; i <= 50; i++ {
+		fmt.Fprintf(&b, "G1 X%d Z-2 F300\n", i)
+	}
+
+	first := &bytes.Buffer{}
+	var laterParts []*bytes.Buffer
+	_, err := Optimize(strings.NewReader(b.String()), first, Config{
+		Allowance: 0, Reference: ReferenceSurface, ReferenceZ: 0,
+		SplitSize: 200, Checksum: true, Renumber: true,
+		NewPart: func(part int) (io.Writer, error) {
+			buf := &bytes.Buffer{}
+			laterParts = append(laterParts, buf)
+			return buf, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+	if len(laterParts) == 0 {
+		t.Fatal("expected SplitSize to trigger at least one additional part")
+	}
+	// Renumber runs continuously across the whole logical program, the same
+	// as it already did for ordinary lines before this fix - only the
+	// split/checksum footers are new to the sequence, not a per-part reset.
+	var ns []int
+	for _, p := range append([]*bytes.Buffer{first}, laterParts...) {
+		ns = append(ns, renumberedNs(t, p.String())...)
+	}
+	assertGaplessSequence(t, ns, 0, 10)
+}
+
+func TestOptimize_RenumberIncludesSpringPassRepeat(t *testing.T) {
+	input := "G1 X1 Z-2 F300\nG1 X2 Z-2 F300\nG1 X3 Z-2 F300\n"
+
+	out := &bytes.Buffer{}
+	_, err := Optimize(strings.NewReader(input), out, Config{
+		Allowance: 0, Reference: ReferenceSurface, ReferenceZ: 0,
+		SpringPass: true, Renumber: true,
+	})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+	assertGaplessSequence(t, renumberedNs(t, out.String()), 0, 10)
+}
+
+func TestOptimize_RangeLimitsFilteringToGivenLines(t *testing.T) {
+	// Line 1 crosses out of material (kept regardless) and line 2 is shallow
+	// and would normally be removed, but only lines 3-4 fall inside -range,
+	// so 1-2 must survive untouched.
+	input := "G1 X1 Z1 F300\nG1 X2 Z1 F300\nG1 X3 Z1 F300\nG1 X4 Z-1 F300\n"
+
+	out := &bytes.Buffer{}
+	stats, err := Optimize(strings.NewReader(input), out, Config{
+		Allowance: 0, Reference: ReferenceSurface, ReferenceZ: 0,
+		HasRange: true, RangeStart: 3, RangeEnd: 4,
+	})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+
+	if stats.LinesRemoved != 1 {
+		t.Fatalf("LinesRemoved = %d, want 1 (only line 3, inside the range)", stats.LinesRemoved)
+	}
+	if !strings.Contains(out.String(), "X1 Z1") || !strings.Contains(out.String(), "X2 Z1") {
+		t.Fatalf("lines outside -range must survive even though they're shallow: %q", out.String())
+	}
+	if strings.Contains(out.String(), "X3 Z1") {
+		t.Fatalf("line 3 is inside -range and shallow, it should have been removed: %q", out.String())
+	}
+}
+
+func TestOptimize_FeedOnlyLineTimesABareCoordinateRemovedMoveCorrectly(t *testing.T) {
+	// G1 Z1 crosses out to shallow (kept, a CrossingLeave); "G1 F2000" at
+	// that same shallow Z sets a new modal feed rate without moving,
+	// itself dropped as a zero-length no-op; the final bare "X10" line
+	// has no command word of its own, inheriting G1 from modal state and
+	// staying shallow throughout (NoCrossing). Its travel at the
+	// F2000 set by the feed-only line should count towards
+	// TimeSavedSeconds, not 0s from a feed rate that was never picked up.
+	input := "G1 Z1 F1000\nG1 F2000\nX10\n"
+
+	out := &bytes.Buffer{}
+	stats, err := Optimize(strings.NewReader(input), out, Config{
+		Allowance: 0, Reference: ReferenceSurface, ReferenceZ: 0,
+	})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+
+	if stats.LinesRemoved != 2 {
+		t.Fatalf("LinesRemoved = %d, want 2 (the feed-only line as a no-op, the bare coordinate line as shallow)", stats.LinesRemoved)
+	}
+	want := 10.0 / 2000 * 60
+	if stats.TimeSavedSeconds != want {
+		t.Fatalf("TimeSavedSeconds = %v, want %v (10 units at the declared F2000)", stats.TimeSavedSeconds, want)
+	}
+}
+
+func TestOptimize_RegionLimitsFilteringToMovesEndingInsideTheRectangle(t *testing.T) {
+	// All three moves end shallow (Z1 > referenceZ=0), but only the second
+	// ends inside the 5,5-15,15 region; the first and third end outside it
+	// (X0 and X20) and must survive untouched despite being shallow (the
+	// first also crosses out of material from the initial Z0).
+	input := "G1 X0 Y0 Z1 F300\nG1 X10 Y10 Z1 F300\nG1 X20 Y20 Z1 F300\n"
+
+	out := &bytes.Buffer{}
+	stats, err := Optimize(strings.NewReader(input), out, Config{
+		Allowance: 0, Reference: ReferenceSurface, ReferenceZ: 0,
+		HasRegion: true, RegionX1: 5, RegionY1: 5, RegionX2: 15, RegionY2: 15,
+	})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+
+	if stats.LinesRemoved != 1 {
+		t.Fatalf("LinesRemoved = %d, want 1 (only the in-region move)", stats.LinesRemoved)
+	}
+	if !strings.Contains(out.String(), "X0 Y0") || !strings.Contains(out.String(), "X20 Y20") {
+		t.Fatalf("moves outside -region must survive even though they're shallow: %q", out.String())
+	}
+	if strings.Contains(out.String(), "X10 Y10") {
+		t.Fatalf("the in-region move is shallow, it should have been removed: %q", out.String())
+	}
+}
+
+func TestOptimize_ToleranceKeepsMovesWithinMarginOfThreshold(t *testing.T) {
+	// With a 0.5 tolerance, the effective threshold is Z=0.5. X1 and X2
+	// stay within the tolerance margin and are kept as an ordinary deep
+	// cut; X3 crosses out past the margin (a CrossingLeave, kept entirely
+	// regardless of tolerance); X4 stays at that same shallow Z
+	// (NoCrossing) and is the one move tolerance doesn't save.
+	input := "G1 X1 Z0 F300\nG1 X2 Z0.3 F300\nG1 X3 Z0.6 F300\nG1 X4 Z0.6 F300\n"
+
+	out := &bytes.Buffer{}
+	stats, err := Optimize(strings.NewReader(input), out, Config{
+		Reference: ReferenceSurface, ReferenceZ: 0, Tolerance: 0.5,
+	})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+
+	if stats.LinesRemoved != 1 {
+		t.Fatalf("LinesRemoved = %d, want 1 (only X4, outside the tolerance margin)", stats.LinesRemoved)
+	}
+	if !strings.Contains(out.String(), "X2") || !strings.Contains(out.String(), "X3") {
+		t.Fatalf("X2 is within tolerance and X3 is a crossing move, both should have been kept: %q", out.String())
+	}
+	if strings.Contains(out.String(), "X4") {
+		t.Fatalf("X4 is outside the tolerance margin, it should have been removed: %q", out.String())
+	}
+}
+
+func TestOptimize_CollapseBlanksDropsConsecutiveBlankLines(t *testing.T) {
+	input := "G1 X1 Z-1 F300\n\n\n\nG1 X2 Z-1 F300\n\nG1 X3 Z-1 F300\n"
+
+	out := &bytes.Buffer{}
+	stats, err := Optimize(strings.NewReader(input), out, Config{CollapseBlanks: true})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+
+	if strings.Contains(out.String(), "\n\n\n") {
+		t.Fatalf("output still has consecutive blank lines: %q", out.String())
+	}
+	if stats.LinesRemoved != 2 {
+		t.Fatalf("LinesRemoved = %d, want 2 (the two extra blanks in the run of three)", stats.LinesRemoved)
+	}
+}
+
+func TestOptimize_BlankLinesPreservedByDefault(t *testing.T) {
+	input := "G1 X1 Z-1 F300\n\n\nG1 X2 Z-1 F300\n"
+
+	out := &bytes.Buffer{}
+	stats, err := Optimize(strings.NewReader(input), out, Config{})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+
+	if !strings.Contains(out.String(), "\n\n\n") {
+		t.Fatalf("consecutive blank lines should survive by default: %q", out.String())
+	}
+	if stats.LinesRemoved != 0 {
+		t.Fatalf("LinesRemoved = %d, want 0", stats.LinesRemoved)
+	}
+}
+
+func TestOptimize_OptimizeRapidsDropsRedundantZeroMoveG0(t *testing.T) {
+	// The second G0 repeats the first's endpoint exactly: a no-op rapid.
+	input := "G0 X5 Y5 Z5\nG0 X5 Y5 Z5\nG1 X5 Y5 Z-1 F300\n"
+
+	out := &bytes.Buffer{}
+	stats, err := Optimize(strings.NewReader(input), out, Config{OptimizeRapids: true})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+
+	if stats.LinesRemoved != 1 {
+		t.Fatalf("LinesRemoved = %d, want 1 (the redundant G0)", stats.LinesRemoved)
+	}
+	if strings.Count(out.String(), "G0") != 1 {
+		t.Fatalf("expected exactly one surviving G0, got: %q", out.String())
+	}
+}
+
+func TestOptimize_OptimizeRapidsNeverDropsRapidsApproachingStock(t *testing.T) {
+	// Both G0s dive to Z-5, below the reference surface; even though the
+	// second repeats the first's endpoint, a rapid that approaches the
+	// stock must never be touched.
+	input := "G0 X5 Y5 Z-5\nG0 X5 Y5 Z-5\n"
+
+	out := &bytes.Buffer{}
+	stats, err := Optimize(strings.NewReader(input), out, Config{OptimizeRapids: true})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+
+	if stats.LinesRemoved != 0 {
+		t.Fatalf("LinesRemoved = %d, want 0; rapids diving into the stock must be preserved", stats.LinesRemoved)
+	}
+	if strings.Count(out.String(), "G0") != 2 {
+		t.Fatalf("expected both G0 rapids preserved, got: %q", out.String())
+	}
+}
+
+func TestOptimize_WarnsOnCuttingMoveBeforeSpindleOn(t *testing.T) {
+	input := "G1 X1 Z-1 F300\nM3 S1000\nG1 X2 Z-1 F300\n"
+
+	out := &bytes.Buffer{}
+	stats, err := Optimize(strings.NewReader(input), out, Config{})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+
+	found := false
+	for _, w := range stats.Warnings {
+		if strings.Contains(w, "spindle-on") {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("expected a missing-spindle-on warning, got %v", stats.Warnings)
+	}
+}
+
+func TestOptimize_NoSpindleWarningWhenM3PrecedesCuts(t *testing.T) {
+	input := "M3 S1000\nG1 X1 Z-1 F300\nG1 X2 Z-1 F300\n"
+
+	out := &bytes.Buffer{}
+	stats, err := Optimize(strings.NewReader(input), out, Config{})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+
+	for _, w := range stats.Warnings {
+		if strings.Contains(w, "spindle-on") {
+			t.Fatalf("unexpected spindle-on warning when M3 precedes cuts: %v", stats.Warnings)
+		}
+	}
+}
+
+func TestOptimize_MovesOnlyDropsEverythingButMotionLines(t *testing.T) {
+	input := "; header\nM3 S1000\nG1 X1 Z-1 F300\n\nG1 X2 Z-1 F300\nM5\n"
+
+	out := &bytes.Buffer{}
+	stats, err := Optimize(strings.NewReader(input), out, Config{MovesOnly: true})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+
+	for _, line := range strings.Split(strings.TrimRight(out.String(), "\n"), "\n") {
+		if !strings.HasPrefix(line, "G1") {
+			t.Fatalf("output contains a non-motion line: %q (full output: %q)", line, out.String())
+		}
+	}
+	if stats.LinesOut != 2 {
+		t.Fatalf("LinesOut = %d, want 2", stats.LinesOut)
+	}
+	found := false
+	for _, w := range stats.Warnings {
+		if strings.Contains(w, "not a runnable program") {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("expected a not-runnable warning, got %v", stats.Warnings)
+	}
+}
+
+func TestOptimize_CheckBoundsWarnsOnOutOfBoundsMove(t *testing.T) {
+	input := "G1 X1 Z-1 F300\nG1 X200 Z-1 F300\n"
+
+	out := &bytes.Buffer{}
+	stats, err := Optimize(strings.NewReader(input), out, Config{
+		Allowance: 0, Reference: ReferenceSurface, ReferenceZ: 0, CheckBounds: true,
+		Metadata: gcode.Metadata{HasXYBounds: true, MinX: 0, MaxX: 100, MinY: 0, MaxY: 100},
+	})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+
+	if stats.OutOfBoundsMoves != 1 {
+		t.Fatalf("OutOfBoundsMoves = %d, want 1", stats.OutOfBoundsMoves)
+	}
+	found := false
+	for _, w := range stats.Warnings {
+		if strings.Contains(w, "outside the header's declared work area") {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("expected an out-of-bounds warning, got %v", stats.Warnings)
+	}
+}
+
+func TestOptimize_SpringPassRepeatsFinalContour(t *testing.T) {
+	input := "G1 X1 Z-2 F300\nG1 X2 Z-2 F300\nG1 X3 Z-2 F300\n"
+
+	out := &bytes.Buffer{}
+	stats, err := Optimize(strings.NewReader(input), out, Config{
+		Allowance: 0, Reference: ReferenceSurface, ReferenceZ: 0, SpringPass: true,
+	})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+
+	text := out.String()
+	if strings.Count(text, "X2 Z-2") != 2 {
+		t.Fatalf("expected the final contour's X2 move to appear twice, got %q", text)
+	}
+	if strings.Count(text, "X3 Z-2") != 2 {
+		t.Fatalf("expected the final contour's X3 move to appear twice, got %q", text)
+	}
+	if !strings.Contains(text, "spring pass") {
+		t.Fatalf("expected a marker comment introducing the repeated pass, got %q", text)
+	}
+	if stats.AddedLines != 4 {
+		t.Fatalf("AddedLines = %d, want 4 (1 marker + 3 repeated moves)", stats.AddedLines)
+	}
+}
+
+func TestOptimize_AllowanceExceedingDepthWarnsAndKeepsStructuralCommands(t *testing.T) {
+	input := "G90\nM3 S1000\nG1 X1 Z-1 F300\nG1 X2 Z-2 F300\nM5\n"
+
+	out := &bytes.Buffer{}
+	stats, err := Optimize(strings.NewReader(input), out, Config{
+		Allowance: 100, Reference: ReferenceSurface, ReferenceZ: 0,
+		Force: true, HasZRange: true, MinZ: -2, MaxZ: 0,
+	})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+
+	found := false
+	for _, w := range stats.Warnings {
+		if strings.Contains(w, "meets or exceeds the toolpath's full cut depth") {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("expected an allowance-exceeds-depth warning, got %v", stats.Warnings)
+	}
+
+	text := out.String()
+	for _, want := range []string{"G90", "M3 S1000", "M5"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("structural command %q missing from output: %q", want, text)
+		}
+	}
+}
+
+func TestOptimize_AllowanceExceedingDepthRefusedWithoutForce(t *testing.T) {
+	_, err := Optimize(strings.NewReader("G1 X1 Z-1 F300\n"), io.Discard, Config{
+		Allowance: 100, Reference: ReferenceSurface, ReferenceZ: 0,
+		HasZRange: true, MinZ: -1, MaxZ: 0,
+	})
+	if !errors.Is(err, ErrAllowanceExceedsDepth) {
+		t.Fatalf("err = %v, want ErrAllowanceExceedsDepth", err)
+	}
+}
+
+func TestOptimize_StampWithParenCommentPrefix(t *testing.T) {
+	out := &bytes.Buffer{}
+	_, err := Optimize(strings.NewReader("G1 Z-1 F300\n"), out, Config{
+		Allowance: 0, Reference: ReferenceSurface, ReferenceZ: 0,
+		Stamp: true, CommentPrefix: "(",
+	})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+
+	firstLine := strings.SplitN(out.String(), "\n", 2)[0]
+	if firstLine != "( optimized_by: gcode-optimizer )" {
+		t.Fatalf("first line = %q, want \"( optimized_by: gcode-optimizer )\"", firstLine)
+	}
+}
+
+func TestOptimize_StampDefaultsToSemicolonComment(t *testing.T) {
+	out := &bytes.Buffer{}
+	_, err := Optimize(strings.NewReader("G1 Z-1 F300\n"), out, Config{
+		Allowance: 0, Reference: ReferenceSurface, ReferenceZ: 0, Stamp: true,
+	})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+
+	firstLine := strings.SplitN(out.String(), "\n", 2)[0]
+	if firstLine != "; optimized_by: gcode-optimizer" {
+		t.Fatalf("first line = %q, want \"; optimized_by: gcode-optimizer\"", firstLine)
+	}
+}
+
+func TestOptimize_WarnsOnUnsafeG0Rapid(t *testing.T) {
+	data, err := os.ReadFile("testdata/unsafe_g0.gcode")
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+
+	out := &bytes.Buffer{}
+	stats, err := Optimize(bytes.NewReader(data), out, Config{Allowance: 0, Reference: ReferenceSurface, ReferenceZ: 0})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+
+	if stats.UnsafeRapids != 1 {
+		t.Fatalf("UnsafeRapids = %d, want 1", stats.UnsafeRapids)
+	}
+	found := false
+	for _, w := range stats.Warnings {
+		if strings.Contains(w, "G0 rapid") {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("expected an unsafe-G0 warning, got %v", stats.Warnings)
+	}
+	// G0 is always preserved regardless.
+	if !strings.Contains(out.String(), "G0 X10 Y10 Z-2") {
+		t.Fatalf("unsafe G0 should still be passed through, got %q", out.String())
+	}
+}
+
+func TestOptimize_InvertKeepsShallowDropsDeep(t *testing.T) {
+	input := "G0 Z5\nG1 Z2 F300\nG1 Z-1 F300\nG1 Z0.5 F300\nM3\n"
+
+	out := &bytes.Buffer{}
+	stats, err := Optimize(strings.NewReader(input), out, Config{Allowance: 0, Reference: ReferenceSurface, ReferenceZ: 0, Invert: true})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+
+	if stats.LinesRemoved != 1 {
+		t.Fatalf("LinesRemoved = %d, want 1", stats.LinesRemoved)
+	}
+	got := out.String()
+	if !strings.Contains(got, "G1 Z2 F300") || !strings.Contains(got, "G1 Z0.5 F300") {
+		t.Fatalf("expected shallow moves kept, got %q", got)
+	}
+	if strings.Contains(got, "G1 Z-1 F300") {
+		t.Fatalf("expected deep move removed, got %q", got)
+	}
+	if !strings.Contains(got, "G0 Z5") || !strings.Contains(got, "M3") {
+		t.Fatalf("expected G0/M-codes to pass through, got %q", got)
+	}
+}
+
+func TestAnalyze_MatchesOptimizeStatistics(t *testing.T) {
+	input := "G0 Z5\nG1 Z2 F300\nG1 Z-1 F300\nG1 Z0.5 F300\n"
+	cfg := Config{Allowance: 0, Reference: ReferenceSurface, ReferenceZ: 0}
+
+	analyzeStats, err := Analyze(strings.NewReader(input), cfg)
+	if err != nil {
+		t.Fatalf("Analyze: %v", err)
+	}
+
+	out := &bytes.Buffer{}
+	optimizeStats, err := Optimize(strings.NewReader(input), out, cfg)
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+
+	if analyzeStats.LinesRemoved != optimizeStats.LinesRemoved ||
+		analyzeStats.LinesOut != optimizeStats.LinesOut ||
+		analyzeStats.TimeSavedSeconds != optimizeStats.TimeSavedSeconds {
+		t.Fatalf("Analyze %+v != Optimize %+v", analyzeStats, optimizeStats)
+	}
+}
+
+func TestOptimize_MixedEndingsWarnsAndNormalizes(t *testing.T) {
+	input := "G0 Z5\r\nG1 Z-1 F300\nM5\r\n"
+
+	out := &bytes.Buffer{}
+	stats, err := Optimize(strings.NewReader(input), out, Config{NormalizeEndings: "crlf"})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+
+	found := false
+	for _, w := range stats.Warnings {
+		if strings.Contains(w, "mixes CRLF and LF") {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("expected mixed-endings warning, got %v", stats.Warnings)
+	}
+
+	want := "G0 Z5\r\nG1 Z-1 F300\r\nM5\r\n"
+	if out.String() != want {
+		t.Fatalf("got %q, want %q", out.String(), want)
+	}
+}
+
+func TestOptimize_ByLayerSections(t *testing.T) {
+	// Each layer's first shallow move is a crossing (kept entirely); a
+	// second move at the same shallow Z (NoCrossing) is the one actually
+	// removed.
+	input := "; Layer 1\nG1 Z0.5 F300\nG1 Z0.5 F300\nG1 Z-1 F300\n; Layer 2\nG1 Z0.5 F300\nG1 Z0.5 F300\n"
+
+	out := &bytes.Buffer{}
+	stats, err := Optimize(strings.NewReader(input), out, Config{Allowance: 0, Reference: ReferenceSurface, ReferenceZ: 0, ByLayer: true})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+
+	if len(stats.Sections) != 3 {
+		t.Fatalf("Sections = %+v, want 3 (before-first-layer, layer 1, layer 2)", stats.Sections)
+	}
+	layer1 := stats.Sections[1]
+	if layer1.LinesRemoved != 1 || layer1.LinesKept != 3 {
+		t.Fatalf("layer 1 = %+v, want 1 removed, 3 kept (the \"; Layer 1\" header plus 2 kept moves)", layer1)
+	}
+	layer2 := stats.Sections[2]
+	if layer2.LinesRemoved != 1 || layer2.LinesKept != 2 {
+		t.Fatalf("layer 2 = %+v, want 1 removed, 2 kept (the \"; Layer 2\" header plus 1 kept move)", layer2)
+	}
+}
+
+func TestOptimize_G93WarnsAndStillFilters(t *testing.T) {
+	input := "G93\nG1 Z2 F0.5\nG1 Z2 F0.5\nG1 Z-1 F0.5\n"
+
+	out := &bytes.Buffer{}
+	stats, err := Optimize(strings.NewReader(input), out, Config{Allowance: 0, Reference: ReferenceSurface, ReferenceZ: 0})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+
+	if stats.LinesRemoved != 1 {
+		t.Fatalf("LinesRemoved = %d, want 1", stats.LinesRemoved)
+	}
+	if stats.TimeSavedSeconds != 0 {
+		t.Fatalf("TimeSavedSeconds = %v, want 0 under G93", stats.TimeSavedSeconds)
+	}
+
+	found := false
+	for _, w := range stats.Warnings {
+		if w == "G93 (inverse-time feed) is active; time estimation for moves in this mode is skipped" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("expected G93 warning, got %v", stats.Warnings)
+	}
+}
+
+func TestOptimize_SplitSizeProducesMultipleParts(t *testing.T) {
+	var b strings.Builder
+	b.WriteString(";header_type: cnc\n")
+	b.WriteString("M3 S1000\n")
+	for i := 1; i <= 50; i++ {
+		fmt.Fprintf(&b, "G1 X%d Z-2 F300\n", i)
+	}
+	input := b.String()
+
+	first := &bytes.Buffer{}
+	var laterParts []*bytes.Buffer
+	cfg := Config{
+		Allowance: 0, Reference: ReferenceSurface, ReferenceZ: 0,
+		SplitSize: 200,
+		NewPart: func(part int) (io.Writer, error) {
+			buf := &bytes.Buffer{}
+			laterParts = append(laterParts, buf)
+			return buf, nil
+		},
+	}
+
+	stats, err := Optimize(strings.NewReader(input), first, cfg)
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+	if len(laterParts) == 0 {
+		t.Fatal("expected SplitSize to trigger at least one additional part")
+	}
+	if stats.LinesRemoved != 0 {
+		t.Fatalf("LinesRemoved = %d, want 0 (every move is deep)", stats.LinesRemoved)
+	}
+
+	allParts := append([]*bytes.Buffer{first}, laterParts...)
+	for i, p := range allParts {
+		text := p.String()
+		if !strings.Contains(text, ";header_type: cnc") {
+			t.Fatalf("part %d missing repeated header:\n%s", i+1, text)
+		}
+		if !strings.Contains(text, "M3 S1000") {
+			t.Fatalf("part %d missing repeated spindle-on:\n%s", i+1, text)
+		}
+		if i < len(allParts)-1 && !strings.Contains(text, "M5") {
+			t.Fatalf("part %d (not the last) missing generated spindle-off footer:\n%s", i+1, text)
+		}
+	}
+
+	if stats.AddedLines == 0 {
+		t.Fatal("AddedLines = 0, want non-zero for a split-heavy run (repeated headers and generated footers)")
+	}
+}
+
+// TestOptimize_RetraceAtDifferentDepthsNeverCollapsed guards against a
+// future dedupe/merge optimization treating two deep, kept moves that
+// share an XY path at different Z (finishing stepdowns) as redundant.
+// Optimize has no move-merging stage today - every kept move is emitted
+// independently - but this pins that behavior down explicitly so it can't
+// regress silently. (A move that doesn't change position at all is a
+// separate, already-tested case: see TestOptimize_DropsZeroLengthMovesByDefault.)
+func TestOptimize_RetraceAtDifferentDepthsNeverCollapsed(t *testing.T) {
+	input := "G1 X1 Y1 Z-1 F300\nG1 X1 Y1 Z-2 F300\nG1 X1 Y1 Z-3 F300\n"
+
+	out := &bytes.Buffer{}
+	stats, err := Optimize(strings.NewReader(input), out, Config{
+		Allowance: 0, Reference: ReferenceSurface, ReferenceZ: 0,
+	})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+
+	if got := strings.Count(out.String(), "\n"); got != 3 {
+		t.Fatalf("output = %q, want all 3 retrace moves kept verbatim", out.String())
+	}
+	if stats.LinesRemoved != 0 {
+		t.Fatalf("LinesRemoved = %d, want 0 (retracing the same XY at a different depth is never a dedupe candidate)", stats.LinesRemoved)
+	}
+}
+
+// TestOptimize_PercentDelimitersSurviveWithoutWarning checks that the
+// Fanuc-style "%" program start/end markers pass through untouched and
+// don't produce any warning, since they carry no recognized command word
+// and so are treated like any other pass-through line.
+func TestOptimize_PercentDelimitersSurviveWithoutWarning(t *testing.T) {
+	input := "%\nM3 S1000\nG1 X1 Z-1 F300\n%\n"
+
+	out := &bytes.Buffer{}
+	stats, err := Optimize(strings.NewReader(input), out, Config{
+		Allowance: 0, Reference: ReferenceSurface, ReferenceZ: 0,
+	})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+
+	if out.String() != input {
+		t.Fatalf("output = %q, want %q (delimiters preserved verbatim)", out.String(), input)
+	}
+	if len(stats.Warnings) != 0 {
+		t.Fatalf("Warnings = %v, want none", stats.Warnings)
+	}
+}
+
+func TestOptimize_ZAliasTreatsSecondaryAxisAsDepth(t *testing.T) {
+	// W stands in for Z here (a quill/secondary depth axis): W0.5 is
+	// shallow, W-1 is deep, exactly as if they'd been written as Z. The
+	// first W0.5 move crosses out of material and is kept entirely; the
+	// second, staying at the same shallow W, is the one removed.
+	input := "G1 X1 W0.5 F300\nG1 X1.5 W0.5 F300\nG1 X2 W-1 F300\n"
+
+	out := &bytes.Buffer{}
+	stats, err := Optimize(strings.NewReader(input), out, Config{
+		Allowance: 0, Reference: ReferenceSurface, ReferenceZ: 0, ZAlias: 'W',
+	})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+
+	if stats.LinesRemoved != 1 {
+		t.Fatalf("LinesRemoved = %d, want 1 (only the shallow W0.5 move)", stats.LinesRemoved)
+	}
+	if !strings.Contains(out.String(), "W-1") {
+		t.Fatalf("expected the deep W-1 move kept, got %q", out.String())
+	}
+}
+
+func TestOptimize_ToolDiameterEstimatesRemovedCoverageArea(t *testing.T) {
+	// The first move crosses out of material from the initial Z0 (a
+	// CrossingLeave, kept, with no XY travel anyway); the second stays above
+	// the surface and is removed, its XY travel a 3-4-5 triangle (5 units).
+	// The third move plunges below the surface and is kept.
+	input := "G1 X0 Y0 Z0.5 F300\nG1 X3 Y4 Z0.5 F300\nG1 X3 Y4 Z-1 F300\n"
+
+	out := &bytes.Buffer{}
+	stats, err := Optimize(strings.NewReader(input), out, Config{
+		Allowance: 0, Reference: ReferenceSurface, ReferenceZ: 0, ToolDiameter: 2,
+	})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+
+	if math.Abs(stats.RemovedXYDistance-5) > 1e-9 {
+		t.Fatalf("RemovedXYDistance = %v, want 5", stats.RemovedXYDistance)
+	}
+	if math.Abs(stats.RemovedCoverageArea-10) > 1e-9 {
+		t.Fatalf("RemovedCoverageArea = %v, want 10 (5 * tool diameter 2)", stats.RemovedCoverageArea)
+	}
+}
+
+// TestOptimize_CollapseRetractsRemovesAPureZRetractApproachPairWithNoCutBetween
+// builds a file where a shallow span's removal would normally leave behind
+// "G0 Z5" (retract) immediately followed by "G0 Z5" (approach) with nothing
+// cut between - pure wasted motion that -collapse-retracts should drop.
+func TestOptimize_CollapseRetractsRemovesAPureZRetractApproachPairWithNoCutBetween(t *testing.T) {
+	input := "G1 X1 Y1 Z-1 F300\n" +
+		"G0 Z5\n" +
+		"G0 Z5\n" +
+		"G1 X2 Y2 Z-1 F300\n"
+
+	out := &bytes.Buffer{}
+	stats, err := Optimize(strings.NewReader(input), out, Config{
+		CollapseRetracts: true,
+	})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+	if strings.Count(out.String(), "G0 Z5") != 0 {
+		t.Fatalf("expected the retract/approach pair collapsed away, got %q", out.String())
+	}
+	if stats.LinesRemoved != 2 {
+		t.Fatalf("LinesRemoved = %d, want 2", stats.LinesRemoved)
+	}
+}
+
+// TestOptimize_CollapseRetractsKeepsAGenuineRetractTravelApproachSequence
+// checks a retract/approach pair with an intervening kept line (a cut, here)
+// is left alone - it's genuine repositioning, not wasted motion.
+func TestOptimize_CollapseRetractsKeepsAGenuineRetractTravelApproachSequence(t *testing.T) {
+	input := "G1 X1 Y1 Z-1 F300\n" +
+		"G0 Z5\n" +
+		"G1 X5 Y5 Z-1 F300\n" +
+		"G0 Z5\n"
+
+	out := &bytes.Buffer{}
+	stats, err := Optimize(strings.NewReader(input), out, Config{
+		CollapseRetracts: true,
+	})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+	if strings.Count(out.String(), "G0 Z5") != 2 {
+		t.Fatalf("expected both G0 Z5 lines kept (genuine retract/travel/approach), got %q", out.String())
+	}
+	if stats.LinesRemoved != 0 {
+		t.Fatalf("LinesRemoved = %d, want 0", stats.LinesRemoved)
+	}
+}
+
+// TestOptimize_HeaderSurvivesByteIdenticalUnderEveryStrategy guards against
+// a future classification bug (e.g. a header comment containing a stray
+// "B" tripping 4-axis detection) ever causing header content to be
+// rewritten or dropped, by asserting the header block is reproduced
+// byte-for-byte regardless of which Strategy ran over the rest of the file.
+func TestOptimize_HeaderSurvivesByteIdenticalUnderEveryStrategy(t *testing.T) {
+	header := ";Header Start\n;header_type: cnc\n;file_total_lines: 7\n;Header End\n\n"
+	body := "G90\nG1 X1 Y1 Z-10 F300\nG1 X2 Y2 Z0.5 F300\n"
+	input := header + body
+
+	for _, strategy := range []Strategy{StrategySafe, StrategySplit, StrategyAggressive, StrategyAllAxes} {
+		t.Run(string(strategy), func(t *testing.T) {
+			out := &bytes.Buffer{}
+			_, err := Optimize(strings.NewReader(input), out, Config{
+				Allowance: 20, Reference: ReferenceSurface, ReferenceZ: 0,
+				Strategy: strategy, Force: true,
+			})
+			if err != nil {
+				t.Fatalf("Optimize: %v", err)
+			}
+			if !strings.HasPrefix(out.String(), header) {
+				t.Fatalf("output did not start with the byte-identical header:\ngot:  %q\nwant prefix: %q", out.String(), header)
+			}
+		})
+	}
+}
+
+func TestOptimize_FooterSurvivesByteIdenticalUnderEveryStrategy(t *testing.T) {
+	body := "G1 X1 Y1 Z-10 F300\nG1 X2 Y2 Z0.5 F300\n"
+	footer := "G0 Z30\nM5\nM2\n"
+	input := body + footer
+
+	for _, strategy := range []Strategy{StrategySafe, StrategySplit, StrategyAggressive, StrategyAllAxes} {
+		t.Run(string(strategy), func(t *testing.T) {
+			out := &bytes.Buffer{}
+			_, err := Optimize(strings.NewReader(input), out, Config{
+				Allowance: 20, Reference: ReferenceSurface, ReferenceZ: 0,
+				Strategy: strategy, Force: true, FooterLines: 3,
+			})
+			if err != nil {
+				t.Fatalf("Optimize: %v", err)
+			}
+			if !strings.HasSuffix(out.String(), footer) {
+				t.Fatalf("output did not end with the byte-identical footer:\ngot:  %q\nwant suffix: %q", out.String(), footer)
+			}
+		})
+	}
+}
+
+func TestOptimize_FooterLinesOverridesFilteringForTheTrailingLines(t *testing.T) {
+	// Both G1 lines stay shallow the whole way (Z5->Z5->Z3, all above the
+	// Z0 reference plane) and would normally be removed outright; with
+	// FooterLines=1 the last one is the literal tail of the file, so it
+	// must survive untouched despite being otherwise filterable.
+	input := "G1 X1 Z5 F300\nG1 X2 Z3 F300\n"
+
+	out := &bytes.Buffer{}
+	stats, err := Optimize(strings.NewReader(input), out, Config{
+		Allowance: 0, Reference: ReferenceSurface, ReferenceZ: 0, FooterLines: 1,
+	})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+	if !strings.Contains(out.String(), "X2 Z3") {
+		t.Fatalf("output = %q, want the footer line preserved despite being otherwise filterable", out.String())
+	}
+	if stats.LinesRemoved != 0 {
+		t.Fatalf("LinesRemoved = %d, want 0 (the footer line must not count as removed)", stats.LinesRemoved)
+	}
+}
+
+func TestOptimize_FooterLinesDisabledByDefault(t *testing.T) {
+	input := "G1 X1 Z5 F300\nG1 X2 Z3 F300\n"
+
+	out := &bytes.Buffer{}
+	_, err := Optimize(strings.NewReader(input), out, Config{
+		Allowance: 0, Reference: ReferenceSurface, ReferenceZ: 0,
+	})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+	if strings.Contains(out.String(), "X2 Z3") {
+		t.Fatalf("output = %q, want the shallow trailing move removed as usual when FooterLines is unset", out.String())
+	}
+}
+
+// TestOptimize_LargestRemovedSpanReportsTheLongestContiguousRemovalRun
+// builds a file with two removed spans of different lengths, separated by
+// a kept deep move, and checks the longer span's move count and distance
+// are what's reported.
+func TestOptimize_LargestRemovedSpanReportsTheLongestContiguousRemovalRun(t *testing.T) {
+	input := "G1 X0 Y0 Z-5 F300\n" + // kept: deep
+		"G1 X0 Y0 Z1 F300\n" + // kept: crosses out of material (CrossingLeave)
+		"G1 X1 Y0 Z1 F300\n" + // removed: span 1, move 1 (distance 1)
+		"G1 X2 Y0 Z1 F300\n" + // removed: span 1, move 2 (distance 1)
+		"G1 X3 Y0 Z1 F300\n" + // removed: span 1, move 3 (distance 1)
+		"G1 X4 Y0 Z1 F300\n" + // removed: span 1, move 4 (distance 1) - span total 4 moves, 4mm
+		"G1 X4 Y0 Z-5 F300\n" + // kept: re-enters material (CrossingEnter), breaks the span
+		"G1 X4 Y0 Z1 F300\n" + // kept: crosses out of material again (CrossingLeave)
+		"G1 X5 Y0 Z1 F300\n" // removed: span 2, move 1 (distance 1) - span total 1 move, 1mm
+
+	out := &bytes.Buffer{}
+	stats, err := Optimize(strings.NewReader(input), out, Config{
+		Allowance: 0, Reference: ReferenceSurface, ReferenceZ: 0,
+	})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+	if stats.LargestRemovedSpanMoves != 4 {
+		t.Fatalf("LargestRemovedSpanMoves = %d, want 4 (the first, longer span)", stats.LargestRemovedSpanMoves)
+	}
+	if stats.LargestRemovedSpanDistance != 4 {
+		t.Fatalf("LargestRemovedSpanDistance = %v, want 4", stats.LargestRemovedSpanDistance)
+	}
+}
+
+func TestOptimize_RemovedFeedRangeTracksMinMaxAverage(t *testing.T) {
+	input := "G0 X0 Y0 Z5\n" + // positions above the surface without being a depth-filter candidate
+		"G1 X1 Y0 Z5 F500\n" + // removed, F500
+		"G1 X2 Y0 Z5 F1500\n" + // removed, F1500
+		"G1 X3 Y0 Z5\n" + // removed, modal F1500 carried over
+		"G1 X4 Y0 Z-5 F300\n" // kept (deep), not counted
+
+	out := &bytes.Buffer{}
+	stats, err := Optimize(strings.NewReader(input), out, Config{
+		Allowance: 0, Reference: ReferenceSurface, ReferenceZ: 0,
+	})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+	if stats.RemovedFeedCount != 3 {
+		t.Fatalf("RemovedFeedCount = %d, want 3", stats.RemovedFeedCount)
+	}
+	if stats.RemovedFeedMin != 500 {
+		t.Fatalf("RemovedFeedMin = %v, want 500", stats.RemovedFeedMin)
+	}
+	if stats.RemovedFeedMax != 1500 {
+		t.Fatalf("RemovedFeedMax = %v, want 1500", stats.RemovedFeedMax)
+	}
+	const wantAvg = (500.0 + 1500.0 + 1500.0) / 3.0
+	if avg := stats.AverageRemovedFeed(); math.Abs(avg-wantAvg) > 1e-9 {
+		t.Fatalf("AverageRemovedFeed = %v, want %v", avg, wantAvg)
+	}
+}
+
+func TestOptimize_RemovedFeedRangeZeroWhenNoRemovedMoveHadAKnownFeed(t *testing.T) {
+	// The first move crosses out of material from the initial Z0 (kept);
+	// the second stays shallow and is removed, but no F word is ever seen.
+	input := "G1 X1 Y0 Z5\nG1 X2 Y0 Z5\n"
+
+	out := &bytes.Buffer{}
+	stats, err := Optimize(strings.NewReader(input), out, Config{
+		Allowance: 0, Reference: ReferenceSurface, ReferenceZ: 0,
+	})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+	if stats.RemovedFeedCount != 0 {
+		t.Fatalf("RemovedFeedCount = %d, want 0", stats.RemovedFeedCount)
+	}
+	if stats.AverageRemovedFeed() != 0 {
+		t.Fatalf("AverageRemovedFeed = %v, want 0", stats.AverageRemovedFeed())
+	}
+}
+
+// TestOptimize_ToolFilterOnlyFiltersTheTargetedToolsSection builds a
+// two-tool file where both tools have an identical shallow move; with
+// -tool=2, only tool 2's section should have its shallow move removed,
+// tool 1's passing through untouched.
+func TestOptimize_ToolFilterOnlyFiltersTheTargetedToolsSection(t *testing.T) {
+	input := "T1 M6\n" +
+		"G1 X1 Y1 Z5 F300\n" +
+		"T2 M6\n" +
+		"G1 X1 Y1 Z5 F300\n"
+
+	out := &bytes.Buffer{}
+	stats, err := Optimize(strings.NewReader(input), out, Config{
+		Allowance: 0, Reference: ReferenceSurface, ReferenceZ: 0,
+		ToolNumber: 2, HasToolFilter: true,
+	})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+	if stats.LinesRemoved != 1 {
+		t.Fatalf("LinesRemoved = %d, want 1 (only tool 2's shallow move)", stats.LinesRemoved)
+	}
+	if strings.Count(out.String(), "T1 M6") == 0 || strings.Count(out.String(), "G1 X1 Y1 Z5 F300") != 1 {
+		t.Fatalf("expected tool 1's section untouched and tool 2's shallow move removed, got %q", out.String())
+	}
+}
+
+func TestOptimize_FloorProtectsDeepestMovesFromAnOverAggressiveAllowance(t *testing.T) {
+	// Four cutting moves at Z -1, -2, -3, -4. An allowance of 5 would
+	// normally remove every one of them (all shallower than the full
+	// cut depth). A 50% floor must still keep the deepest two (-3, -4).
+	input := "G1 X1 Z-1 F300\nG1 X2 Z-2 F300\nG1 X3 Z-3 F300\nG1 X4 Z-4 F300\n"
+
+	threshold, ok, err := DepthPercentile(strings.NewReader(input), 50)
+	if err != nil {
+		t.Fatalf("DepthPercentile: %v", err)
+	}
+	if !ok {
+		t.Fatal("DepthPercentile: ok = false, want true")
+	}
+	if threshold != -3 {
+		t.Fatalf("threshold = %v, want -3 (the deepest 50%% boundary)", threshold)
+	}
+
+	out := &bytes.Buffer{}
+	stats, err := Optimize(strings.NewReader(input), out, Config{
+		Allowance: 5, Reference: ReferenceSurface, ReferenceZ: 0,
+		FloorThreshold: threshold, HasFloor: true, Force: true,
+		MinZ: -4, MaxZ: -1, HasZRange: true,
+	})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+	if stats.LinesRemoved != 2 {
+		t.Fatalf("LinesRemoved = %d, want 2 (the shallow Z-1 and Z-2 moves)", stats.LinesRemoved)
+	}
+	if !strings.Contains(out.String(), "Z-3") || !strings.Contains(out.String(), "Z-4") {
+		t.Fatalf("expected the deepest two moves kept by -floor, got %q", out.String())
+	}
+}
+
+func TestOptimize_NormalizeEndingsAutoReplicatesDetectedInputEnding(t *testing.T) {
+	input := "G1 X1 Z-1 F300\r\nG1 X2 Z-2 F300\r\n"
+
+	out := &bytes.Buffer{}
+	if _, err := Optimize(strings.NewReader(input), out, Config{}); err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+	want := "G1 X1 Z-1 F300\r\nG1 X2 Z-2 F300\r\n"
+	if out.String() != want {
+		t.Fatalf("output = %q, want %q (CRLF preserved by auto-detection)", out.String(), want)
+	}
+}
+
+func TestOptimize_NormalizeEndingsLFForcesLFRegardlessOfInput(t *testing.T) {
+	input := "G1 X1 Z-1 F300\r\nG1 X2 Z-2 F300\r\n"
+
+	out := &bytes.Buffer{}
+	if _, err := Optimize(strings.NewReader(input), out, Config{NormalizeEndings: "lf"}); err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+	want := "G1 X1 Z-1 F300\nG1 X2 Z-2 F300\n"
+	if out.String() != want {
+		t.Fatalf("output = %q, want %q", out.String(), want)
+	}
+}
+
+func TestOptimize_NormalizeEndingsCRLFForcesCRLFRegardlessOfInput(t *testing.T) {
+	input := "G1 X1 Z-1 F300\nG1 X2 Z-2 F300\n"
+
+	out := &bytes.Buffer{}
+	if _, err := Optimize(strings.NewReader(input), out, Config{NormalizeEndings: "crlf"}); err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+	want := "G1 X1 Z-1 F300\r\nG1 X2 Z-2 F300\r\n"
+	if out.String() != want {
+		t.Fatalf("output = %q, want %q", out.String(), want)
+	}
+}
+
+func TestOptimize_BytesOutReflectsWriterNotFileStat(t *testing.T) {
+	// out is a plain bytes.Buffer, not a file, so BytesOut can only come
+	// from the writer's own running total. The first shallow move crosses
+	// out of material and is kept; the second, staying at the same
+	// shallow Z, is the one dropped.
+	input := "G1 X1 Z0.5 F300\nG1 X1.5 Z0.5 F300\nG1 X2 Z-1 F300\n"
+
+	out := &bytes.Buffer{}
+	stats, err := Optimize(strings.NewReader(input), out, Config{
+		Allowance: 0, Reference: ReferenceSurface, ReferenceZ: 0,
+	})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+
+	if stats.BytesOut == 0 {
+		t.Fatal("BytesOut = 0, want it populated from the writer's byte count")
+	}
+	if int(stats.BytesOut) != out.Len() {
+		t.Fatalf("BytesOut = %d, want %d (matching the actual written output)", stats.BytesOut, out.Len())
+	}
+	if stats.BytesOut >= stats.BytesIn {
+		t.Fatalf("BytesOut = %d, want less than BytesIn = %d since the shallow move was dropped", stats.BytesOut, stats.BytesIn)
+	}
+}
+
+func TestOptimize_InvalidFeedRateIsWarnedButLeftAsIsWithoutFixFeed(t *testing.T) {
+	input := "G1 X1 Y0 Z-1 F0\n" +
+		"G1 X2 Y0 Z-1 F-100\n"
+
+	out := &bytes.Buffer{}
+	stats, err := Optimize(strings.NewReader(input), out, Config{
+		Allowance: 0, Reference: ReferenceSurface, ReferenceZ: 0,
+	})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+	if stats.InvalidFeedRates != 2 {
+		t.Fatalf("InvalidFeedRates = %d, want 2", stats.InvalidFeedRates)
+	}
+	if !strings.Contains(out.String(), "F0") || !strings.Contains(out.String(), "F-100") {
+		t.Fatalf("expected invalid F words left unchanged without -fix-feed, got %q", out.String())
+	}
+}
+
+func TestOptimize_FixFeedReplacesInvalidFeedWithLastValidModalFeed(t *testing.T) {
+	input := "G1 X1 Y0 Z-1 F300\n" +
+		"G1 X2 Y0 Z-1 F0\n"
+
+	out := &bytes.Buffer{}
+	stats, err := Optimize(strings.NewReader(input), out, Config{
+		Allowance: 0, Reference: ReferenceSurface, ReferenceZ: 0,
+		FixFeed: true,
+	})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+	if stats.InvalidFeedRates != 1 {
+		t.Fatalf("InvalidFeedRates = %d, want 1", stats.InvalidFeedRates)
+	}
+	if strings.Contains(out.String(), "F0\n") {
+		t.Fatalf("expected F0 replaced with the last valid modal feed rate, got %q", out.String())
+	}
+	if strings.Count(out.String(), "F300") != 2 {
+		t.Fatalf("expected the F0 line fixed to F300, got %q", out.String())
+	}
+}
+
+func TestOptimize_FixFeedUsesDefaultFeedWhenNoValidFeedSeenYet(t *testing.T) {
+	input := "G1 X1 Y0 Z-1 F-100\n"
+
+	out := &bytes.Buffer{}
+	stats, err := Optimize(strings.NewReader(input), out, Config{
+		Allowance: 0, Reference: ReferenceSurface, ReferenceZ: 0,
+		FixFeed: true, DefaultFeed: 500,
+	})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+	if stats.InvalidFeedRates != 1 {
+		t.Fatalf("InvalidFeedRates = %d, want 1", stats.InvalidFeedRates)
+	}
+	if !strings.Contains(out.String(), "F500") {
+		t.Fatalf("expected F-100 replaced with -default-feed=500, got %q", out.String())
+	}
+}
+
+func TestOptimize_NoOpPassPreservesAMissingFinalNewline(t *testing.T) {
+	input := "G1 X1 Y0 Z-1 F300\nG1 X2 Y0 Z-1 F300"
+
+	out := &bytes.Buffer{}
+	_, err := Optimize(strings.NewReader(input), out, Config{
+		Allowance: 0, Reference: ReferenceSurface, ReferenceZ: 0,
+	})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+	if strings.HasSuffix(out.String(), "\n") {
+		t.Fatalf("output = %q, want no trailing newline since the input had none", out.String())
+	}
+	if out.String() != input {
+		t.Fatalf("output = %q, want byte-identical to input %q", out.String(), input)
+	}
+}
+
+func TestOptimize_SmoothInsertsARampAcrossAGapLeftByRemoval(t *testing.T) {
+	// Plunge to Z-5 at X0, a bump up to Z1 that crosses out of material
+	// (kept entirely), a second shallow move at the same Z1 that gets
+	// removed as a no-op, then a plunge to Z-15 at X40. With that second
+	// move gone, output would otherwise jump straight from (X0, Z1) to
+	// (X40, Z-15) in one 40-unit-XY, 16-unit-Z move - far steeper than
+	// DefaultRampAngle (3 degrees, max ~2.1mm of descent over 40mm of
+	// travel).
+	input := "G1 X0 Y0 Z-5 F300\n" +
+		"G1 X0 Y0 Z1 F300\n" +
+		"G1 X40 Y0 Z1 F300\n" +
+		"G1 X40 Y0 Z-15 F300\n"
+
+	out := &bytes.Buffer{}
+	stats, err := Optimize(strings.NewReader(input), out, Config{
+		Allowance: 0, Reference: ReferenceSurface, ReferenceZ: 0, Smooth: true,
+	})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+
+	var kept []gcode.Line
+	for i, raw := range strings.Split(strings.TrimRight(out.String(), "\n"), "\n") {
+		kept = append(kept, gcode.Parse(raw, i+1))
+	}
+	if len(kept) != 4 {
+		t.Fatalf("got %d output lines, want 4 (plunge, crossing move kept, ramp, plunge): %q", len(kept), out.String())
+	}
+
+	ramp := kept[2]
+	rampZ, _ := ramp.Get('Z')
+	wantRampZ := 1 - 40*math.Tan(DefaultRampAngle*math.Pi/180)
+	if math.Abs(rampZ-wantRampZ) > 1e-4 {
+		t.Fatalf("ramp Z = %v, want %v", rampZ, wantRampZ)
+	}
+	rampX, _ := ramp.Get('X')
+	if rampX != 40 {
+		t.Fatalf("ramp X = %v, want 40 (the full travel, ramped gradually)", rampX)
+	}
+	rampF, hasF := ramp.Get('F')
+	if !hasF || rampF != 300 {
+		t.Fatalf("ramp F = %v (hasF=%v), want 300 carried over from the move it precedes", rampF, hasF)
+	}
+
+	final := kept[3]
+	finalZ, _ := final.Get('Z')
+	if finalZ != -15 {
+		t.Fatalf("final Z = %v, want -15 (the original target, unchanged)", finalZ)
+	}
+
+	if stats.AddedLines != 1 {
+		t.Fatalf("AddedLines = %d, want 1 (the inserted ramp)", stats.AddedLines)
+	}
+}
+
+func TestOptimize_SmoothDoesNothingWhenNoRemovalCreatedAGap(t *testing.T) {
+	input := "G1 X0 Y0 Z-5 F300\nG1 X40 Y0 Z-15 F300\n"
+
+	out := &bytes.Buffer{}
+	stats, err := Optimize(strings.NewReader(input), out, Config{
+		Allowance: 0, Reference: ReferenceSurface, ReferenceZ: 0, Smooth: true,
+	})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+	if out.String() != input {
+		t.Fatalf("output = %q, want unchanged %q (steepness that was already in the source isn't Smooth's concern)", out.String(), input)
+	}
+	if stats.AddedLines != 0 {
+		t.Fatalf("AddedLines = %d, want 0", stats.AddedLines)
+	}
+}
+
+func TestOptimize_SurfaceBoundaryAtTheReferencePlaneAcrossReferenceModes(t *testing.T) {
+	cases := []struct {
+		name       string
+		mode       ReferenceMode
+		referenceZ float64
+		z          string // the line's Z word, exactly at the reference plane
+	}{
+		{"surface", ReferenceSurface, 0, "Z0"},
+		{"machine origin", ReferenceMachineOrigin, -10, "Z-10"},
+		{"metadata", ReferenceMetadata, 3, "Z3"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// A deep anchor move establishes Z well below the plane
+			// (always kept, crossing or not), a second move brings Z up
+			// to exactly the plane (also always kept: deep-to-plane is
+			// either a NoCrossing-deep move under SurfaceKeep or a
+			// CrossingLeave under SurfaceRemove, and crossingPredicate
+			// keeps crossings outright). Only the third move, staying at
+			// the plane on both ends and therefore never a crossing,
+			// actually exercises the boundary's shallow/deep call.
+			anchor := fmt.Sprintf("Z%v", c.referenceZ-1000)
+			input := "G1 X0 " + anchor + " F300\n" +
+				"G1 X0 " + c.z + " F300\n" +
+				"G1 X1 " + c.z + " F300\n"
+
+			keepOut := &bytes.Buffer{}
+			keepStats, err := Optimize(strings.NewReader(input), keepOut, Config{
+				Allowance: 0, Reference: c.mode, ReferenceZ: c.referenceZ, SurfaceBoundary: SurfaceKeep,
+			})
+			if err != nil {
+				t.Fatalf("Optimize (keep): %v", err)
+			}
+			if keepStats.LinesRemoved != 0 {
+				t.Fatalf("SurfaceKeep: LinesRemoved = %d, want 0 (move exactly at the plane is kept)", keepStats.LinesRemoved)
+			}
+
+			removeOut := &bytes.Buffer{}
+			removeStats, err := Optimize(strings.NewReader(input), removeOut, Config{
+				Allowance: 0, Reference: c.mode, ReferenceZ: c.referenceZ, SurfaceBoundary: SurfaceRemove,
+			})
+			if err != nil {
+				t.Fatalf("Optimize (remove): %v", err)
+			}
+			if removeStats.LinesRemoved != 1 {
+				t.Fatalf("SurfaceRemove: LinesRemoved = %d, want 1 (move exactly at the plane is removed)", removeStats.LinesRemoved)
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package optimizer
+
+import (
+	"math"
+
+	"github.com/chrisns/snapmaker-cnc-finisher/internal/gcode"
+)
+
+// Command identifies the G-code motion command a MoveContext describes,
+// e.g. "G1".
+type Command string
+
+// Decision is what a MovePredicate concludes about a move.
+type Decision int
+
+const (
+	// NoOpinion defers to the next predicate in the pipeline; if every
+	// predicate abstains, the move is kept.
+	NoOpinion Decision = iota
+	// Keep forces the move to be retained, regardless of later predicates.
+	Keep
+	// Remove forces the move to be dropped, regardless of later predicates.
+	Remove
+)
+
+// MoveContext carries what a MovePredicate needs to judge a single cutting
+// move: the command, its resolved start and end position, the depth
+// threshold it's being judged against, and the modal state relevant to
+// rotary jobs and run-aware predicates (min-segment, plunge-preservation).
+type MoveContext struct {
+	Cmd Command
+
+	StartX, StartY, StartZ float64
+	X, Y, Z                float64 // resolved end position
+
+	Threshold float64 // referenceZ - allowance; below this is "deep"
+	DeltaB    float64 // B-axis rotation over the move, for rotary jobs
+	Rotary    bool    // Config.Rotary && Metadata.Is4Axis
+	// AllAxes is true under StrategyAllAxes: it disables depthPredicate's
+	// rotary protection, so a shallow move is removed purely on Z depth
+	// even if it rotates B meaningfully. Every other strategy leaves
+	// Rotary's protection in effect.
+	AllAxes bool
+
+	// Boundary controls whether a move exactly at Threshold is shallow
+	// (SurfaceRemove) or deep (SurfaceKeep, the default). See
+	// Config.SurfaceBoundary.
+	Boundary SurfaceBoundary
+
+	// FloorThreshold and HasFloor mirror Config.FloorThreshold/HasFloor's
+	// safety backstop: when HasFloor is set, a move ending at or below
+	// FloorThreshold is always kept, regardless of Threshold. HasFloor is
+	// false unless Config.HasFloor was set.
+	FloorThreshold float64
+	HasFloor       bool
+
+	// PrevMotion is the motion mode (0/1/2/3, matching the G-code number)
+	// of the line immediately before this move, or -1 if this is the
+	// first move in the program.
+	PrevMotion int
+	// InCuttingRun reports whether this move continues a run of cutting
+	// moves (PrevMotion was G1/G2/G3) rather than being the first cut
+	// after a G0 repositioning rapid.
+	InCuttingRun bool
+
+	// Meta is the job's header metadata, or nil if none was supplied.
+	Meta *gcode.Metadata
+
+	// ReferenceZ is Config.ReferenceZ, the raw reference plane a move is
+	// above/below - unlike Threshold, it isn't shifted by Allowance or
+	// Tolerance. Only aboveSurfacePredicate consults it.
+	ReferenceZ float64
+	// KeepAboveSurface mirrors Config.KeepAboveSurface: when set,
+	// aboveSurfacePredicate keeps any move ending above ReferenceZ
+	// outright, before Allowance-based filtering gets a say.
+	KeepAboveSurface bool
+}
+
+// MovePredicate judges a single move and returns Keep, Remove, or
+// NoOpinion to let a later predicate in the pipeline decide.
+type MovePredicate func(ctx MoveContext) Decision
+
+// evaluatePredicates runs ctx through preds in order, returning the first
+// non-NoOpinion Decision. A move every predicate abstains on is kept,
+// matching Optimize's existing default of keeping anything not positively
+// identified as removable.
+func evaluatePredicates(ctx MoveContext, preds []MovePredicate) Decision {
+	for _, p := range preds {
+		if d := p(ctx); d != NoOpinion {
+			return d
+		}
+	}
+	return Keep
+}
+
+// DefaultPredicates returns the depth/rotary filtering Optimize itself
+// uses, as an ordered predicate pipeline. It exists so advanced callers can
+// compose additional predicates ahead of or behind it rather than
+// reimplementing the depth logic from scratch.
+func DefaultPredicates() []MovePredicate {
+	return []MovePredicate{aboveSurfacePredicate, crossingPredicate, floorPredicate, depthPredicate}
+}
+
+// aboveSurfacePredicate keeps a move outright when it ends above
+// ctx.ReferenceZ and ctx.KeepAboveSurface is set, overriding Allowance-based
+// removal entirely - Config.KeepAboveSurface's escape hatch for engraving
+// jobs referenced above the stock surface, where the usual zero-allowance
+// removal of positive-Z moves would delete intended travel rather than
+// wasted motion. It's a no-op whenever KeepAboveSurface is false, so it
+// costs nothing by default, and it runs first so no other predicate gets a
+// chance to remove a move it protects.
+func aboveSurfacePredicate(ctx MoveContext) Decision {
+	if ctx.KeepAboveSurface && ctx.Z > ctx.ReferenceZ {
+		return Keep
+	}
+	return NoOpinion
+}
+
+// floorPredicate keeps a move outright when it ends at or below
+// ctx.FloorThreshold, Config.FloorThreshold's backstop against an
+// over-aggressive Allowance deleting real contour. It's a no-op (NoOpinion)
+// whenever ctx.HasFloor is false, so it costs nothing when no floor is set.
+func floorPredicate(ctx MoveContext) Decision {
+	if ctx.HasFloor && ctx.Z <= ctx.FloorThreshold {
+		return Keep
+	}
+	return NoOpinion
+}
+
+// ClassifyMove reports how the move in ctx sits relative to ctx.Threshold,
+// using both its start and end Z - so a plunge into material (CrossingEnter)
+// is told apart from a retract out of it (CrossingLeave) even for a pure-Z
+// move with no X/Y travel at all.
+func ClassifyMove(ctx MoveContext) Crossing {
+	startShallow := isShallow(ctx.StartZ, ctx.Threshold, ctx.Boundary)
+	endShallow := isShallow(ctx.Z, ctx.Threshold, ctx.Boundary)
+	return ClassifyCrossing(startShallow, endShallow)
+}
+
+// crossingPredicate keeps a move entirely when it crosses the threshold,
+// either entering material (CrossingEnter, the initial plunge into a cut)
+// or leaving it (CrossingLeave, a retract partway through a cut): in both
+// cases, depthPredicate's end-Z-only view would otherwise misjudge the
+// move from whichever endpoint happens to be shallow, discarding real
+// travel the tool actually made below the threshold. It must run before
+// depthPredicate, which would otherwise only look at the end Z and, for a
+// CrossingEnter move, happen to agree here anyway - but a later predicate
+// reasoning about segment length or run state could disagree, so both
+// crossing cases are decided explicitly rather than by coincidence.
+//
+// A strategy that can split (Strategy.splits()) intercepts CrossingLeave
+// moves before they ever reach FilterMove, replacing this wholesale Keep
+// with a SplitMove call that discards only the shallow tail; this
+// predicate's CrossingLeave branch only fires for a strategy that can't
+// split, where keeping the whole move is the only lossless option.
+func crossingPredicate(ctx MoveContext) Decision {
+	switch ClassifyMove(ctx) {
+	case CrossingEnter, CrossingLeave:
+		return Keep
+	default:
+		return NoOpinion
+	}
+}
+
+// FilterMove reports whether the move described by ctx should be removed,
+// running it through DefaultPredicates(). Code that only has the
+// shallow/deltaB/rotary summary rather than a full MoveContext can use
+// ShouldFilterMove instead.
+func FilterMove(ctx MoveContext) bool {
+	return evaluatePredicates(ctx, DefaultPredicates()) == Remove
+}
+
+// depthPredicate removes a move that ends shallow, unless it's a rotary
+// job rotating B by more than RotaryBThreshold - the same rule
+// ShouldFilterMove has always applied, expressed as a MovePredicate. Under
+// StrategyAllAxes (ctx.AllAxes), that protection is disabled: a shallow
+// move is removed purely on Z depth regardless of B rotation.
+func depthPredicate(ctx MoveContext) Decision {
+	if ctx.Rotary && !ctx.AllAxes && math.Abs(ctx.DeltaB) > RotaryBThreshold {
+		return NoOpinion
+	}
+	if isShallow(ctx.Z, ctx.Threshold, ctx.Boundary) {
+		return Remove
+	}
+	return NoOpinion
+}
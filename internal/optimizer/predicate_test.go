@@ -0,0 +1,124 @@
+package optimizer
+
+import "testing"
+
+func TestEvaluatePredicates_ComposesTwoPredicatesInOrder(t *testing.T) {
+	// arcOverride always keeps arcs, regardless of depth; it must run
+	// before depthPredicate to have any effect, since Keep/Remove short
+	// circuits the rest of the pipeline.
+	arcOverride := func(ctx MoveContext) Decision {
+		if ctx.Cmd == "G2" || ctx.Cmd == "G3" {
+			return Keep
+		}
+		return NoOpinion
+	}
+	preds := []MovePredicate{arcOverride, depthPredicate}
+
+	shallowArc := MoveContext{Cmd: "G2", Z: 1, Threshold: 0}
+	if got := evaluatePredicates(shallowArc, preds); got != Keep {
+		t.Fatalf("shallow arc = %v, want Keep (arcOverride should win)", got)
+	}
+
+	shallowLine := MoveContext{Cmd: "G1", Z: 1, Threshold: 0}
+	if got := evaluatePredicates(shallowLine, preds); got != Remove {
+		t.Fatalf("shallow line = %v, want Remove (depthPredicate should fire)", got)
+	}
+
+	deepLine := MoveContext{Cmd: "G1", Z: -1, Threshold: 0}
+	if got := evaluatePredicates(deepLine, preds); got != Keep {
+		t.Fatalf("deep line = %v, want Keep (no predicate objects)", got)
+	}
+}
+
+func TestEvaluatePredicates_KeepsWhenEveryPredicateAbstains(t *testing.T) {
+	abstain := func(MoveContext) Decision { return NoOpinion }
+	got := evaluatePredicates(MoveContext{}, []MovePredicate{abstain, abstain})
+	if got != Keep {
+		t.Fatalf("evaluatePredicates() = %v, want Keep", got)
+	}
+}
+
+func TestClassifyMove_PureZMoves(t *testing.T) {
+	cases := []struct {
+		name         string
+		startZ, endZ float64
+		want         Crossing
+	}{
+		{"plunge", 1, -2, CrossingEnter},
+		{"retract", -2, 1, CrossingLeave},
+		{"shallow oscillation", 1, 0.5, NoCrossing},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx := MoveContext{Cmd: "G1", StartX: 5, X: 5, StartZ: c.startZ, Z: c.endZ, Threshold: 0}
+			if got := ClassifyMove(ctx); got != c.want {
+				t.Fatalf("ClassifyMove() = %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestFilterMove_PlungeAndRetractKeptOscillationRemoved(t *testing.T) {
+	plunge := MoveContext{Cmd: "G1", StartZ: 0.3, Z: -2, Threshold: 0}
+	if FilterMove(plunge) {
+		t.Fatal("plunge into material should be kept entirely")
+	}
+
+	// A retract (CrossingLeave) has real travel below the threshold too;
+	// removing it outright on end-Z alone would discard that deep portion
+	// and leave a gap. FilterMove has no way to split a single line, so
+	// keeping the whole move is the only lossless choice - a strategy
+	// that can split intercepts CrossingLeave moves before they ever
+	// reach FilterMove and does better.
+	retract := MoveContext{Cmd: "G1", StartZ: -2, Z: 0.3, Threshold: 0}
+	if FilterMove(retract) {
+		t.Fatal("retract starting deep should be kept entirely, not removed on end Z alone")
+	}
+
+	shallowOscillation := MoveContext{Cmd: "G1", StartZ: 1, Z: 0.5, Threshold: 0}
+	if !FilterMove(shallowOscillation) {
+		t.Fatal("pure-Z move that stays shallow should be removed")
+	}
+}
+
+func TestFilterMove_CrossingMovesDecidedByBothEndpointsNotJustEndZ(t *testing.T) {
+	// depthPredicate alone only looks at the resolved end position, but
+	// crossingPredicate runs first and looks at both endpoints, so a
+	// move's StartZ does flip the decision whenever it crosses the
+	// threshold either way.
+	plunge := MoveContext{Cmd: "G1", StartZ: 1, Z: -1, Threshold: 0}
+	if FilterMove(plunge) {
+		t.Fatal("plunge ending deep should be kept (FilterMove = true means removed)")
+	}
+
+	retract := MoveContext{Cmd: "G1", StartZ: -1, Z: 1, Threshold: 0}
+	if FilterMove(retract) {
+		t.Fatal("retract starting deep should be kept entirely, not removed on end Z alone")
+	}
+
+	shallowOscillation := MoveContext{Cmd: "G1", StartZ: 1, Z: 0.5, Threshold: 0}
+	if !FilterMove(shallowOscillation) {
+		t.Fatal("move starting and ending shallow should be removed")
+	}
+}
+
+func TestFilterMove_KeepAboveSurfaceOverridesAllowanceRemoval(t *testing.T) {
+	// With zero allowance, a move ending above the reference plane is
+	// ordinarily removed outright by depthPredicate (it's shallow).
+	positiveZ := MoveContext{Cmd: "G1", StartZ: 2, Z: 2, Threshold: 0, ReferenceZ: 0}
+	if !FilterMove(positiveZ) {
+		t.Fatal("positive-Z move should be removed when KeepAboveSurface is off")
+	}
+
+	positiveZ.KeepAboveSurface = true
+	if FilterMove(positiveZ) {
+		t.Fatal("positive-Z move should be kept when KeepAboveSurface is on")
+	}
+
+	// Shallow relative to Threshold (an allowance-shifted plane) but still
+	// at or below ReferenceZ itself: KeepAboveSurface shouldn't protect it.
+	belowSurface := MoveContext{Cmd: "G1", StartZ: -0.5, Z: -0.5, Threshold: -1, ReferenceZ: 0, KeepAboveSurface: true}
+	if !FilterMove(belowSurface) {
+		t.Fatal("KeepAboveSurface shouldn't protect a move that ends at or below the reference plane")
+	}
+}
@@ -0,0 +1,88 @@
+package optimizer
+
+import (
+	"bufio"
+	"io"
+	"strings"
+
+	"github.com/chrisns/snapmaker-cnc-finisher/internal/gcode"
+)
+
+// Process is the lowest-level public hook into the optimizer: it drives
+// the same modal engine and depth/rotary filtering decision Optimize's
+// core loop uses, but instead of writing anything itself, it invokes visit
+// once per source line with the line's original (unmodified) text, its
+// command, and the Keep/Remove decision - leaving output entirely up to
+// the caller. Optimize/OptimizeContext make the same decision in their own
+// loop rather than through Process, layered with reformatting, stamping,
+// splitting, renumbering, and every other output-shaping Config option
+// Process doesn't apply here.
+//
+// cfg's filtering-relevant fields are honored (Allowance, Tolerance,
+// Reference, ReferenceZ, SurfaceBoundary, Invert, Strategy, Rotary,
+// Metadata, FloorThreshold/HasFloor, KeepAboveSurface); of Strategy, only
+// StrategyAllAxes's rotary handling applies - Process never splits a
+// crossing move, whatever the strategy. Everything else (stamping, feed
+// fixing, splitting into multiple files, and so on) is ignored, since
+// those only make sense once a visitor has decided how to assemble output
+// from the per-line decisions.
+//
+// visit's error, if non-nil, stops Process immediately and is returned
+// unwrapped, the same way an io error from r would be.
+func Process(r io.Reader, cfg Config, visit func(line string, cmd Command, decision Decision) error) error {
+	state := NewState()
+	rotary := cfg.Rotary && cfg.Metadata.Is4Axis
+
+	br := bufio.NewReaderSize(r, 64*1024)
+	lineNo := 0
+	for {
+		raw, terminated, err := readLine(br)
+		if err != nil {
+			return err
+		}
+		if raw == "" && !terminated {
+			break
+		}
+		lineNo++
+
+		text := strings.TrimSuffix(raw, "\r")
+		line := gcode.Parse(text, lineNo)
+		prevX, prevY, prevZ, prevB := state.X, state.Y, state.Z, state.B
+		UpdateState(state, line)
+
+		decision := Keep
+		switch line.Code {
+		case "G1", "G2", "G3":
+			endShallow := IsShallowDepth(state.Z, cfg.ReferenceZ, cfg.effectiveAllowance(), cfg.SurfaceBoundary)
+
+			var filter bool
+			if cfg.Invert {
+				filter = ShouldFilterMove(endShallow, state.B-prevB, rotary)
+			} else {
+				filter = FilterMove(MoveContext{
+					Cmd:    Command(line.Code),
+					StartX: prevX, StartY: prevY, StartZ: prevZ,
+					X: state.X, Y: state.Y, Z: state.Z,
+					Threshold:        cfg.ReferenceZ - cfg.effectiveAllowance(),
+					DeltaB:           state.B - prevB,
+					Rotary:           rotary,
+					AllAxes:          cfg.strategy() == StrategyAllAxes,
+					Boundary:         cfg.SurfaceBoundary,
+					FloorThreshold:   cfg.FloorThreshold,
+					HasFloor:         cfg.HasFloor,
+					ReferenceZ:       cfg.ReferenceZ,
+					KeepAboveSurface: cfg.KeepAboveSurface,
+				})
+			}
+			if filter != cfg.Invert {
+				decision = Remove
+			}
+		}
+
+		if err := visit(text, Command(line.Code), decision); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,59 @@
+package optimizer
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+var errFakeVisit = errors.New("fake visit error")
+
+func TestProcess_VisitorCountsDecisionsAndReconstructsKeptOutput(t *testing.T) {
+	// G0 rapids to Z5 (always kept); Z5->Z3 and Z3->Z2 both stay shallow
+	// (removed); Z2->Z-1 crosses back into material (kept).
+	input := "G0 Z5\nG1 X1 Z3 F300\nG1 X2 Z2 F300\nG1 X3 Z-1 F300\n"
+
+	var kept, removed int
+	var out strings.Builder
+	err := Process(strings.NewReader(input), Config{
+		Allowance: 0, Reference: ReferenceSurface, ReferenceZ: 0,
+	}, func(line string, cmd Command, decision Decision) error {
+		switch decision {
+		case Keep:
+			kept++
+			out.WriteString(line)
+			out.WriteString("\n")
+		case Remove:
+			removed++
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Process: %v", err)
+	}
+
+	if kept != 2 || removed != 2 {
+		t.Fatalf("kept = %d, removed = %d, want 2, 2", kept, removed)
+	}
+	want := "G0 Z5\nG1 X3 Z-1 F300\n"
+	if out.String() != want {
+		t.Fatalf("reconstructed output = %q, want %q", out.String(), want)
+	}
+}
+
+func TestProcess_StopsAndReturnsVisitError(t *testing.T) {
+	input := "G1 X1 Z1 F300\nG1 X2 Z1 F300\n"
+	wantErr := errFakeVisit
+
+	calls := 0
+	err := Process(strings.NewReader(input), Config{}, func(line string, cmd Command, decision Decision) error {
+		calls++
+		return wantErr
+	})
+	if err != wantErr {
+		t.Fatalf("Process error = %v, want %v", err, wantErr)
+	}
+	if calls != 1 {
+		t.Fatalf("visit called %d times, want 1 (Process should stop on the first error)", calls)
+	}
+}
@@ -0,0 +1,51 @@
+package optimizer
+
+import (
+	"math"
+
+	"github.com/chrisns/snapmaker-cnc-finisher/internal/gcode"
+)
+
+// DefaultRampAngle is the descent angle, in degrees from horizontal,
+// Config.Smooth limits a move to when Config.RampAngle isn't set. It's a
+// conservative finishing-pass angle, shallow enough to avoid tool deflection
+// on a re-entry cut.
+const DefaultRampAngle = 3.0
+
+// RampMove reports the ramp move needed to keep a steep transition from
+// (fromX,fromY,fromZ) into to's endpoint (toX,toY,toZ) from exceeding
+// rampAngleDeg: a single Synthesized line that travels the full XY distance
+// while descending only as far as the angle allows, carrying to's feed
+// rate. The caller writes this ahead of to, which continues unchanged from
+// the ramp's endpoint down to its own original target - now a pure-Z
+// plunge over whatever depth remains.
+//
+// ok is false when no ramp is needed: there's no XY travel to ramp across
+// (fromX,fromY coincide with toX,toY, an actual plunge), the move doesn't
+// descend at all, or it's already shallower than rampAngleDeg.
+func RampMove(fromX, fromY, fromZ float64, to gcode.Line, toX, toY, toZ, rampAngleDeg float64, precision int) (ramp gcode.Line, ok bool) {
+	xy := math.Hypot(toX-fromX, toY-fromY)
+	descent := fromZ - toZ
+	if xy < zeroLengthEpsilon || descent <= 0 {
+		return gcode.Line{}, false
+	}
+
+	maxDescent := xy * math.Tan(rampAngleDeg*math.Pi/180)
+	if descent <= maxDescent {
+		return gcode.Line{}, false
+	}
+
+	rampZ := fromZ - maxDescent
+
+	ramp = gcode.Line{Code: "G1", Synthesized: true}
+	ramp.Params = []gcode.Param{
+		{Letter: 'X', Value: round(toX, precision), Raw: formatCoord(toX, precision)},
+		{Letter: 'Y', Value: round(toY, precision), Raw: formatCoord(toY, precision)},
+		{Letter: 'Z', Value: round(rampZ, precision), Raw: formatCoord(rampZ, precision)},
+	}
+	if feedVal, hasFeed := to.Get('F'); hasFeed {
+		feedRaw, _ := to.GetRaw('F')
+		ramp.Params = append(ramp.Params, gcode.Param{Letter: 'F', Value: feedVal, Raw: feedRaw})
+	}
+	return ramp, true
+}
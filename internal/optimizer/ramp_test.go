@@ -0,0 +1,56 @@
+package optimizer
+
+import (
+	"math"
+	"testing"
+
+	"github.com/chrisns/snapmaker-cnc-finisher/internal/gcode"
+)
+
+func TestRampMove_InsertsARampWhenTheDescentExceedsTheAngle(t *testing.T) {
+	to := gcode.Parse("G1 X10 Y0 Z-10 F300", 1)
+
+	ramp, ok := RampMove(0, 0, 0, to, 10, 0, -10, 3, 4)
+	if !ok {
+		t.Fatal("RampMove: ok = false, want true for a 45-degree descent against a 3-degree limit")
+	}
+
+	x, _ := ramp.Get('X')
+	y, _ := ramp.Get('Y')
+	z, _ := ramp.Get('Z')
+	f, hasF := ramp.Get('F')
+	if x != 10 || y != 0 {
+		t.Fatalf("ramp X/Y = %v/%v, want the full travel 10/0", x, y)
+	}
+	wantZ := -10 * math.Tan(3*math.Pi/180)
+	if math.Abs(z-wantZ) > 1e-4 {
+		t.Fatalf("ramp Z = %v, want %v (10 * tan(3 degrees))", z, wantZ)
+	}
+	if !hasF || f != 300 {
+		t.Fatalf("ramp F = %v (hasF=%v), want 300 carried over from the target move", f, hasF)
+	}
+}
+
+func TestRampMove_NoRampNeededWhenAlreadyShallowerThanTheAngle(t *testing.T) {
+	to := gcode.Parse("G1 X100 Y0 Z-1 F300", 1)
+
+	if _, ok := RampMove(0, 0, 0, to, 100, 0, -1, 3, 4); ok {
+		t.Fatal("RampMove: ok = true, want false for a shallow descent already within the angle")
+	}
+}
+
+func TestRampMove_NoRampForAPureVerticalPlunge(t *testing.T) {
+	to := gcode.Parse("G1 X0 Y0 Z-10 F300", 1)
+
+	if _, ok := RampMove(0, 0, 0, to, 0, 0, -10, 3, 4); ok {
+		t.Fatal("RampMove: ok = true, want false when there's no XY travel to ramp across")
+	}
+}
+
+func TestRampMove_NoRampWhenNotDescending(t *testing.T) {
+	to := gcode.Parse("G1 X10 Y0 Z0 F300", 1)
+
+	if _, ok := RampMove(0, 0, -10, to, 10, 0, 0, 3, 4); ok {
+		t.Fatal("RampMove: ok = true, want false for a move that retracts rather than descends")
+	}
+}
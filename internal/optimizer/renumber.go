@@ -0,0 +1,32 @@
+package optimizer
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RenumberLine rewrites line's leading N-word to n, inserting one if line
+// doesn't already start with one. N-words are a positional prefix Luban
+// places before the command word, not a modal parameter, so this works on
+// the line's rendered text directly rather than through gcode.Line.Params.
+func RenumberLine(line string, n int) string {
+	return fmt.Sprintf("N%d %s", n, stripLeadingNWord(line))
+}
+
+// stripLeadingNWord removes a leading "N<digits>" word and the whitespace
+// following it, if line starts with one; otherwise it returns line
+// unchanged.
+func stripLeadingNWord(line string) string {
+	trimmed := strings.TrimLeft(line, " ")
+	if len(trimmed) < 2 || (trimmed[0] != 'N' && trimmed[0] != 'n') {
+		return line
+	}
+	i := 1
+	for i < len(trimmed) && trimmed[i] >= '0' && trimmed[i] <= '9' {
+		i++
+	}
+	if i == 1 {
+		return line
+	}
+	return strings.TrimLeft(trimmed[i:], " ")
+}
@@ -0,0 +1,19 @@
+package optimizer
+
+import "testing"
+
+func TestRenumberLine_InsertsNWhenAbsent(t *testing.T) {
+	got := RenumberLine("G1 X1 Z-1 F300", 20)
+	want := "N20 G1 X1 Z-1 F300"
+	if got != want {
+		t.Fatalf("RenumberLine() = %q, want %q", got, want)
+	}
+}
+
+func TestRenumberLine_ReplacesExistingN(t *testing.T) {
+	got := RenumberLine("N5 G1 X1 Z-1 F300", 20)
+	want := "N20 G1 X1 Z-1 F300"
+	if got != want {
+		t.Fatalf("RenumberLine() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,19 @@
+package optimizer
+
+import (
+	"regexp"
+
+	"github.com/chrisns/snapmaker-cnc-finisher/internal/gcode"
+)
+
+var layerCommentRE = regexp.MustCompile(`(?i);\s*(layer\s+\d+|tool\s*change.*)`)
+
+// sectionName returns the section label a comment line starts, and whether
+// it recognized one (a "; Layer N" or tool-change checkpoint comment).
+func sectionName(line gcode.Line) (string, bool) {
+	m := layerCommentRE.FindStringSubmatch(line.Comment)
+	if m == nil {
+		return "", false
+	}
+	return m[1], true
+}
@@ -0,0 +1,210 @@
+package optimizer
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/chrisns/snapmaker-cnc-finisher/internal/gcode"
+)
+
+// Crossing describes how a linear move's endpoints sit relative to the
+// depth threshold.
+type Crossing int
+
+const (
+	// NoCrossing means both endpoints are on the same side of the threshold.
+	NoCrossing Crossing = iota
+	// CrossingEnter is a move that starts shallow and ends deep (a plunge
+	// into material).
+	CrossingEnter
+	// CrossingLeave is a move that starts deep and ends shallow (a retract
+	// out of material).
+	CrossingLeave
+)
+
+// ClassifyCrossing compares whether a move's start and end are shallow
+// (above the threshold) and reports which, if either, boundary it crosses.
+func ClassifyCrossing(startShallow, endShallow bool) Crossing {
+	switch {
+	case startShallow && !endShallow:
+		return CrossingEnter
+	case !startShallow && endShallow:
+		return CrossingLeave
+	default:
+		return NoCrossing
+	}
+}
+
+// CalculateIntersection returns the point along the straight line from
+// (x1,y1,z1) to (x2,y2,z2) where Z equals threshold. If the move has no Z
+// travel, it returns the start point's X/Y with Z set to threshold.
+func CalculateIntersection(x1, y1, z1, x2, y2, z2, threshold float64) (x, y, z float64) {
+	if z2 == z1 {
+		return x1, y1, threshold
+	}
+	t := (threshold - z1) / (z2 - z1)
+	return x1 + (x2-x1)*t, y1 + (y2-y1)*t, threshold
+}
+
+// DefaultPrecision is the number of decimal places SplitMove rounds
+// generated coordinates to when the caller doesn't request otherwise,
+// matching Luban's own default output precision.
+const DefaultPrecision = 4
+
+func round(v float64, precision int) float64 {
+	p := math.Pow10(precision)
+	return math.Round(v*p) / p
+}
+
+func formatCoord(v float64, precision int) string {
+	return strconv.FormatFloat(round(v, precision), 'f', precision, 64)
+}
+
+// SplitMove splits a linear move starting at (prevX,prevY,prevZ) and
+// described by line into the segment before the depth threshold and the
+// segment after it, in travel order: line1 runs from the start to the
+// threshold crossing, line2 runs from the crossing to the original
+// endpoint. Both are Synthesized gcode.Lines carrying the original motion
+// code and feed rate; the caller keeps whichever segment is on the deep
+// side and discards the other.
+func SplitMove(line gcode.Line, prevX, prevY, prevZ, threshold float64, precision int) (line1, line2 gcode.Line) {
+	x, okX := line.Get('X')
+	y, okY := line.Get('Y')
+	z, okZ := line.Get('Z')
+	if !okX {
+		x = prevX
+	}
+	if !okY {
+		y = prevY
+	}
+	if !okZ {
+		z = prevZ
+	}
+
+	ix, iy, iz := CalculateIntersection(prevX, prevY, prevZ, x, y, z, threshold)
+
+	feedRaw, hasFeed := line.GetRaw('F')
+
+	build := func(px, py, pz float64) gcode.Line {
+		l := gcode.Line{Code: line.Code, Synthesized: true, Comment: line.Comment}
+		l.Params = []gcode.Param{
+			{Letter: 'X', Value: round(px, precision), Raw: formatCoord(px, precision)},
+			{Letter: 'Y', Value: round(py, precision), Raw: formatCoord(py, precision)},
+			{Letter: 'Z', Value: round(pz, precision), Raw: formatCoord(pz, precision)},
+		}
+		if hasFeed {
+			feedVal, _ := line.Get('F')
+			l.Params = append(l.Params, gcode.Param{Letter: 'F', Value: feedVal, Raw: feedRaw})
+		}
+		return l
+	}
+
+	line1 = build(ix, iy, iz)
+	line2 = build(x, y, z)
+	return line1, line2
+}
+
+// ArcCenter returns the absolute center of a G2/G3 arc starting at
+// (startX, startY), given the arc's I/J parameters - an offset from the
+// start point to the center, the only center format this codebase parses
+// (R-format arcs aren't supported). ok is false when line has neither I
+// nor J.
+func ArcCenter(line gcode.Line, startX, startY float64) (cx, cy float64, ok bool) {
+	i, hasI := line.Get('I')
+	j, hasJ := line.Get('J')
+	if !hasI && !hasJ {
+		return 0, 0, false
+	}
+	return startX + i, startY + j, true
+}
+
+// CalculateArcIntersection returns the point along the G2 (clockwise) or
+// G3 (counterclockwise) arc from (x1,y1,z1) to (x2,y2,z2), centered at
+// (cx,cy), where Z equals threshold. A helical arc's Z interpolates
+// linearly with swept angle (not with chord distance), so the fraction of
+// Z travel to threshold is also the fraction of angle swept.
+func CalculateArcIntersection(x1, y1, z1, x2, y2, z2, cx, cy float64, clockwise bool, threshold float64) (x, y, z float64) {
+	if z2 == z1 {
+		return x1, y1, threshold
+	}
+	t := (threshold - z1) / (z2 - z1)
+
+	r := math.Hypot(x1-cx, y1-cy)
+	a1 := math.Atan2(y1-cy, x1-cx)
+	a2 := math.Atan2(y2-cy, x2-cx)
+
+	var sweep float64
+	if clockwise {
+		sweep = a1 - a2
+	} else {
+		sweep = a2 - a1
+	}
+	for sweep < 0 {
+		sweep += 2 * math.Pi
+	}
+	// Start and end coinciding is a full circle, not a zero-length arc;
+	// there's no swept-angle signal to measure from, so assume one full
+	// turn in the move's direction.
+	if sweep == 0 {
+		sweep = 2 * math.Pi
+	}
+
+	at := a1 + sweep*t
+	if clockwise {
+		at = a1 - sweep*t
+	}
+	return cx + r*math.Cos(at), cy + r*math.Sin(at), threshold
+}
+
+// SplitArc is SplitMove's arc analogue: it splits a helical G2/G3 arc
+// starting at (prevX,prevY,prevZ) and described by line into the segment
+// before the depth threshold and the segment after it, line1 running from
+// the start to the threshold crossing and line2 from the crossing to the
+// original endpoint. Both segments share the original arc's center and
+// radius - line2's I/J are recomputed relative to its new start point, the
+// crossing, rather than copied from line. ok is false when line has no I/J
+// center offset to split around, in which case the caller should fall back
+// to treating the move as a straight line via SplitMove.
+func SplitArc(line gcode.Line, prevX, prevY, prevZ, threshold float64, precision int) (line1, line2 gcode.Line, ok bool) {
+	cx, cy, hasCenter := ArcCenter(line, prevX, prevY)
+	if !hasCenter {
+		return gcode.Line{}, gcode.Line{}, false
+	}
+
+	x, okX := line.Get('X')
+	y, okY := line.Get('Y')
+	z, okZ := line.Get('Z')
+	if !okX {
+		x = prevX
+	}
+	if !okY {
+		y = prevY
+	}
+	if !okZ {
+		z = prevZ
+	}
+
+	ix, iy, iz := CalculateArcIntersection(prevX, prevY, prevZ, x, y, z, cx, cy, line.Code == "G2", threshold)
+
+	feedRaw, hasFeed := line.GetRaw('F')
+
+	build := func(px, py, pz, i, j float64) gcode.Line {
+		l := gcode.Line{Code: line.Code, Synthesized: true, Comment: line.Comment}
+		l.Params = []gcode.Param{
+			{Letter: 'X', Value: round(px, precision), Raw: formatCoord(px, precision)},
+			{Letter: 'Y', Value: round(py, precision), Raw: formatCoord(py, precision)},
+			{Letter: 'Z', Value: round(pz, precision), Raw: formatCoord(pz, precision)},
+			{Letter: 'I', Value: round(i, precision), Raw: formatCoord(i, precision)},
+			{Letter: 'J', Value: round(j, precision), Raw: formatCoord(j, precision)},
+		}
+		if hasFeed {
+			feedVal, _ := line.Get('F')
+			l.Params = append(l.Params, gcode.Param{Letter: 'F', Value: feedVal, Raw: feedRaw})
+		}
+		return l
+	}
+
+	line1 = build(ix, iy, iz, cx-prevX, cy-prevY)
+	line2 = build(x, y, z, cx-ix, cy-iy)
+	return line1, line2, true
+}
@@ -0,0 +1,89 @@
+package optimizer
+
+import (
+	"math"
+	"testing"
+
+	"github.com/chrisns/snapmaker-cnc-finisher/internal/gcode"
+)
+
+func TestSplitMove_ConfigurablePrecision(t *testing.T) {
+	move := gcode.Parse("G1 X3 Y0 Z-1 F100", 1)
+
+	// Threshold at -1/3 of the way down: intersection X = 1, exact.
+	// Use a fraction that doesn't round evenly to exercise precision.
+	line1, _ := SplitMove(move, 0, 0, 0, -1.0/3.0, 2)
+	x, _ := line1.Get('X')
+	if x != 1.0 {
+		t.Fatalf("X at precision 2 = %v, want 1", x)
+	}
+
+	line1, _ = SplitMove(move, 0, 0, 0, -0.1, 1)
+	xRaw, _ := line1.GetRaw('X')
+	if xRaw != "0.3" {
+		t.Fatalf("X raw at precision 1 = %q, want %q", xRaw, "0.3")
+	}
+
+	line1, _ = SplitMove(move, 0, 0, 0, -0.1, 4)
+	xRaw, _ = line1.GetRaw('X')
+	if xRaw != "0.3000" {
+		t.Fatalf("X raw at precision 4 = %q, want %q", xRaw, "0.3000")
+	}
+}
+
+func TestSplitArc_SegmentsShareOriginalCenterAndRadius(t *testing.T) {
+	// A quarter circle, CCW (G3), from (10,0) to (0,10) centered on the
+	// origin, radius 10, helically descending from Z0 to Z-10. Threshold
+	// -5 sits exactly halfway through both the Z travel and the swept
+	// angle, at 45 degrees: (10/sqrt(2), 10/sqrt(2)).
+	arc := gcode.Parse("G3 X0 Y10 Z-10 I-10 J0 F300", 1)
+
+	line1, line2, ok := SplitArc(arc, 10, 0, 0, -5, 4)
+	if !ok {
+		t.Fatal("SplitArc: ok = false, want true (I/J present)")
+	}
+
+	wantX, wantY := 10/math.Sqrt2, 10/math.Sqrt2
+	x1, _ := line1.Get('X')
+	y1, _ := line1.Get('Y')
+	z1, _ := line1.Get('Z')
+	if math.Abs(x1-wantX) > 1e-3 || math.Abs(y1-wantY) > 1e-3 || z1 != -5 {
+		t.Fatalf("line1 endpoint = (%v,%v,%v), want (%v,%v,-5)", x1, y1, z1, wantX, wantY)
+	}
+
+	x2, _ := line2.Get('X')
+	y2, _ := line2.Get('Y')
+	z2, _ := line2.Get('Z')
+	if x2 != 0 || y2 != 10 || z2 != -10 {
+		t.Fatalf("line2 endpoint = (%v,%v,%v), want (0,10,-10) (the original endpoint)", x2, y2, z2)
+	}
+
+	const wantRadius = 10
+
+	i1, _ := line1.Get('I')
+	j1, _ := line1.Get('J')
+	cx1, cy1 := 10+i1, 0+j1 // line1 starts at the original (10,0)
+	if math.Abs(cx1) > 1e-3 || math.Abs(cy1) > 1e-3 {
+		t.Fatalf("line1 center = (%v,%v), want (0,0)", cx1, cy1)
+	}
+	if r := math.Hypot(i1, j1); math.Abs(r-wantRadius) > 1e-3 {
+		t.Fatalf("line1 radius = %v, want %v", r, wantRadius)
+	}
+
+	i2, _ := line2.Get('I')
+	j2, _ := line2.Get('J')
+	cx2, cy2 := x1+i2, y1+j2 // line2 starts at the intersection
+	if math.Abs(cx2) > 1e-3 || math.Abs(cy2) > 1e-3 {
+		t.Fatalf("line2 center = (%v,%v), want (0,0)", cx2, cy2)
+	}
+	if r := math.Hypot(i2, j2); math.Abs(r-wantRadius) > 1e-3 {
+		t.Fatalf("line2 radius = %v, want %v", r, wantRadius)
+	}
+}
+
+func TestSplitArc_NoCenterFallsBackToFalse(t *testing.T) {
+	arc := gcode.Parse("G3 X0 Y10 Z-10 F300", 1)
+	if _, _, ok := SplitArc(arc, 10, 0, 0, -5, 4); ok {
+		t.Fatal("SplitArc: ok = true for an arc with no I/J center offset, want false")
+	}
+}
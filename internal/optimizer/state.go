@@ -0,0 +1,202 @@
+package optimizer
+
+import (
+	"strconv"
+
+	"github.com/chrisns/snapmaker-cnc-finisher/internal/gcode"
+)
+
+// FeedMode is the active feed rate interpretation, selected by G93/G94/G95.
+type FeedMode int
+
+const (
+	// FeedPerMinute is the default mode (G94): F is in mm/min (or in/min).
+	FeedPerMinute FeedMode = iota
+	// FeedInverseTime is G93: F is the reciprocal of the time, in minutes,
+	// the move should take. It is not a speed and cannot be compared
+	// directly against a mm/min machine limit.
+	FeedInverseTime
+	// FeedPerRevolution is G95: F is in mm (or in) per spindle revolution,
+	// and real-world speed depends on the spindle RPM.
+	FeedPerRevolution
+)
+
+// String implements fmt.Stringer for diagnostic output.
+func (m FeedMode) String() string {
+	switch m {
+	case FeedInverseTime:
+		return "G93 (inverse time)"
+	case FeedPerRevolution:
+		return "G95 (units per revolution)"
+	default:
+		return "G94 (units per minute)"
+	}
+}
+
+// DistanceMode is the active interpretation of X/Y/Z/B words, selected by
+// G90/G91.
+type DistanceMode int
+
+const (
+	// DistanceAbsolute (G90, the default) interprets axis words as
+	// positions.
+	DistanceAbsolute DistanceMode = iota
+	// DistanceIncremental (G91) interprets axis words as offsets from the
+	// current position.
+	DistanceIncremental
+)
+
+// Units is the active measurement unit for axis words and feed rates,
+// selected by G20/G21.
+type Units int
+
+const (
+	// UnitsMillimeters (G21, the default) is Luban's native unit.
+	UnitsMillimeters Units = iota
+	// UnitsInches is G20.
+	UnitsInches
+)
+
+// Plane is the active arc plane for G2/G3, selected by G17/G18/G19.
+type Plane int
+
+const (
+	// PlaneXY (G17, the default) is the plane every 3-axis Luban job cuts in.
+	PlaneXY Plane = iota
+	// PlaneXZ is G18.
+	PlaneXZ
+	// PlaneYZ is G19.
+	PlaneYZ
+)
+
+// State is the modal machine state accumulated while scanning a G-code
+// program line by line. Only the subset of modal groups the optimizer
+// needs to track is represented.
+type State struct {
+	X, Y, Z          float64
+	HasX, HasY, HasZ bool
+
+	// B is the rotary B-axis position, tracked for 4-axis jobs. Most
+	// 3-axis programs never set it, so HasB stays false.
+	B    float64
+	HasB bool
+
+	Motion      string // last seen motion command: "G0", "G1", "G2", "G3"
+	FeedMode    FeedMode
+	FeedRate    float64
+	HasFeedRate bool
+
+	// DistanceMode, Units, Plane, and WorkOffset (54-59) are updated from
+	// their respective G-codes; of them, only Units is read elsewhere (by
+	// InitialUnits). They're tracked so a bare modal line like "G91" is
+	// recognized as structural state rather than an unrecognized no-op.
+	DistanceMode DistanceMode
+	Units        Units
+	Plane        Plane
+	WorkOffset   int
+
+	// DepthAlias, if set, is an additional axis letter (see
+	// Config.ZAlias) that UpdateState also routes into Z, for controllers
+	// that use a secondary quill axis (e.g. W) as the real depth word.
+	DepthAlias byte
+
+	// Tool is the active tool number, last selected by a "T<N>" word.
+	// HasTool is false until the first tool change, for a file that never
+	// selects a tool explicitly.
+	Tool    int
+	HasTool bool
+}
+
+// NewState returns a State initialized to the machine's power-on defaults.
+func NewState() *State {
+	return &State{FeedMode: FeedPerMinute, WorkOffset: 54}
+}
+
+// UpdateState applies a parsed Line to the modal state, returning any
+// warnings produced as a side effect (e.g. an unsupported feed mode).
+func UpdateState(s *State, line gcode.Line) []string {
+	var warnings []string
+
+	switch line.Code {
+	case "G0", "G1", "G2", "G3":
+		s.Motion = line.Code
+	case "G93":
+		if s.FeedMode != FeedInverseTime {
+			warnings = append(warnings, "G93 (inverse-time feed) is active; time estimation for moves in this mode is skipped")
+		}
+		s.FeedMode = FeedInverseTime
+	case "G94":
+		s.FeedMode = FeedPerMinute
+	case "G95":
+		if s.FeedMode != FeedPerRevolution {
+			warnings = append(warnings, "G95 (units-per-revolution feed) is active; time estimation for moves in this mode is skipped")
+		}
+		s.FeedMode = FeedPerRevolution
+	case "G90":
+		s.DistanceMode = DistanceAbsolute
+	case "G91":
+		s.DistanceMode = DistanceIncremental
+	case "G20":
+		s.Units = UnitsInches
+	case "G21":
+		s.Units = UnitsMillimeters
+	case "G17":
+		s.Plane = PlaneXY
+	case "G18":
+		s.Plane = PlaneXZ
+	case "G19":
+		s.Plane = PlaneYZ
+	case "G54":
+		s.WorkOffset = 54
+	case "G55":
+		s.WorkOffset = 55
+	case "G56":
+		s.WorkOffset = 56
+	case "G57":
+		s.WorkOffset = 57
+	case "G58":
+		s.WorkOffset = 58
+	case "G59":
+		s.WorkOffset = 59
+	default:
+		if n, ok := parseToolWord(line.Code); ok {
+			s.Tool, s.HasTool = n, true
+		}
+	}
+
+	if x, ok := line.Get('X'); ok {
+		s.X, s.HasX = x, true
+	}
+	if y, ok := line.Get('Y'); ok {
+		s.Y, s.HasY = y, true
+	}
+	if z, ok := line.Get('Z'); ok {
+		s.Z, s.HasZ = z, true
+	}
+	if s.DepthAlias != 0 {
+		if z, ok := line.Get(s.DepthAlias); ok {
+			s.Z, s.HasZ = z, true
+		}
+	}
+	if b, ok := line.Get('B'); ok {
+		s.B, s.HasB = b, true
+	}
+	if f, ok := line.Get('F'); ok {
+		s.FeedRate, s.HasFeedRate = f, true
+	}
+
+	return warnings
+}
+
+// parseToolWord reports the tool number selected by code if it's a T-word
+// (e.g. "T1", "T12"), and ok=false otherwise.
+func parseToolWord(code string) (n int, ok bool) {
+	if len(code) < 2 || code[0] != 'T' {
+		return 0, false
+	}
+	v, err := strconv.Atoi(code[1:])
+	if err != nil {
+		return 0, false
+	}
+	return v, true
+}
@@ -0,0 +1,62 @@
+package optimizer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/chrisns/snapmaker-cnc-finisher/internal/gcode"
+)
+
+func TestUpdateState_StructuralGCodesUpdateModalFlags(t *testing.T) {
+	cases := []struct {
+		code  string
+		check func(s *State) bool
+	}{
+		{"G90", func(s *State) bool { return s.DistanceMode == DistanceAbsolute }},
+		{"G91", func(s *State) bool { return s.DistanceMode == DistanceIncremental }},
+		{"G20", func(s *State) bool { return s.Units == UnitsInches }},
+		{"G21", func(s *State) bool { return s.Units == UnitsMillimeters }},
+		{"G17", func(s *State) bool { return s.Plane == PlaneXY }},
+		{"G18", func(s *State) bool { return s.Plane == PlaneXZ }},
+		{"G19", func(s *State) bool { return s.Plane == PlaneYZ }},
+		{"G54", func(s *State) bool { return s.WorkOffset == 54 }},
+		{"G59", func(s *State) bool { return s.WorkOffset == 59 }},
+	}
+
+	for _, c := range cases {
+		t.Run(c.code, func(t *testing.T) {
+			s := NewState()
+			// Start from a state the code's target isn't already in, so the
+			// assertion can't pass on the zero value alone.
+			s.DistanceMode = DistanceIncremental
+			s.Units = UnitsInches
+			s.Plane = PlaneYZ
+			s.WorkOffset = 55
+
+			line := gcode.Parse(c.code, 1)
+			UpdateState(s, line)
+			if !c.check(s) {
+				t.Fatalf("%s did not update the expected modal flag: %+v", c.code, s)
+			}
+		})
+	}
+}
+
+func TestUpdateState_BareStructuralGCodesSurviveFiltering(t *testing.T) {
+	input := "G17\nG18\nG19\nG20\nG21\nG90\nG91\nG54\nG55\nG56\nG57\nG58\nG59\nG4 P1\n"
+
+	var out strings.Builder
+	stats, err := Optimize(strings.NewReader(input), &out, Config{})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+	if stats.LinesRemoved != 0 {
+		t.Fatalf("LinesRemoved = %d, want 0; bare structural G-codes must always survive", stats.LinesRemoved)
+	}
+	for _, code := range strings.Fields(strings.ReplaceAll(input, "\n", " ")) {
+		code = strings.Fields(code)[0]
+		if !strings.Contains(out.String(), code) {
+			t.Fatalf("output missing %q: %q", code, out.String())
+		}
+	}
+}
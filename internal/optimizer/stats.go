@@ -0,0 +1,237 @@
+package optimizer
+
+// Statistics summarizes the result of optimizing a single G-code program.
+type Statistics struct {
+	LinesIn      int
+	LinesOut     int
+	LinesRemoved int
+
+	// ZeroLengthRemoved counts moves dropped because they didn't change
+	// position (a subset of LinesRemoved), see Config.KeepZeroLength.
+	ZeroLengthRemoved int
+
+	// FeedRateClamped counts emitted F words that were capped to
+	// Config.MaxFeed.
+	FeedRateClamped int
+
+	// RedundantFeedStripped counts emitted F words dropped by
+	// Config.StripRedundantFeed because they repeated the current modal
+	// feed rate.
+	RedundantFeedStripped int
+
+	// InvalidFeedRates counts motion lines seen with a zero or negative F
+	// word, whether or not Config.FixFeed was set to correct them.
+	InvalidFeedRates int
+
+	// UnsafeRapids counts G0 moves that end below the reference Z while
+	// also moving in X/Y - a potential crash in the source file that the
+	// optimizer deliberately doesn't try to fix or mask (G0 moves are
+	// always preserved), only flag.
+	UnsafeRapids int
+
+	// OutOfBoundsMoves counts emitted moves whose X or Y endpoint falls
+	// outside the header's declared work area, see Config.CheckBounds.
+	OutOfBoundsMoves int
+
+	// AddedLines counts output lines with no corresponding input line at
+	// all, such as a -stamp comment or the header/spindle-on/retract lines
+	// generated at a -split-size part boundary. LinesRemoved alone
+	// undercounts the real diff for modes that add or rewrite lines
+	// instead of only dropping them.
+	AddedLines int
+	// ModifiedLines counts input lines that were kept but rewritten
+	// (Synthesized) rather than passed through verbatim, such as a
+	// -max-feed clamp or a -strategy=split move shortened to its deep
+	// portion.
+	ModifiedLines int
+
+	// KeptByStrategy counts rotary moves that a non-all-axes strategy spared
+	// because of Rotary protection (DeltaB above RotaryBThreshold), but
+	// StrategyAllAxes would have removed on depth alone. It's the borderline
+	// set: moves kept only because of the configured strategy, not because
+	// they were genuinely judged safe.
+	KeptByStrategy int
+
+	BytesIn int64
+	// BytesOut is the total size of the output, summed across every part
+	// for a split run. It comes from the writer's own running byte count
+	// rather than a file.Stat() on the result, so it's accurate for stdout
+	// and other non-file writers too.
+	BytesOut int64
+
+	// RemovedXYDistance is the total XY (not Z) travel distance of every
+	// removed cutting move, always tracked regardless of Config.ToolDiameter.
+	RemovedXYDistance float64
+	// RemovedCoverageArea is RemovedXYDistance times Config.ToolDiameter, a
+	// rough estimate of the area of wasted "air cutting" eliminated. Zero
+	// unless ToolDiameter was set.
+	RemovedCoverageArea float64
+
+	// LargestRemovedSpanMoves, LargestRemovedSpanDistance, and
+	// LargestRemovedSpanSeconds describe the single longest contiguous
+	// run of removed cutting moves seen - a run broken by any kept line
+	// in between, not just a G0 rapid - as a way to validate how much of
+	// the optimization came from one big air-cutting span versus many
+	// small ones. All three are zero if no move was ever removed.
+	LargestRemovedSpanMoves    int
+	LargestRemovedSpanDistance float64
+	LargestRemovedSpanSeconds  float64
+
+	TimeSavedSeconds float64
+
+	// OriginalEstimatedSeconds and OptimizedEstimatedSeconds are the
+	// estimated machining time of every cutting move seen (kept or
+	// removed) and of just the ones kept, respectively. Both are derived
+	// the same way as TimeSavedSeconds - by feed rate and distance, or
+	// CalibratedFeedRate when set - and OriginalEstimatedSeconds always
+	// equals OptimizedEstimatedSeconds+TimeSavedSeconds, since every
+	// cutting move is either kept or removed.
+	OriginalEstimatedSeconds  float64
+	OptimizedEstimatedSeconds float64
+
+	// RemovedFeedMin, RemovedFeedMax, RemovedFeedSum, and RemovedFeedCount
+	// track the feed rate (explicit F word, or the modal rate carried over
+	// from an earlier one - see state.FeedRate) in effect for every removed
+	// cutting move, for reporting what range of feed rates the removed
+	// material was actually going to be cut at. A move removed before any F
+	// word has been seen anywhere in the file doesn't contribute, since
+	// there's no modal rate yet to attribute to it. All four are zero if no
+	// removed move ever had a known feed rate.
+	RemovedFeedMin   float64
+	RemovedFeedMax   float64
+	RemovedFeedSum   float64
+	RemovedFeedCount int
+
+	Warnings []string
+
+	// CodedWarnings is Warnings again, paired with a machine-matchable
+	// WarningCode, for a caller embedding Optimize that wants to act on a
+	// specific condition instead of pattern-matching message text. It's
+	// always in sync with Warnings: every message added through
+	// AddWarningCode appears in both, in the same order, minus exact
+	// duplicates. A warning added through the plain AddWarning (used for
+	// the handful of conditions with no dedicated code yet) appears only
+	// in Warnings, with WarningUnknown implied.
+	CodedWarnings []Warning
+
+	// Sections holds a per-layer/tool breakdown when Config.ByLayer is set.
+	// It is nil otherwise.
+	Sections []SectionStats
+}
+
+// SectionStats is the line breakdown for one layer/tool section, as
+// delimited by a recognized "; Layer N" or tool-change comment.
+type SectionStats struct {
+	Name         string
+	LinesKept    int
+	LinesRemoved int
+}
+
+// AddWarning appends a warning to the statistics, ignoring exact duplicates
+// so a condition that repeats across many lines doesn't flood the report.
+func (s *Statistics) AddWarning(msg string) {
+	for _, w := range s.Warnings {
+		if w == msg {
+			return
+		}
+	}
+	s.Warnings = append(s.Warnings, msg)
+}
+
+// AddWarningCode is AddWarning plus a WarningCode, appending to
+// CodedWarnings as well as Warnings when msg is genuinely new. A repeat
+// message is suppressed from both, the same as a plain AddWarning call.
+func (s *Statistics) AddWarningCode(code WarningCode, msg string) {
+	before := len(s.Warnings)
+	s.AddWarning(msg)
+	if len(s.Warnings) == before {
+		return
+	}
+	s.CodedWarnings = append(s.CodedWarnings, Warning{Code: code, Message: msg})
+}
+
+// Merge folds other into s, for combining per-file Statistics into a grand
+// total in batch mode. Counts and durations add; Sections and Warnings are
+// concatenated (with AddWarning's deduplication). Anything derived, like a
+// reduction percentage, must be recomputed from the merged totals rather
+// than averaged - ReductionPercent does this correctly because it only
+// ever reads the summed fields.
+func (s *Statistics) Merge(other *Statistics) {
+	s.LinesIn += other.LinesIn
+	s.LinesOut += other.LinesOut
+	s.LinesRemoved += other.LinesRemoved
+	s.ZeroLengthRemoved += other.ZeroLengthRemoved
+	s.FeedRateClamped += other.FeedRateClamped
+	s.RedundantFeedStripped += other.RedundantFeedStripped
+	s.InvalidFeedRates += other.InvalidFeedRates
+	s.UnsafeRapids += other.UnsafeRapids
+	s.OutOfBoundsMoves += other.OutOfBoundsMoves
+	s.AddedLines += other.AddedLines
+	s.ModifiedLines += other.ModifiedLines
+	s.KeptByStrategy += other.KeptByStrategy
+	s.BytesIn += other.BytesIn
+	s.BytesOut += other.BytesOut
+	s.RemovedXYDistance += other.RemovedXYDistance
+	s.RemovedCoverageArea += other.RemovedCoverageArea
+	if other.LargestRemovedSpanMoves > s.LargestRemovedSpanMoves {
+		s.LargestRemovedSpanMoves = other.LargestRemovedSpanMoves
+		s.LargestRemovedSpanDistance = other.LargestRemovedSpanDistance
+		s.LargestRemovedSpanSeconds = other.LargestRemovedSpanSeconds
+	}
+	s.TimeSavedSeconds += other.TimeSavedSeconds
+	s.OriginalEstimatedSeconds += other.OriginalEstimatedSeconds
+	s.OptimizedEstimatedSeconds += other.OptimizedEstimatedSeconds
+	if other.RemovedFeedCount > 0 {
+		if s.RemovedFeedCount == 0 || other.RemovedFeedMin < s.RemovedFeedMin {
+			s.RemovedFeedMin = other.RemovedFeedMin
+		}
+		if other.RemovedFeedMax > s.RemovedFeedMax {
+			s.RemovedFeedMax = other.RemovedFeedMax
+		}
+		s.RemovedFeedSum += other.RemovedFeedSum
+		s.RemovedFeedCount += other.RemovedFeedCount
+	}
+	s.Sections = append(s.Sections, other.Sections...)
+	coded := make(map[string]bool, len(other.CodedWarnings))
+	for _, w := range other.CodedWarnings {
+		coded[w.Message] = true
+		s.AddWarningCode(w.Code, w.Message)
+	}
+	for _, w := range other.Warnings {
+		if coded[w] {
+			continue
+		}
+		s.AddWarning(w)
+	}
+}
+
+// TimeSavedPercent reports what fraction of the original estimated
+// machining time TimeSavedSeconds represents, as a percentage. It's always
+// derived from the merged totals directly, the same way ReductionPercent
+// is, so it stays correct after Merge.
+func (s *Statistics) TimeSavedPercent() float64 {
+	if s.OriginalEstimatedSeconds == 0 {
+		return 0
+	}
+	return s.TimeSavedSeconds / s.OriginalEstimatedSeconds * 100
+}
+
+// ReductionPercent reports what fraction of input lines were removed, as a
+// percentage. It's always derived from LinesIn/LinesRemoved directly, so
+// merging several Statistics and then calling ReductionPercent gives the
+// true combined percentage rather than an average of per-file percentages.
+func (s *Statistics) ReductionPercent() float64 {
+	if s.LinesIn == 0 {
+		return 0
+	}
+	return float64(s.LinesRemoved) / float64(s.LinesIn) * 100
+}
+
+// AverageRemovedFeed reports the mean feed rate across every removed
+// cutting move that had a known feed rate, or 0 if RemovedFeedCount is 0.
+func (s *Statistics) AverageRemovedFeed() float64 {
+	if s.RemovedFeedCount == 0 {
+		return 0
+	}
+	return s.RemovedFeedSum / float64(s.RemovedFeedCount)
+}
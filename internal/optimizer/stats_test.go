@@ -0,0 +1,73 @@
+package optimizer
+
+import "testing"
+
+func TestStatistics_MergeComputesReductionFromTotalsNotAverage(t *testing.T) {
+	a := Statistics{LinesIn: 100, LinesOut: 90, LinesRemoved: 10}
+	b := Statistics{LinesIn: 10, LinesOut: 0, LinesRemoved: 10}
+
+	// Averaging each file's percent (10% and 100%) would give 55%; merging
+	// the totals first (20 removed of 110 in) gives the true ~18.18%.
+	a.Merge(&b)
+
+	if a.LinesIn != 110 || a.LinesOut != 90 || a.LinesRemoved != 20 {
+		t.Fatalf("merged = %+v, want LinesIn=110 LinesOut=90 LinesRemoved=20", a)
+	}
+
+	got := a.ReductionPercent()
+	want := 20.0 / 110.0 * 100
+	if got != want {
+		t.Fatalf("ReductionPercent() = %v, want %v", got, want)
+	}
+}
+
+func TestStatistics_ReductionPercentOfEmptyInputIsZeroNotNaN(t *testing.T) {
+	var s Statistics
+	if got := s.ReductionPercent(); got != 0 {
+		t.Fatalf("ReductionPercent() of empty Statistics = %v, want 0", got)
+	}
+}
+
+func TestStatistics_MergeDedupesWarnings(t *testing.T) {
+	a := Statistics{Warnings: []string{"shared", "only in a"}}
+	b := Statistics{Warnings: []string{"shared", "only in b"}}
+
+	a.Merge(&b)
+
+	want := []string{"shared", "only in a", "only in b"}
+	if len(a.Warnings) != len(want) {
+		t.Fatalf("Warnings = %v, want %v", a.Warnings, want)
+	}
+	for i, w := range want {
+		if a.Warnings[i] != w {
+			t.Fatalf("Warnings[%d] = %q, want %q", i, a.Warnings[i], w)
+		}
+	}
+}
+
+func TestStatistics_MergeCarriesCodedWarningsWithoutDuplicating(t *testing.T) {
+	var a Statistics
+	a.AddWarningCode(WarningRotaryUnsafe, "rotary")
+	var b Statistics
+	b.AddWarningCode(WarningRotaryUnsafe, "rotary")
+	b.AddWarningCode(WarningMixedLineEndings, "mixed")
+	b.AddWarning("uncoded")
+
+	a.Merge(&b)
+
+	if len(a.CodedWarnings) != 2 {
+		t.Fatalf("CodedWarnings = %+v, want 2 entries", a.CodedWarnings)
+	}
+	if a.CodedWarnings[0].Code != WarningRotaryUnsafe || a.CodedWarnings[1].Code != WarningMixedLineEndings {
+		t.Fatalf("CodedWarnings = %+v, want RotaryUnsafe then MixedLineEndings", a.CodedWarnings)
+	}
+	want := []string{"rotary", "mixed", "uncoded"}
+	if len(a.Warnings) != len(want) {
+		t.Fatalf("Warnings = %v, want %v", a.Warnings, want)
+	}
+	for i, w := range want {
+		if a.Warnings[i] != w {
+			t.Fatalf("Warnings[%d] = %q, want %q", i, a.Warnings[i], w)
+		}
+	}
+}
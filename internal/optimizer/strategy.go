@@ -0,0 +1,51 @@
+package optimizer
+
+// Strategy selects how moves that straddle the depth threshold are
+// handled.
+type Strategy string
+
+const (
+	// StrategySafe (the default) classifies each move by its start and end Z
+	// and never splits a line; a move that starts deep and ends shallow
+	// (or vice versa) is kept or dropped as a whole.
+	StrategySafe Strategy = "safe"
+	// StrategySplit splits a move that crosses the threshold at the
+	// crossing point via SplitMove, keeping only the deep portion.
+	StrategySplit Strategy = "split"
+	// StrategyAggressive splits crossing moves exactly as StrategySplit
+	// does; Optimize removes nothing more under it than under StrategySplit.
+	StrategyAggressive Strategy = "aggressive"
+	// StrategyAllAxes extends depth filtering to consider axes beyond Z
+	// (e.g. B-axis rotation on 4-axis rotary jobs).
+	StrategyAllAxes Strategy = "all-axes"
+)
+
+func (c Config) strategy() Strategy {
+	if c.Strategy == "" {
+		return StrategySafe
+	}
+	return c.Strategy
+}
+
+func (s Strategy) splits() bool {
+	return s == StrategySplit || s == StrategyAggressive
+}
+
+// StrategyInfo describes one Strategy for user-facing listings, so
+// -strategy's accepted values stay documented from a single source instead
+// of hand-maintained help text that can drift out of sync with the enum.
+type StrategyInfo struct {
+	Strategy    Strategy
+	Description string
+}
+
+// Strategies lists every Strategy value with a one-line description of its
+// behavior and safety tradeoff, for "gcode-optimizer strategies".
+func Strategies() []StrategyInfo {
+	return []StrategyInfo{
+		{StrategySafe, "classifies each move by its start and end Z and never splits a line; the safe default, including on 4-axis rotary jobs"},
+		{StrategySplit, "splits a move that crosses the depth threshold at the crossing point, keeping only its deep portion"},
+		{StrategyAggressive, "splits crossing moves exactly like split; removes nothing more than split does"},
+		{StrategyAllAxes, "extends depth filtering to consider axes beyond Z, such as B-axis rotation on 4-axis rotary jobs"},
+	}
+}
@@ -0,0 +1,20 @@
+package optimizer
+
+import "testing"
+
+func TestStrategies_CoversEveryEnumValue(t *testing.T) {
+	want := []Strategy{StrategySafe, StrategySplit, StrategyAggressive, StrategyAllAxes}
+
+	infos := Strategies()
+	if len(infos) != len(want) {
+		t.Fatalf("Strategies() has %d entries, want %d", len(infos), len(want))
+	}
+	for i, w := range want {
+		if infos[i].Strategy != w {
+			t.Fatalf("Strategies()[%d].Strategy = %q, want %q", i, infos[i].Strategy, w)
+		}
+		if infos[i].Description == "" {
+			t.Fatalf("Strategies()[%d].Description is empty for %q", i, w)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package optimizer
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/chrisns/snapmaker-cnc-finisher/internal/gcode"
+)
+
+// TimeForMove estimates the seconds required to travel distance (in the
+// file's native units) at feedRate under the given feed mode. ok is false
+// when the feed mode doesn't describe a speed that time can be derived
+// from directly (G93 inverse-time, G95 units-per-revolution) or when no
+// usable feed rate is available.
+func TimeForMove(distance, feedRate float64, mode FeedMode) (seconds float64, ok bool) {
+	if mode != FeedPerMinute || feedRate <= 0 {
+		return 0, false
+	}
+	return distance / feedRate * 60, true
+}
+
+// Distance returns the straight-line distance between two points. Z is
+// included so plunges and retracts are accounted for, not just XY travel.
+func Distance(x1, y1, z1, x2, y2, z2 float64) float64 {
+	dx, dy, dz := x2-x1, y2-y1, z2-z1
+	return math.Sqrt(dx*dx + dy*dy + dz*dz)
+}
+
+// DistanceXY returns the straight-line distance between two points' X/Y
+// components only, ignoring Z - for a coverage estimate where plunge depth
+// doesn't contribute to swept area the way lateral travel does.
+func DistanceXY(x1, y1, x2, y2 float64) float64 {
+	dx, dy := x2-x1, y2-y1
+	return math.Sqrt(dx*dx + dy*dy)
+}
+
+// TimeAccumulator totals the estimated machining time represented by a set
+// of removed moves, keeping count of moves that could not be converted to
+// a time because of their active feed mode.
+type TimeAccumulator struct {
+	SecondsSaved         float64
+	SkippedFeedModeMoves int
+
+	// CalibratedFeedRate, if set (> 0), is used in place of each move's
+	// own feed rate/mode. See CalibratedFeedRate for how it's derived.
+	CalibratedFeedRate float64
+}
+
+// Add records one removed move of the given distance under state s.
+func (t *TimeAccumulator) Add(distance float64, s *State) {
+	secs, ok := CalculateTimeSaved(distance, s, t.CalibratedFeedRate)
+	if !ok {
+		t.SkippedFeedModeMoves++
+		return
+	}
+	t.SecondsSaved += secs
+}
+
+// DefaultFeedRate is a reasonable default cutting feed rate, in mm/min,
+// used as -default-feed's own default so -fix-feed is useful without
+// requiring a second flag. It has no effect on Optimize/OptimizeContext
+// itself: Config.DefaultFeed's zero value still means "no fallback" to any
+// caller of the package, exactly as FixFeed documents; only the CLI
+// substitutes this constant when the user doesn't set -default-feed.
+const DefaultFeedRate = 1000.0
+
+// CalculateTimeSaved estimates the seconds represented by skipping a move
+// of the given distance under state s. When calibratedFeedRate is > 0, it's
+// used directly instead of s's own feed rate/mode, since a calibrated rate
+// reflects the machine's measured real-world performance across the whole
+// job rather than a single literal F value.
+func CalculateTimeSaved(distance float64, s *State, calibratedFeedRate float64) (seconds float64, ok bool) {
+	if calibratedFeedRate > 0 {
+		return distance / calibratedFeedRate * 60, true
+	}
+	return TimeForMove(distance, s.FeedRate, s.FeedMode)
+}
+
+// CalibratedFeedRate derives an effective mm/min feed rate from a header's
+// stated estimated_time and the program's total travel distance, so
+// time-saved reporting can reflect the machine's real measured performance
+// instead of literal F values (which undercount acceleration, jerk, and
+// lookahead effects). ok is false when either input is non-positive.
+func CalibratedFeedRate(totalDistance, estimatedTimeSeconds float64) (feedRate float64, ok bool) {
+	if totalDistance <= 0 || estimatedTimeSeconds <= 0 {
+		return 0, false
+	}
+	return totalDistance / (estimatedTimeSeconds / 60), true
+}
+
+// TotalDistance sums the straight-line travel distance of every G0/G1/G2/G3
+// move in r, for use as CalibratedFeedRate's totalDistance input. It makes
+// its own forward pass with a fresh State, independent of any Optimize run.
+func TotalDistance(r io.Reader) (float64, error) {
+	state := NewState()
+	var total float64
+
+	br := bufio.NewReaderSize(r, 64*1024)
+	lineNo := 0
+	for {
+		raw, terminated, err := readLine(br)
+		if err != nil {
+			return total, err
+		}
+		if raw == "" && !terminated {
+			break
+		}
+		lineNo++
+
+		line := gcode.Parse(strings.TrimSuffix(raw, "\r"), lineNo)
+		prevX, prevY, prevZ := state.X, state.Y, state.Z
+		UpdateState(state, line)
+
+		switch line.Code {
+		case "G0", "G1", "G2", "G3":
+			total += Distance(prevX, prevY, prevZ, state.X, state.Y, state.Z)
+		}
+	}
+
+	return total, nil
+}
+
+// DepthPercentile scans every G1/G2/G3 cutting move in r and returns the Z
+// value below which pct percent of moves (by end Z, deepest first) fall, for
+// Config.Floor's safety backstop. It makes its own forward pass with a
+// fresh State, independent of any Optimize run, buffering every move's end Z
+// since a percentile can't be derived online the way ZRange's min/max can.
+// ok is false if r has no G1/G2/G3 cutting moves or pct isn't in (0, 100].
+func DepthPercentile(r io.Reader, pct float64) (threshold float64, ok bool, err error) {
+	if pct <= 0 || pct > 100 {
+		return 0, false, nil
+	}
+
+	state := NewState()
+	var zs []float64
+
+	br := bufio.NewReaderSize(r, 64*1024)
+	lineNo := 0
+	for {
+		raw, terminated, rerr := readLine(br)
+		if rerr != nil {
+			return 0, false, rerr
+		}
+		if raw == "" && !terminated {
+			break
+		}
+		lineNo++
+
+		line := gcode.Parse(strings.TrimSuffix(raw, "\r"), lineNo)
+		UpdateState(state, line)
+
+		switch line.Code {
+		case "G1", "G2", "G3":
+			// G0 is deliberately excluded, matching ZRange: a rapid can
+			// pre-position anywhere, including well below the deepest real
+			// cut, and folding that into the population would drag the
+			// computed percentile down with it - weakening the floor it's
+			// meant to protect the actual cutting moves with.
+			zs = append(zs, state.Z)
+		}
+	}
+	if len(zs) == 0 {
+		return 0, false, nil
+	}
+
+	sort.Float64s(zs)
+	idx := int(math.Ceil(float64(len(zs))*pct/100)) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	if idx >= len(zs) {
+		idx = len(zs) - 1
+	}
+	return zs[idx], true, nil
+}
+
+// ZRange scans every G1/G2/G3 cutting move in r and returns the lowest and
+// highest Z reached, for validating a requested allowance against the
+// program's actual cut depth before optimizing. It makes its own forward
+// pass with a fresh State, independent of any Optimize run. ok is false if r
+// has no cutting moves at all.
+func ZRange(r io.Reader) (minZ, maxZ float64, ok bool, err error) {
+	state := NewState()
+
+	br := bufio.NewReaderSize(r, 64*1024)
+	lineNo := 0
+	for {
+		raw, terminated, rerr := readLine(br)
+		if rerr != nil {
+			return 0, 0, false, rerr
+		}
+		if raw == "" && !terminated {
+			break
+		}
+		lineNo++
+
+		line := gcode.Parse(strings.TrimSuffix(raw, "\r"), lineNo)
+		UpdateState(state, line)
+
+		switch line.Code {
+		case "G1", "G2", "G3":
+			// G0 is deliberately excluded: a rapid can pre-position
+			// anywhere, including well below the deepest real cut, and
+			// folding that into the range would skew anything derived
+			// from it - such as the allowance-vs-cut-depth warning this
+			// feeds - around positioning moves rather than actual
+			// material removal.
+			if !ok {
+				minZ, maxZ, ok = state.Z, state.Z, true
+				continue
+			}
+			if state.Z < minZ {
+				minZ = state.Z
+			}
+			if state.Z > maxZ {
+				maxZ = state.Z
+			}
+		}
+	}
+
+	return minZ, maxZ, ok, nil
+}
@@ -0,0 +1,36 @@
+package optimizer
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestZRange_IgnoresRapidPositioningDepth(t *testing.T) {
+	// The G0 pre-positions far deeper (Z-50) than any actual cut; minZ
+	// should still reflect only the cutting moves, not the rapid.
+	input := "G0 X0 Z-50\nG1 X1 Z-2 F300\nG1 X2 Z-1 F300\n"
+
+	minZ, maxZ, ok, err := ZRange(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("ZRange: %v", err)
+	}
+	if !ok || minZ != -2 || maxZ != -1 {
+		t.Fatalf("ZRange = [%v,%v] (ok=%v), want [-2,-1] (the G0's Z-50 ignored)", minZ, maxZ, ok)
+	}
+}
+
+func TestDepthPercentile_IgnoresRapidPositioningDepth(t *testing.T) {
+	// The G0 pre-positions far deeper (Z-50) than any real cut; if it were
+	// included in the population, it alone would drag the 50% threshold
+	// down to Z-50. It should instead be ignored, leaving the same
+	// threshold as for the three cutting moves on their own.
+	input := "G0 X0 Z-50\nG1 X1 Z-1 F300\nG1 X2 Z-2 F300\nG1 X3 Z-3 F300\n"
+
+	threshold, ok, err := DepthPercentile(strings.NewReader(input), 50)
+	if err != nil {
+		t.Fatalf("DepthPercentile: %v", err)
+	}
+	if !ok || threshold != -2 {
+		t.Fatalf("DepthPercentile = %v (ok=%v), want -2 (the G0's Z-50 ignored)", threshold, ok)
+	}
+}
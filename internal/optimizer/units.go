@@ -0,0 +1,42 @@
+package optimizer
+
+import (
+	"bufio"
+	"io"
+	"strings"
+
+	"github.com/chrisns/snapmaker-cnc-finisher/internal/gcode"
+)
+
+// InitialUnits scans r for the first G20/G21 directive and returns the
+// units it selects, defaulting to UnitsMillimeters (G21) if neither
+// appears before EOF - the same default UpdateState applies to a fresh
+// State. It's a forward pre-pass like ZRange and TotalDistance, meant to
+// be run once against a seekable input before the main Optimize pass, so
+// a value given in a fixed unit (e.g. a -allowance parsed by
+// cli.ParseAllowance) can be converted into whatever units the file
+// itself is written in.
+func InitialUnits(r io.Reader) (Units, error) {
+	state := NewState()
+
+	br := bufio.NewReaderSize(r, 64*1024)
+	lineNo := 0
+	for {
+		raw, terminated, err := readLine(br)
+		if err != nil {
+			return UnitsMillimeters, err
+		}
+		if raw == "" && !terminated {
+			break
+		}
+		lineNo++
+
+		line := gcode.Parse(strings.TrimSuffix(raw, "\r"), lineNo)
+		UpdateState(state, line)
+
+		if line.Code == "G20" || line.Code == "G21" {
+			return state.Units, nil
+		}
+	}
+	return state.Units, nil
+}
@@ -0,0 +1,26 @@
+package optimizer
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestInitialUnits_DefaultsToMillimetersWithoutG20G21(t *testing.T) {
+	got, err := InitialUnits(strings.NewReader("G1 X1 Y1 F300\n"))
+	if err != nil {
+		t.Fatalf("InitialUnits: %v", err)
+	}
+	if got != UnitsMillimeters {
+		t.Fatalf("InitialUnits = %v, want UnitsMillimeters", got)
+	}
+}
+
+func TestInitialUnits_DetectsG20Inches(t *testing.T) {
+	got, err := InitialUnits(strings.NewReader("G20\nG1 X1 Y1 F300\n"))
+	if err != nil {
+		t.Fatalf("InitialUnits: %v", err)
+	}
+	if got != UnitsInches {
+		t.Fatalf("InitialUnits = %v, want UnitsInches", got)
+	}
+}
@@ -0,0 +1,102 @@
+package optimizer
+
+// WarningCode identifies the kind of condition a Warning reports, so a
+// caller embedding Optimize can act on specific warnings programmatically
+// instead of pattern-matching Statistics.Warnings message text.
+type WarningCode int
+
+const (
+	// WarningUnknown is never produced by Optimize itself; it's the zero
+	// value for a Warning constructed without a recognized code.
+	WarningUnknown WarningCode = iota
+	// WarningRotaryUnsafe is a non-safe Strategy used on a 4-axis job.
+	WarningRotaryUnsafe
+	// WarningAllowanceExceedsDepth is Allowance meeting or exceeding the
+	// toolpath's full cut depth.
+	WarningAllowanceExceedsDepth
+	// WarningMovesOnlyNotRunnable is Config.MovesOnly stripping comments
+	// and M-codes from the output.
+	WarningMovesOnlyNotRunnable
+	// WarningMixedLineEndings is the input mixing CRLF and LF terminators.
+	WarningMixedLineEndings
+	// WarningNoSpindleBeforeCut is a cutting move seen before any M3/M4.
+	WarningNoSpindleBeforeCut
+	// WarningUnsafeRapid is a G0 rapid that moves in X/Y while diving
+	// below the reference Z.
+	WarningUnsafeRapid
+	// WarningSkippedFeedMode is a move whose active feed mode (G93/G95)
+	// can't be converted into a time estimate.
+	WarningSkippedFeedMode
+	// WarningFeedRateClamped is one or more F words capped to MaxFeed.
+	WarningFeedRateClamped
+	// WarningOutOfBounds is an emitted move falling outside the header's
+	// declared work area.
+	WarningOutOfBounds
+	// WarningSpringPassBufferCapped is SpringPass's buffered final
+	// contour exceeding springPassMaxBufferedLines.
+	WarningSpringPassBufferCapped
+	// WarningFileTotalLinesMismatch is the header's declared
+	// file_total_lines disagreeing with the number of lines actually seen.
+	WarningFileTotalLinesMismatch
+	// WarningNonCNCToolHead is the header's declared header_type naming a
+	// tool head other than CNC (e.g. laser or 3D printing).
+	WarningNonCNCToolHead
+	// WarningOscillatingPlunge is a cutting run's Z reversing direction
+	// instead of descending (or ascending) monotonically, see
+	// Config.CheckPlunge.
+	WarningOscillatingPlunge
+	// WarningInvalidFeedRate is a motion line's F word that's zero or
+	// negative, see Config.FixFeed.
+	WarningInvalidFeedRate
+	// WarningArcDepthImprecise is a G2/G3 arc filtered under a non-safe
+	// Strategy: depth filtering only looks at the arc's endpoint Z, which
+	// ignores mid-arc depth variation on a helical arc.
+	WarningArcDepthImprecise
+)
+
+// String implements fmt.Stringer, returning the code's machine-readable
+// name rather than a human sentence (see Warning.Message for that).
+func (c WarningCode) String() string {
+	switch c {
+	case WarningRotaryUnsafe:
+		return "RotaryUnsafe"
+	case WarningAllowanceExceedsDepth:
+		return "AllowanceExceedsDepth"
+	case WarningMovesOnlyNotRunnable:
+		return "MovesOnlyNotRunnable"
+	case WarningMixedLineEndings:
+		return "MixedLineEndings"
+	case WarningNoSpindleBeforeCut:
+		return "NoSpindleBeforeCut"
+	case WarningUnsafeRapid:
+		return "UnsafeRapid"
+	case WarningSkippedFeedMode:
+		return "SkippedFeedMode"
+	case WarningFeedRateClamped:
+		return "FeedRateClamped"
+	case WarningOutOfBounds:
+		return "OutOfBounds"
+	case WarningSpringPassBufferCapped:
+		return "SpringPassBufferCapped"
+	case WarningFileTotalLinesMismatch:
+		return "FileTotalLinesMismatch"
+	case WarningNonCNCToolHead:
+		return "NonCNCToolHead"
+	case WarningOscillatingPlunge:
+		return "OscillatingPlunge"
+	case WarningInvalidFeedRate:
+		return "InvalidFeedRate"
+	case WarningArcDepthImprecise:
+		return "ArcDepthImprecise"
+	default:
+		return "Unknown"
+	}
+}
+
+// Warning is a single condition Optimize flagged during a run, pairing a
+// machine-matchable Code with the human-readable Message that also appears
+// in Statistics.Warnings.
+type Warning struct {
+	Code    WarningCode
+	Message string
+}
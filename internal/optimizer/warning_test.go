@@ -0,0 +1,115 @@
+package optimizer
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/chrisns/snapmaker-cnc-finisher/internal/gcode"
+)
+
+func TestWarningCode_StringReturnsMachineReadableName(t *testing.T) {
+	cases := map[WarningCode]string{
+		WarningRotaryUnsafe:     "RotaryUnsafe",
+		WarningMixedLineEndings: "MixedLineEndings",
+		WarningCode(999):        "Unknown",
+	}
+	for code, want := range cases {
+		if got := code.String(); got != want {
+			t.Errorf("%d.String() = %q, want %q", code, got, want)
+		}
+	}
+}
+
+func hasCodedWarning(warnings []Warning, code WarningCode) bool {
+	for _, w := range warnings {
+		if w.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+func TestOptimize_RotaryUnsafeFixtureProducesCodedWarning(t *testing.T) {
+	input := "G1 X10 B90 Z0.5 F300\n"
+
+	out := &bytes.Buffer{}
+	stats, err := Optimize(strings.NewReader(input), out, Config{
+		Allowance: 0, Reference: ReferenceSurface, ReferenceZ: 0,
+		Strategy: StrategyAggressive, Metadata: gcode.Metadata{Is4Axis: true}, Force: true,
+	})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+	if !hasCodedWarning(stats.CodedWarnings, WarningRotaryUnsafe) {
+		t.Fatalf("CodedWarnings = %+v, want a WarningRotaryUnsafe entry", stats.CodedWarnings)
+	}
+}
+
+func TestOptimize_MixedLineEndingsFixtureProducesCodedWarning(t *testing.T) {
+	input := "G0 Z5\r\nG1 Z-1 F300\nM5\r\n"
+
+	out := &bytes.Buffer{}
+	stats, err := Optimize(strings.NewReader(input), out, Config{NormalizeEndings: "crlf"})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+	if !hasCodedWarning(stats.CodedWarnings, WarningMixedLineEndings) {
+		t.Fatalf("CodedWarnings = %+v, want a WarningMixedLineEndings entry", stats.CodedWarnings)
+	}
+}
+
+func TestOptimize_CheckPlungeWarnsOnOscillatingZWithinACuttingRun(t *testing.T) {
+	input := "G1 X1 Z-1 F300\nG1 X2 Z-0.5 F300\nG1 X3 Z-1 F300\n"
+
+	out := &bytes.Buffer{}
+	stats, err := Optimize(strings.NewReader(input), out, Config{CheckPlunge: true})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+	if !hasCodedWarning(stats.CodedWarnings, WarningOscillatingPlunge) {
+		t.Fatalf("CodedWarnings = %+v, want a WarningOscillatingPlunge entry", stats.CodedWarnings)
+	}
+}
+
+func TestOptimize_CheckPlungeDoesNotFlagARampedDescent(t *testing.T) {
+	input := "G1 X1 Z-0.2 F300\nG1 X2 Z-0.6 F300\nG1 X3 Z-1 F300\n"
+
+	out := &bytes.Buffer{}
+	stats, err := Optimize(strings.NewReader(input), out, Config{CheckPlunge: true})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+	if hasCodedWarning(stats.CodedWarnings, WarningOscillatingPlunge) {
+		t.Fatalf("CodedWarnings = %+v, want no WarningOscillatingPlunge entry for a monotonic descent", stats.CodedWarnings)
+	}
+}
+
+func TestOptimize_CheckPlungeResetsRunOnARapid(t *testing.T) {
+	// A G0 between the two cutting runs means each descends from its own
+	// starting Z, so the second run starting shallower than the first
+	// retract isn't a reversal within a single plunge.
+	input := "G1 X1 Z-1 F300\nG0 Z5\nG1 X2 Z-1 F300\nG1 X3 Z-2 F300\n"
+
+	out := &bytes.Buffer{}
+	stats, err := Optimize(strings.NewReader(input), out, Config{CheckPlunge: true})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+	if hasCodedWarning(stats.CodedWarnings, WarningOscillatingPlunge) {
+		t.Fatalf("CodedWarnings = %+v, want no WarningOscillatingPlunge entry across a G0-separated run", stats.CodedWarnings)
+	}
+}
+
+func TestOptimize_NoSpindleBeforeCutFixtureProducesCodedWarning(t *testing.T) {
+	input := "G1 X10 Z-1 F300\nM3 S1000\nG1 X20 Z-1 F300\n"
+
+	out := &bytes.Buffer{}
+	stats, err := Optimize(strings.NewReader(input), out, Config{})
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+	if !hasCodedWarning(stats.CodedWarnings, WarningNoSpindleBeforeCut) {
+		t.Fatalf("CodedWarnings = %+v, want a WarningNoSpindleBeforeCut entry", stats.CodedWarnings)
+	}
+}
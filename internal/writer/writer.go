@@ -0,0 +1,159 @@
+// Package writer renders gcode.Lines back to text. It exists as its own
+// package so output formatting can be exercised with a plain io.Writer
+// (e.g. a bytes.Buffer) instead of a real file.
+package writer
+
+import (
+	"bufio"
+	"hash"
+	"hash/crc32"
+	"io"
+
+	"github.com/chrisns/snapmaker-cnc-finisher/internal/gcode"
+)
+
+// bufferSize bounds how much output WriteLine can hold before it must
+// flush to the underlying io.Writer. Using a fixed size (rather than
+// bufio's smaller 4096-byte default) keeps memory use predictable however
+// many lines a run produces - including Config.SplitSize, which can emit
+// more lines than it reads - while a slow or backpressured underlying
+// writer still only ever blocks Write, the same as writing directly to it
+// would; bufio.Writer buffers, it never grows unboundedly.
+const bufferSize = 64 * 1024
+
+// Writer buffers and writes gcode.Lines to an underlying io.Writer.
+type Writer struct {
+	bw      *bufio.Writer
+	newline string
+
+	// finalNewline controls whether the terminator after the very last
+	// line written is actually emitted, see SetFinalNewline.
+	finalNewline bool
+	// pendingNewline is true once a line has been written whose terminator
+	// hasn't been emitted yet. It's deferred this way - rather than
+	// writing it eagerly, the way the line's own text is - so it can be
+	// dropped entirely if that line turns out to be the last one written
+	// and finalNewline is false.
+	pendingNewline bool
+
+	crc          hash.Hash32
+	lineCount    int
+	bytesWritten int64
+}
+
+// NewWriter returns a Writer that writes to w, terminating each line with "\n".
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{bw: bufio.NewWriterSize(w, bufferSize), newline: "\n", finalNewline: true}
+}
+
+// SetNewline overrides the line terminator written after each line (e.g.
+// "\r\n" to force CRLF output). The default is "\n".
+func (w *Writer) SetNewline(newline string) {
+	w.newline = newline
+}
+
+// Newline reports the line terminator currently configured, so a second
+// Writer can be kept consistent with a primary one.
+func (w *Writer) Newline() string {
+	return w.newline
+}
+
+// SetFinalNewline controls whether the very last line written to w ends up
+// terminated: pass false for byte-exact passthrough of an input whose own
+// last line lacked a trailing newline. It only takes effect if nothing else
+// is written afterward - any further WriteLine call still terminates the
+// line that came before it as usual, so a trailer added after the "final"
+// line (a -checksum footer, a -spring-pass repeat) still gets one. The
+// default is true, matching historical behavior.
+func (w *Writer) SetFinalNewline(present bool) {
+	w.finalNewline = present
+}
+
+// flushPendingNewline emits the terminator deferred by the previous
+// WriteLine call, if any.
+func (w *Writer) flushPendingNewline() error {
+	if !w.pendingNewline {
+		return nil
+	}
+	n, err := w.bw.WriteString(w.newline)
+	w.bytesWritten += int64(n)
+	if err != nil {
+		return err
+	}
+	if w.crc != nil {
+		w.crc.Write([]byte(w.newline))
+	}
+	w.pendingNewline = false
+	return nil
+}
+
+// WriteLine renders l via gcode.Line.String (its original text if
+// untouched, or a rebuilt "CODE PARAM... ;comment" form, space-separated,
+// if Synthesized) and writes it, deferring its line terminator until the
+// next WriteLine or Flush call (see SetFinalNewline).
+func (w *Writer) WriteLine(l gcode.Line) error {
+	if err := w.flushPendingNewline(); err != nil {
+		return err
+	}
+	text := l.String()
+	n, err := w.bw.WriteString(text)
+	w.bytesWritten += int64(n)
+	if err != nil {
+		return err
+	}
+	if w.crc != nil {
+		w.crc.Write([]byte(text))
+		w.lineCount++
+	}
+	w.pendingNewline = true
+	return nil
+}
+
+// BytesWritten returns the total number of bytes handed to the underlying
+// io.Writer so far (including buffered-but-not-yet-flushed bytes), for a
+// caller that wants an accurate output size without relying on a
+// file.Stat() that doesn't work for stdout or other non-file writers.
+func (w *Writer) BytesWritten() int64 {
+	return w.bytesWritten
+}
+
+// EnableChecksum turns on incremental CRC32 (IEEE) tracking over every
+// line written from this point on, plus a running count of those lines,
+// for a --checksum trailer footer. It's a no-op to call more than once.
+func (w *Writer) EnableChecksum() {
+	if w.crc == nil {
+		w.crc = crc32.NewIEEE()
+	}
+}
+
+// Checksum returns the CRC32 (IEEE) of every line written since
+// EnableChecksum was called (including line terminators). Returns 0 if
+// EnableChecksum was never called. Any terminator still deferred by the
+// last WriteLine call is flushed first, since a caller reading Checksum
+// always does so to report on lines that are about to be followed by more
+// output (a -checksum footer), so that terminator is never in question.
+func (w *Writer) Checksum() uint32 {
+	if w.crc == nil {
+		return 0
+	}
+	w.flushPendingNewline()
+	return w.crc.Sum32()
+}
+
+// LineCount returns how many lines have been written since EnableChecksum
+// was called. Returns 0 if EnableChecksum was never called.
+func (w *Writer) LineCount() int {
+	return w.lineCount
+}
+
+// Flush writes any buffered data to the underlying io.Writer, including the
+// terminator deferred by the last WriteLine call unless SetFinalNewline(false)
+// left it suppressed.
+func (w *Writer) Flush() error {
+	if w.finalNewline {
+		if err := w.flushPendingNewline(); err != nil {
+			return err
+		}
+	}
+	return w.bw.Flush()
+}
@@ -0,0 +1,125 @@
+package writer_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/chrisns/snapmaker-cnc-finisher/internal/gcode"
+	"github.com/chrisns/snapmaker-cnc-finisher/internal/optimizer"
+	"github.com/chrisns/snapmaker-cnc-finisher/internal/writer"
+)
+
+func TestWriteLine_PassThrough(t *testing.T) {
+	var buf bytes.Buffer
+	w := writer.NewWriter(&buf)
+
+	line := gcode.Parse("G1 X1.000 Y2.000 ; cut", 1)
+	if err := w.WriteLine(line); err != nil {
+		t.Fatalf("WriteLine: %v", err)
+	}
+	w.Flush()
+
+	want := "G1 X1.000 Y2.000 ; cut\n"
+	if buf.String() != want {
+		t.Fatalf("got %q, want %q", buf.String(), want)
+	}
+}
+
+func TestWriteLine_SplitMoveRoundTrips(t *testing.T) {
+	move := gcode.Parse("G1 X10 Y0 Z-4 F300", 1)
+	line1, line2 := optimizer.SplitMove(move, 0, 0, 1, -1, 4)
+
+	var buf bytes.Buffer
+	w := writer.NewWriter(&buf)
+	if err := w.WriteLine(line1); err != nil {
+		t.Fatalf("WriteLine: %v", err)
+	}
+	if err := w.WriteLine(line2); err != nil {
+		t.Fatalf("WriteLine: %v", err)
+	}
+	w.Flush()
+
+	want := "G1 X4.0000 Y0.0000 Z-1.0000 F300\nG1 X10.0000 Y0.0000 Z-4.0000 F300\n"
+	if buf.String() != want {
+		t.Fatalf("got %q, want %q", buf.String(), want)
+	}
+}
+
+// slowWriter wraps a bytes.Buffer but records how many times Write was
+// called, simulating a backpressured sink (e.g. a slow pipe) that a real
+// bufio.Writer would block on rather than buffer past.
+type slowWriter struct {
+	bytes.Buffer
+	writeCalls int
+}
+
+func (s *slowWriter) Write(p []byte) (int, error) {
+	s.writeCalls++
+	return s.Buffer.Write(p)
+}
+
+func TestWriteLine_FlushesThroughToASlowUnderlyingWriter(t *testing.T) {
+	sw := &slowWriter{}
+	w := writer.NewWriter(sw)
+
+	for i := 0; i < 10; i++ {
+		if err := w.WriteLine(gcode.Parse("G1 X1 F300", i+1)); err != nil {
+			t.Fatalf("WriteLine: %v", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	if sw.writeCalls == 0 {
+		t.Fatal("underlying writer never received any Write call")
+	}
+	want := int64(sw.Len())
+	if w.BytesWritten() != want {
+		t.Fatalf("BytesWritten() = %d, want %d", w.BytesWritten(), want)
+	}
+}
+
+func TestSetFinalNewline_SuppressesOnlyTheLastLinesTerminator(t *testing.T) {
+	var buf bytes.Buffer
+	w := writer.NewWriter(&buf)
+	w.SetFinalNewline(false)
+
+	if err := w.WriteLine(gcode.Parse("G1 X1 F300", 1)); err != nil {
+		t.Fatalf("WriteLine: %v", err)
+	}
+	if err := w.WriteLine(gcode.Parse("G1 X2 F300", 2)); err != nil {
+		t.Fatalf("WriteLine: %v", err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	want := "G1 X1 F300\nG1 X2 F300"
+	if buf.String() != want {
+		t.Fatalf("got %q, want %q", buf.String(), want)
+	}
+}
+
+func TestSetFinalNewline_IsOverriddenByALineWrittenAfterward(t *testing.T) {
+	var buf bytes.Buffer
+	w := writer.NewWriter(&buf)
+	w.SetFinalNewline(false)
+
+	if err := w.WriteLine(gcode.Parse("G1 X1 F300", 1)); err != nil {
+		t.Fatalf("WriteLine: %v", err)
+	}
+	// A trailer (e.g. a -checksum footer) written after the "final" line
+	// still terminates the line that came before it.
+	if err := w.WriteLine(gcode.Parse("; checksum: crc32=00000000 lines=1", 2)); err != nil {
+		t.Fatalf("WriteLine: %v", err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	want := "G1 X1 F300\n; checksum: crc32=00000000 lines=1"
+	if buf.String() != want {
+		t.Fatalf("got %q, want %q", buf.String(), want)
+	}
+}